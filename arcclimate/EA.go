@@ -120,9 +120,9 @@ func (msm *MsmTarget) groupForTempCI() map[YearMonth]GroupDataForTempCI {
 		index_m[m] = make([]int, 0, int(len(msm.date)/11))
 	}
 
-	//年月インデックス領域確保
-	index_ym := make(map[YearMonth][]int, 10)
-	for y := 2010; y <= 2021; y++ {
+	//年月インデックス領域確保(データの期間の各年)
+	index_ym := make(map[YearMonth][]int, 12*10)
+	for y := msm.date[0].Year(); y <= msm.date[len(msm.date)-1].Year(); y++ {
 		for m := 1; m <= 12; m++ {
 			ym := YearMonth{y, m}
 			index_ym[ym] = make([]int, 0, int(len(msm.date)/11))
@@ -794,7 +794,7 @@ func (msmt *MsmTarget) patchRepYears(repYears []int) *MsmTarget {
 	}
 
 	// ベクトル風速から16方位の風向風速を再計算
-	EA.WindVectorToDirAndSpeed()
+	EA.WindVectorToDirAndSpeed(DefaultWindDirection)
 
 	return &EA
 }
@@ -0,0 +1,143 @@
+package arcclimate
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//--------------------------------------
+// 期間別アーカイブの結合
+//--------------------------------------
+
+// 複数のアーカイブで参照時刻が重複した場合に採用するデータ
+type MsmPrecedence string
+
+const (
+	PrecedenceFirst MsmPrecedence = "first" // 先に指定したアーカイブを優先
+	PrecedenceLast  MsmPrecedence = "last"  // 後に指定したアーカイブを優先
+)
+
+func ParseMsmPrecedence(s string) (MsmPrecedence, error) {
+	switch p := MsmPrecedence(s); p {
+	case PrecedenceFirst, PrecedenceLast:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown msm precedence %q (want first or last)", s)
+}
+
+// 期間別に公開されたMSMファイルのアーカイブ (例: msm_2011_2020/)
+type MsmArchive struct {
+	Name   string    // アーカイブ名。キャッシュを保存するサブディレクトリ名として使用します。
+	Source MsmSource // MSMファイルの取得元
+}
+
+// アーカイブの指定 spec ("名前=取得元" または "取得元") からアーカイブを作成します。
+// 取得元の指定は ParseMsmSource と同じです。名前を省略した場合は、URLまたはディレクトリの末尾の名前とします。
+func ParseMsmArchive(spec string) (MsmArchive, error) {
+	name := ""
+	if i := strings.Index(spec, "="); i >= 0 && !strings.Contains(spec[:i], "/") {
+		name, spec = spec[:i], spec[i+1:]
+	}
+	if spec == "" {
+		return MsmArchive{}, fmt.Errorf("empty msm archive source")
+	}
+
+	src, err := ParseMsmSource(spec)
+	if err != nil {
+		return MsmArchive{}, err
+	}
+
+	if name == "" {
+		first := strings.TrimSpace(strings.Split(spec, ",")[0])
+		if u, err := url.Parse(first); err == nil && u.Scheme != "" {
+			name = path.Base(strings.TrimSuffix(u.Path, "/"))
+		} else {
+			name = filepath.Base(filepath.Clean(first))
+		}
+	}
+
+	return MsmArchive{Name: name, Source: src}, nil
+}
+
+// 欠測期間
+type MsmGap struct {
+	After  time.Time // 欠測直前の参照時刻
+	Before time.Time // 欠測直後の参照時刻
+}
+
+// 結合したMSMデータに欠測期間があることを表すエラー
+type MsmGapError struct {
+	Name string // メッシュ地点番号
+	Gaps []MsmGap
+}
+
+func (e *MsmGapError) Error() string {
+	gaps := make([]string, len(e.Gaps))
+	for i, g := range e.Gaps {
+		gaps[i] = fmt.Sprintf("%s - %s", g.After.Format("2006-01-02 15:04"), g.Before.Format("2006-01-02 15:04"))
+	}
+	return fmt.Sprintf("%s: %d gaps in merged msm data: %s", msmFileName(e.Name), len(e.Gaps), strings.Join(gaps, ", "))
+}
+
+// アーカイブ別に読み込んだ同一地点のMSMデータ parts (アーカイブの指定順) を参照時刻順に結合します。
+// 重複する参照時刻のデータは precedence に従って採用し、結合後に欠測がある場合は *MsmGapError を返します。
+func mergeMsm(name string, parts []MsmData, precedence MsmPrecedence) (MsmData, error) {
+	type archiveRow struct {
+		archive int
+		row     MsmDataRow
+	}
+
+	n := 0
+	for _, part := range parts {
+		n += part.Length()
+	}
+	all := make([]archiveRow, 0, n)
+	for i, part := range parts {
+		for _, row := range part.Rows {
+			all = append(all, archiveRow{i, row})
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].row.date.Before(all[j].row.date)
+	})
+
+	rows := make([]MsmDataRow, 0, n)
+	overlaps := 0
+	var prev archiveRow
+	for i, r := range all {
+		if i > 0 && r.row.date.Equal(prev.row.date) {
+			// 参照時刻が重複
+			overlaps++
+			if precedence == PrecedenceLast && r.archive > prev.archive ||
+				precedence == PrecedenceFirst && r.archive < prev.archive {
+				rows[len(rows)-1] = r.row
+				prev = r
+			}
+			continue
+		}
+		rows = append(rows, r.row)
+		prev = r
+	}
+	if overlaps > 0 {
+		log.Printf("MSM結合 %s: 重複 %d 時間 (優先: %s)", name, overlaps, precedence)
+	}
+
+	// 欠測の確認
+	gaps := []MsmGap{}
+	for i := 1; i < len(rows); i++ {
+		if rows[i].date.Sub(rows[i-1].date) != time.Hour {
+			gaps = append(gaps, MsmGap{After: rows[i-1].date, Before: rows[i].date})
+		}
+	}
+	if len(gaps) > 0 {
+		return MsmData{}, &MsmGapError{Name: name, Gaps: gaps}
+	}
+
+	return MsmData{name: name, Rows: rows}, nil
+}
@@ -0,0 +1,104 @@
+package arcclimate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LoadMsmArchives(t *testing.T) {
+	start := time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// 旧アーカイブ: 0-47時, 新アーカイブ: 24-71時 (24時間重複)
+	older := NewMemoryMsmSource()
+	older.Put("238-315", makeMsmGz(start, 48, 0.0))
+	newer := NewMemoryMsmSource()
+	newer.Put("238-315", makeMsmGz(start.Add(24*time.Hour), 48, 1000.0))
+	srcs := []MsmSource{older, newer}
+
+	msms, err := LoadMsmArchives(context.Background(), []string{"238-315"}, srcs, PrecedenceLast, MsmWindow{})
+	assert.NoError(t, err)
+	msm := msms.Data[0]
+	assert.Equal(t, 72, msm.Length())
+	assert.Equal(t, start, msm.Rows[0].date)
+	assert.Equal(t, 23.0, msm.Rows[23].TMP)
+	assert.Equal(t, 1000.0, msm.Rows[24].TMP)
+
+	msms, err = LoadMsmArchives(context.Background(), []string{"238-315"}, srcs, PrecedenceFirst, MsmWindow{})
+	assert.NoError(t, err)
+	assert.Equal(t, 72, msms.Data[0].Length())
+	assert.Equal(t, 24.0, msms.Data[0].Rows[24].TMP)
+	assert.Equal(t, 1024.0, msms.Data[0].Rows[48].TMP)
+
+	// 一方のアーカイブにのみ存在する地点
+	older.Put("238-316", makeMsmGz(start, 48, 0.0))
+	msms, err = LoadMsmArchives(context.Background(), []string{"238-316"}, srcs, PrecedenceLast, MsmWindow{})
+	assert.NoError(t, err)
+	assert.Equal(t, 48, msms.Data[0].Length())
+
+	// いずれのアーカイブにも存在しない地点
+	_, err = LoadMsmArchives(context.Background(), []string{"238-317"}, srcs, PrecedenceLast, MsmWindow{})
+	assert.ErrorIs(t, err, ErrMsmNotFound)
+}
+
+func Test_LoadMsmArchives_Gap(t *testing.T) {
+	start := time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	older := NewMemoryMsmSource()
+	older.Put("238-315", makeMsmGz(start, 24, 0.0))
+	newer := NewMemoryMsmSource()
+	newer.Put("238-315", makeMsmGz(start.Add(30*time.Hour), 24, 0.0))
+
+	_, err := LoadMsmArchives(context.Background(), []string{"238-315"}, []MsmSource{older, newer}, PrecedenceLast, MsmWindow{})
+	var gapErr *MsmGapError
+	assert.True(t, errors.As(err, &gapErr))
+	assert.Equal(t, []MsmGap{{After: start.Add(23 * time.Hour), Before: start.Add(30 * time.Hour)}}, gapErr.Gaps)
+}
+
+func Test_ParseMsmArchive(t *testing.T) {
+	a, err := ParseMsmArchive("https://example.com/arcclimate-ja/msm_2021_2023/")
+	assert.NoError(t, err)
+	assert.Equal(t, "msm_2021_2023", a.Name)
+
+	a, err = ParseMsmArchive("new=https://a.example/msm,https://b.example/msm")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", a.Name)
+	assert.Equal(t, []string{"https://a.example/msm/", "https://b.example/msm/"}, a.Source.(*HTTPMsmSource).BaseURLs)
+
+	dir := t.TempDir()
+	a, err = ParseMsmArchive(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, dir, a.Source.(*DirMsmSource).Dir)
+
+	_, err = ParseMsmArchive("old=")
+	assert.Error(t, err)
+}
+
+func Test_InterpolateWithOptions_Archives(t *testing.T) {
+	lat, lon := 35.658, 139.741
+	older := NewMemoryMsmSource()
+	newer := NewMemoryMsmSource()
+	for _, name := range RequiredMsmList(lat, lon) {
+		older.Put(name, makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 365*24, 0.0))
+		newer.Put(name, makeMsmGz(time.Date(2012, 1, 1, 0, 0, 0, 0, time.UTC), 366*24, 0.0))
+	}
+
+	opts := NewOptions(lat, lon)
+	opts.StartYear, opts.EndYear = 2011, 2012
+	opts.ElevationMode = ElevationMesh
+	opts.UseCache, opts.SaveCache = false, false
+	opts.Archives = []MsmArchive{{"old", older}, {"new", newer}}
+
+	res, err := InterpolateWithOptions(context.Background(), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, (365+366)*24, len(res.date))
+	assert.Equal(t, []string{"old", "new"}, res.Metadata.Archives)
+
+	// アーカイブ名の重複
+	opts.Archives = []MsmArchive{{"old", older}, {"old", newer}}
+	_, err = InterpolateWithOptions(context.Background(), opts)
+	assert.Error(t, err)
+}
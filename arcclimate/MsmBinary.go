@@ -0,0 +1,323 @@
+package arcclimate
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+//--------------------------------------
+// MSMデータのバイナリキャッシュ
+//--------------------------------------
+
+// 解析済みのMSMデータを直接読み込める取得元
+// load_msm は取得元がこのインターフェースを実装している場合、CSVの解析の代わりに LoadMsm を使用します。
+type MsmDataSource interface {
+	MsmSource
+	// メッシュ地点番号 name のMSMデータのうち期間 window (ゼロ値の場合は全期間)のデータを読み込みます。
+	LoadMsm(ctx context.Context, name string, window MsmWindow) (MsmData, error)
+}
+
+// バイナリキャッシュの形式
+//
+//	magic    [4]byte  "MSMB"
+//	version  uint32   msmBinaryVersion
+//	rows     uint32   行数 n
+//	columns  uint32   数値列の数 msmBinaryColumns
+//	sha256   [32]byte 元のMSMファイル(gzip圧縮CSV)のSHA-256
+//	hours    [n]int32 参照時刻(1970-01-01 00:00からの経過時間 [h])
+//	columns  [msmBinaryColumns][n]float64 TMP,MR,DSWRF_est,DSWRF_msm,Ld,VGRD,UGRD,PRES,APCP01 の順
+//	crc32    uint32   以上のバイト列のCRC-32(IEEE)
+//
+// 数値はすべてリトルエンディアンです。CSVから読み込んだ値と完全に一致させるため、数値列は float64 とします。
+const (
+	msmBinaryMagic   = "MSMB"
+	msmBinaryVersion = 1
+	msmBinaryColumns = 9
+	msmBinaryHeader  = 4 + 4 + 4 + 4 + sha256Size
+	sha256Size       = 32
+)
+
+// MSMファイル path のバイナリキャッシュのパス
+func binaryPath(path string) string {
+	return strings.TrimSuffix(path, ".csv.gz") + ".msmb"
+}
+
+// MSMデータ msm をバイナリキャッシュの形式に変換します。sha256hex は元のMSMファイルのSHA-256です。
+func encodeMsmBinary(msm *MsmData, sha256hex string) ([]byte, error) {
+	sum, err := hex.DecodeString(sha256hex)
+	if err != nil || len(sum) != sha256Size {
+		return nil, fmt.Errorf("invalid sha256 %q", sha256hex)
+	}
+
+	n := msm.Length()
+	b := make([]byte, msmBinaryHeader+n*4+msmBinaryColumns*n*8+4)
+	le := binary.LittleEndian
+
+	copy(b, msmBinaryMagic)
+	le.PutUint32(b[4:], msmBinaryVersion)
+	le.PutUint32(b[8:], uint32(n))
+	le.PutUint32(b[12:], msmBinaryColumns)
+	copy(b[16:], sum)
+
+	off := msmBinaryHeader
+	for _, row := range msm.Rows {
+		sec := row.date.Unix()
+		if sec%3600 != 0 {
+			return nil, fmt.Errorf("date %s is not on the hour", row.date.Format("2006-01-02 15:04:05"))
+		}
+		le.PutUint32(b[off:], uint32(int32(sec/3600)))
+		off += 4
+	}
+
+	for col := 0; col < msmBinaryColumns; col++ {
+		for i := range msm.Rows {
+			le.PutUint64(b[off:], math.Float64bits(*msmColumn(&msm.Rows[i], col)))
+			off += 8
+		}
+	}
+
+	le.PutUint32(b[off:], crc32.ChecksumIEEE(b[:off]))
+
+	return b, nil
+}
+
+// バイナリキャッシュの内容 b からメッシュ地点番号 name のMSMデータを復元します。
+// 元のMSMファイルのSHA-256も返します。
+func decodeMsmBinary(name string, b []byte) (MsmData, string, error) {
+	return decodeMsmBinaryWindow(name, b, MsmWindow{})
+}
+
+// バイナリキャッシュの内容 b から期間 window のMSMデータを復元します。
+// 期間にデータが含まれない場合は ErrOutOfPeriod をラップしたエラーを返します。
+func decodeMsmBinaryWindow(name string, b []byte, window MsmWindow) (MsmData, string, error) {
+	le := binary.LittleEndian
+
+	if len(b) < msmBinaryHeader+4 || string(b[:4]) != msmBinaryMagic {
+		return MsmData{}, "", fmt.Errorf("not a msm binary cache")
+	}
+	if v := le.Uint32(b[4:]); v != msmBinaryVersion {
+		return MsmData{}, "", fmt.Errorf("unsupported msm binary cache version %d", v)
+	}
+	n := int(le.Uint32(b[8:]))
+	if c := le.Uint32(b[12:]); c != msmBinaryColumns {
+		return MsmData{}, "", fmt.Errorf("unexpected column count %d", c)
+	}
+	if len(b) != msmBinaryHeader+n*4+msmBinaryColumns*n*8+4 {
+		return MsmData{}, "", fmt.Errorf("size mismatch: %d bytes for %d rows", len(b), n)
+	}
+	body := b[:len(b)-4]
+	if le.Uint32(b[len(body):]) != crc32.ChecksumIEEE(body) {
+		return MsmData{}, "", fmt.Errorf("checksum mismatch")
+	}
+	sha := hex.EncodeToString(b[16 : 16+sha256Size])
+
+	// 参照時刻の索引から期間の行 [lo, hi) を求める
+	hours := b[msmBinaryHeader : msmBinaryHeader+n*4]
+	date := func(i int) time.Time {
+		return time.Unix(int64(int32(le.Uint32(hours[i*4:])))*3600, 0).UTC()
+	}
+	lo := sort.Search(n, func(i int) bool { return !window.before(date(i)) })
+	hi := sort.Search(n, func(i int) bool { return window.after(date(i)) })
+	if lo >= hi {
+		if n == 0 || window.IsZero() {
+			return MsmData{name: name, Rows: []MsmDataRow{}}, sha, nil
+		}
+		return MsmData{}, "", window.outOfPeriod(date(0), date(n-1))
+	}
+
+	rows := make([]MsmDataRow, hi-lo)
+	for i := range rows {
+		rows[i].date = date(lo + i)
+	}
+	for col := 0; col < msmBinaryColumns; col++ {
+		off := msmBinaryHeader + n*4 + col*n*8 + lo*8
+		for i := range rows {
+			*msmColumn(&rows[i], col) = math.Float64frombits(le.Uint64(b[off:]))
+			off += 8
+		}
+	}
+
+	return MsmData{name: name, Rows: rows}, sha, nil
+}
+
+// バイナリキャッシュの col 列目の値
+func msmColumn(row *MsmDataRow, col int) *float64 {
+	switch col {
+	case 0:
+		return &row.TMP
+	case 1:
+		return &row.MR
+	case 2:
+		return &row.DSWRF_est
+	case 3:
+		return &row.DSWRF_msm
+	case 4:
+		return &row.Ld
+	case 5:
+		return &row.VGRD
+	case 6:
+		return &row.UGRD
+	case 7:
+		return &row.PRES
+	default:
+		return &row.APCP01
+	}
+}
+
+// MSMファイル path のバイナリキャッシュが最新であれば読み込みます。
+// MSMファイルとマニフェストが存在し、バイナリキャッシュが同じ内容から作成されている場合に最新とみなします。
+// バイナリキャッシュが存在しない場合は os.IsNotExist で判定できるエラーを返します。
+func readFreshMsmBinary(path string, name string) (MsmData, error) {
+	return readFreshMsmBinaryWindow(path, name, MsmWindow{})
+}
+
+// readFreshMsmBinary と同様に、期間 window のデータのみを読み込みます。
+func readFreshMsmBinaryWindow(path string, name string, window MsmWindow) (MsmData, error) {
+	b, err := os.ReadFile(binaryPath(path))
+	if err != nil {
+		return MsmData{}, err
+	}
+
+	m, err := readMsmManifest(path)
+	if err != nil {
+		return MsmData{}, fmt.Errorf("manifest: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return MsmData{}, err
+	}
+	if info.Size() != m.Size {
+		return MsmData{}, fmt.Errorf("stale: %s has changed", filepath.Base(path))
+	}
+
+	if len(b) >= msmBinaryHeader && int(binary.LittleEndian.Uint32(b[8:])) != m.Rows {
+		return MsmData{}, fmt.Errorf("stale: built from a different %s", filepath.Base(path))
+	}
+	msm, sha, err := decodeMsmBinaryWindow(name, b, window)
+	if err != nil {
+		return MsmData{}, err
+	}
+	if sha != m.SHA256 {
+		return MsmData{}, fmt.Errorf("stale: built from a different %s", filepath.Base(path))
+	}
+
+	return msm, nil
+}
+
+// MSMファイル path から読み込んだデータ msm のバイナリキャッシュを保存します。
+func writeMsmBinary(path string, msm *MsmData) error {
+	m, err := readMsmManifest(path)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+	b, err := encodeMsmBinary(msm, m.SHA256)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(binaryPath(path), b)
+}
+
+func (s *CachedMsmSource) LoadMsm(ctx context.Context, name string, window MsmWindow) (MsmData, error) {
+	path := filepath.Join(s.Dir, msmFileName(name))
+
+	if s.Binary && s.UseCache {
+		msm, err := readFreshMsmBinaryWindow(path, name, window)
+		if errors.Is(err, ErrOutOfPeriod) {
+			return MsmData{}, fmt.Errorf("read %s: %w", msmFileName(name), err)
+		}
+		if err == nil {
+			countStat(&stats.MsmCacheHits, 1)
+			log.Printf("MSMバイナリキャッシュ読み込み: %s", binaryPath(path))
+			// 削除(prune)時の判定のため最終使用日時を更新する
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			return msm, nil
+		}
+		if !os.IsNotExist(err) {
+			log.Printf("MSMバイナリキャッシュ再作成 %s: %v", binaryPath(path), err)
+			os.Remove(binaryPath(path))
+		}
+	}
+
+	if !s.Binary || !s.SaveCache {
+		return parseMsmFrom(ctx, s, name, window)
+	}
+
+	// 初回の読み込み時にバイナリキャッシュを作成する(作成には全期間のデータが必要)
+	msm, err := parseMsmFrom(ctx, s, name, MsmWindow{})
+	if err != nil {
+		return MsmData{}, err
+	}
+	log.Printf("MSMバイナリキャッシュ保存 %s", binaryPath(path))
+	if err := writeMsmBinary(path, &msm); err != nil {
+		log.Printf("MSMバイナリキャッシュ保存失敗 %v", err)
+	}
+
+	return msm.window(window)
+}
+
+// キャッシュしたMSMファイル path のバイナリキャッシュを作成します。
+// 作成済みで最新の場合は何もしません。
+func ConvertCachedMsm(path string) error {
+	name := strings.TrimSuffix(filepath.Base(path), ".csv.gz")
+	if _, err := readFreshMsmBinary(path, name); err == nil {
+		return nil
+	}
+
+	b, err := readVerifiedMsm(path)
+	if err != nil {
+		return err
+	}
+	msm, err := parseMsm(name, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	return writeMsmBinary(path, &msm)
+}
+
+// キャッシュしたMSMファイル path のバイナリキャッシュが存在する場合に、
+// CSVから読み込んだデータ msm と一致するかを検証します。
+func verifyMsmBinary(path string, msm *MsmData) error {
+	bin, err := readFreshMsmBinary(path, msm.name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil && !equalMsmRows(bin.Rows, msm.Rows) {
+		err = errors.New("contents differ from csv")
+	}
+	if err != nil {
+		return fmt.Errorf("binary cache: %w", err)
+	}
+	return nil
+}
+
+// 2つのMSMデータの行が一致するか(NaN同士は一致とみなす)
+func equalMsmRows(a []MsmDataRow, b []MsmDataRow) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].date.Equal(b[i].date) {
+			return false
+		}
+		for col := 0; col < msmBinaryColumns; col++ {
+			x, y := *msmColumn(&a[i], col), *msmColumn(&b[i], col)
+			if x != y && !(math.IsNaN(x) && math.IsNaN(y)) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package arcclimate
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MsmBinary_RoundTrip(t *testing.T) {
+	data := makeMsmGzFromCSV("date,TMP,MR,DSWRF_est,DSWRF_msm,Ld,VGRD,UGRD,PRES,APCP01\n" +
+		"2011-01-01 00:00:00,1.25,5.0,0.5,,300.0,1.0,-1.0,101325.0,0.0\n" +
+		"2011-01-01 01:00:00,0.1,5.5,0.0,0.3,301.5,-2.0,3.0,101300.0,1.5\n")
+	msm, err := parseMsm("238-315", bytes.NewReader(data))
+	assert.NoError(t, err)
+
+	m, err := inspectMsmGz(data)
+	assert.NoError(t, err)
+	b, err := encodeMsmBinary(&msm, m.SHA256)
+	assert.NoError(t, err)
+
+	got, sha, err := decodeMsmBinary("238-315", b)
+	assert.NoError(t, err)
+	assert.Equal(t, m.SHA256, sha)
+	assert.True(t, equalMsmRows(msm.Rows, got.Rows))
+	assert.Equal(t, msm.Rows[1], got.Rows[1])
+	assert.True(t, math.IsNaN(got.Rows[0].DSWRF_msm))
+
+	// 破損の検出
+	b[len(b)/2] ^= 0xff
+	_, _, err = decodeMsmBinary("238-315", b)
+	assert.Error(t, err)
+}
+
+func Test_CachedMsmSource_Binary(t *testing.T) {
+	dir := t.TempDir()
+	data := makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 48, 0.0)
+	mem := NewMemoryMsmSource()
+	mem.Put("238-315", data)
+	src := &countingMsmSource{MsmSource: mem}
+	cached := &CachedMsmSource{Source: src, Dir: dir, UseCache: true, SaveCache: true, Binary: true}
+	path := filepath.Join(dir, "238-315.csv.gz")
+
+	// 初回の読み込み時にバイナリキャッシュを作成
+	msms, err := LoadMsmFiles(context.Background(), []string{"238-315"}, cached)
+	assert.NoError(t, err)
+	assert.FileExists(t, binaryPath(path))
+	want := msms.Data[0]
+
+	// 2回目はバイナリキャッシュから読み込む(CSVは開かない)
+	msms, err = LoadMsmFiles(context.Background(), []string{"238-315"}, cached)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, src.opened)
+	assert.True(t, equalMsmRows(want.Rows, msms.Data[0].Rows))
+
+	// 元のMSMファイルが変わった場合は作り直す
+	other := makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 24, 100.0)
+	m, err := inspectMsmGz(other)
+	assert.NoError(t, err)
+	assert.NoError(t, writeCachedMsm(path, other, m))
+	msms, err = LoadMsmFiles(context.Background(), []string{"238-315"}, cached)
+	assert.NoError(t, err)
+	assert.Equal(t, 24, msms.Data[0].Length())
+	assert.Equal(t, 100.0, msms.Data[0].Rows[0].TMP)
+
+	// 検証
+	_, err = VerifyCachedMsm(path)
+	assert.NoError(t, err)
+}
+
+func Test_ConvertCachedMsm(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "238-315.csv.gz")
+	assert.NoError(t, os.WriteFile(path, makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 24, 0.0), 0644))
+
+	// マニフェストの無いファイルも変換できる
+	assert.NoError(t, ConvertCachedMsm(path))
+	msm, err := readFreshMsmBinary(path, "238-315")
+	assert.NoError(t, err)
+	assert.Equal(t, 24, msm.Length())
+
+	entries, err := ListMsmCache(dir)
+	assert.NoError(t, err)
+	assert.True(t, entries[0].Binary)
+	assert.NoError(t, RemoveCachedMsm(entries[0]))
+	assert.NoFileExists(t, binaryPath(path))
+}
+
+// 2011-2020年と同じ行数のMSMファイル
+func benchmarkMsmGz() []byte {
+	return makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 87687, 0.0)
+}
+
+func Benchmark_ParseMsm_CSV(b *testing.B) {
+	data := benchmarkMsmGz()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parseMsm("238-315", bytes.NewReader(data)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_ParseMsm_Binary(b *testing.B) {
+	data := benchmarkMsmGz()
+	msm, _ := parseMsm("238-315", bytes.NewReader(data))
+	m, _ := inspectMsmGz(data)
+	bin, err := encodeMsmBinary(&msm, m.SHA256)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := decodeMsmBinary("238-315", bin); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// キャッシュからの読み込み(検証を含む)
+func benchmarkCachedLoad(b *testing.B, binary bool) {
+	dir := b.TempDir()
+	mem := NewMemoryMsmSource()
+	mem.Put("238-315", benchmarkMsmGz())
+	cached := &CachedMsmSource{Source: mem, Dir: dir, UseCache: true, SaveCache: true, Binary: binary}
+	if _, err := load_msm(context.Background(), cached, "238-315", MsmWindow{}); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := load_msm(context.Background(), cached, "238-315", MsmWindow{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_CachedMsm_CSV(b *testing.B) {
+	benchmarkCachedLoad(b, false)
+}
+
+func Benchmark_CachedMsm_Binary(b *testing.B) {
+	benchmarkCachedLoad(b, true)
+}
@@ -0,0 +1,556 @@
+package arcclimate
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//--------------------------------------
+// MSMファイルのキャッシュ
+//--------------------------------------
+
+// 取得元 Source から取得したMSMファイルをディレクトリ Dir に保存して再利用する取得元
+//
+// 保存時には一時ファイルに書き込んでから名前を変更するため、中断された場合でも壊れたファイルは残りません。
+// また、MSMファイルごとにチェックサムと行数を記録したマニフェスト {SN}-{WE}.csv.gz.manifest を保存し、
+// 読み込み時に照合します。照合に失敗したファイルは破棄して再取得します。
+//
+// Binary を true とした場合は、解析済みのデータをバイナリキャッシュ {SN}-{WE}.msmb としても保存し、
+// 次回以降はCSVの展開・解析を省略して読み込みます(LoadMsm)。
+type CachedMsmSource struct {
+	Source    MsmSource
+	Dir       string
+	UseCache  bool // Dir に保存済みのMSMファイルがあれば使用する
+	SaveCache bool // Source から取得したMSMファイルを Dir に保存する
+	Binary    bool // バイナリキャッシュを使用・作成する
+}
+
+func (s *CachedMsmSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	path := filepath.Join(s.Dir, msmFileName(name))
+
+	if s.UseCache {
+		b, err := readVerifiedMsm(path)
+		if err == nil {
+			countStat(&stats.MsmCacheHits, 1)
+			log.Printf("MSMファイル読み込み: %s", path)
+			// 削除(prune)時の判定のため最終使用日時を更新する
+			now := time.Now()
+			os.Chtimes(path, now, now)
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+		if !os.IsNotExist(err) {
+			log.Printf("MSMファイル破損 %s: %v", path, err)
+			if _, offline := s.Source.(offlineMsmSource); offline {
+				// 再取得できないため、ファイルは削除せずに照合のエラーを返す
+				return nil, fmt.Errorf("verify %s: %w", path, err)
+			}
+			// 壊れたキャッシュは破棄して再取得する
+			removeCachedMsm(path)
+		}
+	}
+
+	countStat(&stats.MsmCacheMisses, 1)
+	body, err := s.Source.Open(ctx, name)
+	if err != nil || !s.SaveCache {
+		return body, err
+	}
+	defer body.Close()
+
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", msmFileName(name), err)
+	}
+
+	// 保存前に内容を検査する
+	manifest, err := inspectMsmGz(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", msmFileName(name), err)
+	}
+
+	// 保存先ディレクトリの作成
+	if err := os.MkdirAll(s.Dir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("create msm directory %s: %w", s.Dir, err)
+	}
+
+	log.Printf("MSM保存 %s", path)
+	if err := writeCachedMsm(path, b, manifest); err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+// キャッシュしたMSMファイルの検証情報
+type MsmManifest struct {
+	SHA256 string    `json:"sha256"` // gzip圧縮されたファイルのSHA-256
+	Size   int64     `json:"size"`   // gzip圧縮されたファイルのバイト数
+	Rows   int       `json:"rows"`   // ヘッダーを除くデータ行数
+	Start  time.Time `json:"start"`  // 最初の参照時刻
+	End    time.Time `json:"end"`    // 最後の参照時刻
+}
+
+// MSMファイル path のマニフェストのパス
+func manifestPath(path string) string {
+	return path + ".manifest"
+}
+
+// gzip圧縮されたMSMファイルの内容 b を展開して検査し、検証情報を作成します。
+func inspectMsmGz(b []byte) (MsmManifest, error) {
+	sum := sha256.Sum256(b)
+	m := MsmManifest{
+		SHA256: hex.EncodeToString(sum[:]),
+		Size:   int64(len(b)),
+	}
+
+	gf, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return m, fmt.Errorf("gzip: %w", err)
+	}
+	defer gf.Close()
+
+	scanner := bufio.NewScanner(gf)
+	if !scanner.Scan() {
+		return m, fmt.Errorf("empty file")
+	}
+
+	var first, last string
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		if first == "" {
+			first = line
+		}
+		last = line
+		m.Rows++
+	}
+	if err := scanner.Err(); err != nil {
+		return m, fmt.Errorf("gzip: %w", err)
+	}
+	if m.Rows == 0 {
+		return m, fmt.Errorf("no data rows")
+	}
+
+	parseDate := func(line string) (time.Time, error) {
+		return time.Parse("2006-01-02 15:04:05", strings.SplitN(line, ",", 2)[0])
+	}
+	if m.Start, err = parseDate(first); err != nil {
+		return m, fmt.Errorf("line 2: date: %w", err)
+	}
+	if m.End, err = parseDate(last); err != nil {
+		return m, fmt.Errorf("line %d: date: %w", m.Rows+1, err)
+	}
+
+	return m, nil
+}
+
+// 検査結果 got が検証情報 m と一致するかを確認します。
+func (m MsmManifest) verify(got MsmManifest) error {
+	if err := m.verifyChecksum(got.Size, got.SHA256); err != nil {
+		return err
+	}
+	if got.Rows != m.Rows {
+		return fmt.Errorf("row count mismatch: %d rows, manifest has %d", got.Rows, m.Rows)
+	}
+	return nil
+}
+
+// バイト数 size と SHA-256 sum が検証情報 m と一致するかを確認します。
+func (m MsmManifest) verifyChecksum(size int64, sum string) error {
+	if size != m.Size {
+		return fmt.Errorf("size mismatch: %d bytes, manifest has %d", size, m.Size)
+	}
+	if sum != m.SHA256 {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
+
+// MSMファイル path のマニフェストを読み込みます。
+func readMsmManifest(path string) (MsmManifest, error) {
+	var m MsmManifest
+	b, err := os.ReadFile(manifestPath(path))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("manifest: %w", err)
+	}
+	return m, nil
+}
+
+// キャッシュしたMSMファイル path を読み込み、マニフェストのバイト数・チェックサムと照合します。
+// 保存時に内容を検査しているため、ここでは展開しません (展開して検査するのは VerifyCachedMsm)。
+// マニフェストが無い場合(以前のバージョンで保存したファイル)は、内容を検査できればマニフェストを作成します。
+// ファイルが存在しない場合は os.IsNotExist で判定できるエラーを返します。
+func readVerifiedMsm(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := readMsmManifest(path)
+	if os.IsNotExist(err) {
+		got, err := inspectMsmGz(b)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("MSMマニフェスト作成 %s", manifestPath(path))
+		if err := writeMsmManifest(path, got); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(b)
+	if err := m.verifyChecksum(int64(len(b)), hex.EncodeToString(sum[:])); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// MSMファイル path とそのマニフェストを保存します。
+func writeCachedMsm(path string, b []byte, m MsmManifest) error {
+	if err := writeFileAtomic(path, b); err != nil {
+		return err
+	}
+	return writeMsmManifest(path, m)
+}
+
+func writeMsmManifest(path string, m MsmManifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(manifestPath(path), b)
+}
+
+// キャッシュしたMSMファイル path とそのマニフェスト、バイナリキャッシュを削除します。
+func removeCachedMsm(path string) {
+	os.Remove(path)
+	os.Remove(manifestPath(path))
+	os.Remove(binaryPath(path))
+}
+
+// 同じディレクトリの一時ファイルに内容 b を書き込んでから path に名前を変更します。
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("save %s: %w", path, err)
+	}
+	tmpName := tmp.Name()
+
+	_, err = tmp.Write(b)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmpName, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmpName, path)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("save %s: %w", path, err)
+	}
+	return nil
+}
+
+//--------------------------------------
+// キャッシュの管理
+//--------------------------------------
+
+// キャッシュディレクトリ内のMSMファイル
+type MsmCacheEntry struct {
+	Archive  string       // アーカイブ名(アーカイブ別のサブディレクトリの場合)
+	Name     string       // メッシュ地点番号 "{SN}-{WE}"
+	Path     string       // ファイルのパス
+	Size     int64        // ファイルサイズ(マニフェスト・バイナリキャッシュを含む)
+	Binary   bool         // バイナリキャッシュの有無
+	LastUsed time.Time    // 最終使用日時(ファイルの更新日時)
+	Manifest *MsmManifest // マニフェスト(存在しない場合は nil)
+}
+
+// 表示用の名前 ("{アーカイブ名}/{SN}-{WE}")
+func (e *MsmCacheEntry) DisplayName() string {
+	if e.Archive == "" {
+		return e.Name
+	}
+	return e.Archive + "/" + e.Name
+}
+
+// キャッシュディレクトリ dir 内(アーカイブ別のサブディレクトリを含む)のMSMファイルの一覧を名前順に返します。
+func ListMsmCache(dir string) ([]MsmCacheEntry, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.csv.gz"))
+	if err != nil {
+		return nil, err
+	}
+	sub, err := filepath.Glob(filepath.Join(dir, "*", "*.csv.gz"))
+	if err != nil {
+		return nil, err
+	}
+	paths = append(paths, sub...)
+
+	entries := make([]MsmCacheEntry, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		entry := MsmCacheEntry{
+			Name:     strings.TrimSuffix(filepath.Base(path), ".csv.gz"),
+			Path:     path,
+			Size:     info.Size(),
+			LastUsed: info.ModTime(),
+		}
+		if d := filepath.Dir(path); d != filepath.Clean(dir) {
+			entry.Archive = filepath.Base(d)
+		}
+		if m, err := readMsmManifest(path); err == nil {
+			entry.Manifest = &m
+		}
+		if minfo, err := os.Stat(manifestPath(path)); err == nil {
+			entry.Size += minfo.Size()
+		}
+		if binfo, err := os.Stat(binaryPath(path)); err == nil {
+			entry.Size += binfo.Size()
+			entry.Binary = true
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Archive != entries[j].Archive {
+			return entries[i].Archive < entries[j].Archive
+		}
+		return entries[i].Name < entries[j].Name
+	})
+
+	return entries, nil
+}
+
+// キャッシュしたMSMファイル path を展開して読み込み、内容を検証します。
+// 読み込みには load_msm と同じ処理を使用します。マニフェストやバイナリキャッシュがある場合は照合も行います。
+func VerifyCachedMsm(path string) (MsmManifest, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return MsmManifest{}, err
+	}
+
+	got, err := inspectMsmGz(b)
+	if err != nil {
+		return got, err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), ".csv.gz")
+	msm, err := parseMsm(name, bytes.NewReader(b))
+	if err != nil {
+		return got, err
+	}
+
+	m, err := readMsmManifest(path)
+	if err == nil {
+		err = m.verify(got)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err == nil {
+		err = verifyMsmBinary(path, &msm)
+	}
+
+	return got, err
+}
+
+// キャッシュしたMSMファイル entry をマニフェスト・バイナリキャッシュとともに削除します。
+func RemoveCachedMsm(entry MsmCacheEntry) error {
+	if err := os.Remove(entry.Path); err != nil {
+		return err
+	}
+	for _, p := range []string{manifestPath(entry.Path), binaryPath(entry.Path)} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// キャッシュしたMSMファイルの一覧 entries から削除すべきファイルを最終使用日時の古い順に選びます。
+// 最終使用日時が now から maxAge 以上前のファイル(maxAge > 0 の場合)と、
+// 合計サイズが maxSize 以下になるまで(maxSize > 0 の場合)の古いファイルが対象です。
+func PruneCandidates(entries []MsmCacheEntry, maxSize int64, maxAge time.Duration, now time.Time) []MsmCacheEntry {
+	sorted := append([]MsmCacheEntry{}, entries...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].LastUsed.Before(sorted[j].LastUsed) })
+
+	var total int64
+	for _, e := range sorted {
+		total += e.Size
+	}
+
+	prune := []MsmCacheEntry{}
+	for _, e := range sorted {
+		expired := maxAge > 0 && now.Sub(e.LastUsed) >= maxAge
+		oversize := maxSize > 0 && total > maxSize
+		if !expired && !oversize {
+			continue
+		}
+		prune = append(prune, e)
+		total -= e.Size
+	}
+
+	return prune
+}
+
+// 緯度 south から north, 経度 west から east の範囲内のいずれかの地点の計算に必要な
+// MSMファイルのメッシュ地点番号の一覧を返します。
+func RequiredMsmListInBounds(south float64, west float64, north float64, east float64) []string {
+	return RequiredMsmListInBoundsN(south, west, north, east, Neighborhood4)
+}
+
+// 緯度 south から north, 経度 west から east の範囲内のいずれかの地点の周囲 n 地点 (4 または 16) による
+// 計算に必要なMSMファイルのメッシュ地点番号の一覧を返します。16地点の場合は外周の1行・1列を含みます。
+func RequiredMsmListInBoundsN(south float64, west float64, north float64, east float64, n int) []string {
+	if south > north {
+		south, north = north, south
+	}
+	if west > east {
+		west, east = east, west
+	}
+
+	MSM_S, _, MSM_W, _ := Meshcode1d(south, west)
+	_, MSM_N, _, MSM_E := Meshcode1d(north, east)
+	if n == Neighborhood16 {
+		MSM_S, MSM_N, MSM_W, MSM_E = MSM_S+1, MSM_N-1, MSM_W-1, MSM_E+1
+	}
+
+	list := []string{}
+	for sn := MSM_N; sn <= MSM_S; sn++ {
+		for we := MSM_W; we <= MSM_E; we++ {
+			list = append(list, fmt.Sprintf("%d-%d", sn, we))
+		}
+	}
+	return list
+}
+
+// MSMファイル msm_list を取得元 src から取得してキャッシュに保存します。
+// 同時に取得するファイル数は parallel 以下とします。
+// 取得に失敗したファイルがあっても残りのファイルの取得は継続し、失敗したファイルのエラーを返します。
+func PrefetchMsm(ctx context.Context, msm_list []string, src *CachedMsmSource, parallel int) map[string]error {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	var mu sync.Mutex
+	errs := make(map[string]error)
+
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for _, name := range msm_list {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			r, err := src.Open(ctx, name)
+			if err == nil {
+				_, err = io.Copy(io.Discard, r)
+				r.Close()
+			}
+			if err != nil {
+				mu.Lock()
+				errs[name] = err
+				mu.Unlock()
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	return errs
+}
+
+//--------------------------------------
+// オフライン
+//--------------------------------------
+
+// ネットワークを使用しないため、MSMファイルを取得できないことを表すエラー
+var ErrOffline = errors.New("network access is disabled in offline mode")
+
+// 必要なMSMファイルの一部がローカルディレクトリに存在しないことを表すエラー
+type MissingMsmFilesError struct {
+	Dir   string   // ディレクトリ
+	Names []string // 存在しないMSMファイル名 "{SN}-{WE}.csv.gz"
+}
+
+func (e *MissingMsmFilesError) Error() string {
+	return fmt.Sprintf("missing msm files in %s: %s", e.Dir, strings.Join(e.Names, ", "))
+}
+
+func (e *MissingMsmFilesError) Unwrap() error {
+	return ErrMsmNotFound
+}
+
+// MSMファイル msm_list がディレクトリ dirs のいずれかにすべて存在するかを確認します。
+// 存在しないファイルがある場合は *MissingMsmFilesError を返します。
+func checkMsmFiles(dirs []string, msm_list []string) error {
+	missing := []string{}
+	for _, name := range msm_list {
+		found := false
+		for _, dir := range dirs {
+			if fileExists(filepath.Join(dir, msmFileName(name))) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, msmFileName(name))
+		}
+	}
+	if len(missing) > 0 {
+		return &MissingMsmFilesError{Dir: strings.Join(dirs, ", "), Names: missing}
+	}
+	return nil
+}
+
+// 常に取得に失敗する取得元(オフライン時にキャッシュが破損していた場合に再取得させないため)
+type offlineMsmSource struct{}
+
+func (offlineMsmSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	return nil, offlineError{name}
+}
+
+// ローカルディレクトリにMSMファイルが存在しないことを表すエラー
+// (ErrOffline と ErrMsmNotFound のいずれとしても扱えます)
+type offlineError struct {
+	name string
+}
+
+func (e offlineError) Error() string {
+	return fmt.Sprintf("%s: %v", msmFileName(e.name), ErrOffline)
+}
+
+func (e offlineError) Is(target error) bool {
+	return target == ErrOffline || target == ErrMsmNotFound
+}
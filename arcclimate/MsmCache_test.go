@@ -0,0 +1,170 @@
+package arcclimate
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 取得回数を数える取得元
+type countingMsmSource struct {
+	MsmSource
+	opened int
+}
+
+func (s *countingMsmSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	s.opened++
+	return s.MsmSource.Open(ctx, name)
+}
+
+func Test_CachedMsmSource(t *testing.T) {
+	start := time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := makeMsmGz(start, 24, 0.0)
+	mem := NewMemoryMsmSource()
+	mem.Put("238-315", data)
+	upstream := &countingMsmSource{MsmSource: mem}
+
+	dir := t.TempDir()
+	src := &CachedMsmSource{Source: upstream, Dir: dir, UseCache: true, SaveCache: true}
+
+	readAll := func() []byte {
+		r, err := src.Open(context.Background(), "238-315")
+		assert.NoError(t, err)
+		b, _ := io.ReadAll(r)
+		r.Close()
+		return b
+	}
+
+	// 初回は取得元から取得して保存する
+	assert.Equal(t, data, readAll())
+	assert.Equal(t, 1, upstream.opened)
+
+	path := filepath.Join(dir, "238-315.csv.gz")
+	m, err := readMsmManifest(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 24, m.Rows)
+	assert.Equal(t, int64(len(data)), m.Size)
+	assert.Equal(t, start, m.Start)
+	assert.Equal(t, start.Add(23*time.Hour), m.End)
+
+	// 一時ファイルは残らない
+	files, _ := filepath.Glob(filepath.Join(dir, "*.tmp*"))
+	assert.Empty(t, files)
+
+	// 2回目はキャッシュから読み込む
+	assert.Equal(t, data, readAll())
+	assert.Equal(t, 1, upstream.opened)
+
+	// 壊れたキャッシュは再取得する
+	assert.NoError(t, os.WriteFile(path, data[:len(data)/2], 0644))
+	assert.Equal(t, data, readAll())
+	assert.Equal(t, 2, upstream.opened)
+
+	// マニフェストの無いキャッシュは検査してマニフェストを作成する
+	os.Remove(manifestPath(path))
+	assert.Equal(t, data, readAll())
+	assert.Equal(t, 2, upstream.opened)
+	assert.FileExists(t, manifestPath(path))
+
+	// マニフェストがある場合はバイト数とチェックサムのみ照合し、展開しない (展開して検査するのは VerifyCachedMsm)
+	notGzip := []byte("not gzip")
+	manifest, _ := inspectMsmGz(notGzip)
+	assert.NoError(t, writeCachedMsm(path, notGzip, manifest))
+	assert.Equal(t, notGzip, readAll())
+	assert.Equal(t, 2, upstream.opened)
+	_, err = VerifyCachedMsm(path)
+	assert.Error(t, err)
+}
+
+func Test_CachedMsmSource_RejectInvalid(t *testing.T) {
+	mem := NewMemoryMsmSource()
+	mem.Put("238-315", []byte("<html>Service Unavailable</html>"))
+
+	dir := t.TempDir()
+	src := &CachedMsmSource{Source: mem, Dir: dir, UseCache: true, SaveCache: true}
+
+	_, err := src.Open(context.Background(), "238-315")
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "238-315.csv.gz"))
+}
+
+func Test_ListMsmCache_Verify(t *testing.T) {
+	start := time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+	mem := NewMemoryMsmSource()
+	mem.Put("238-315", makeMsmGz(start, 24, 0.0))
+	mem.Put("238-316", makeMsmGz(start, 48, 0.0))
+
+	dir := t.TempDir()
+	src := &CachedMsmSource{Source: mem, Dir: dir, UseCache: true, SaveCache: true}
+	errs := PrefetchMsm(context.Background(), []string{"238-316", "238-315", "999-999"}, src, 2)
+	assert.Equal(t, 1, len(errs))
+	assert.ErrorIs(t, errs["999-999"], ErrMsmNotFound)
+
+	entries, err := ListMsmCache(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(entries))
+	assert.Equal(t, "238-315", entries[0].Name)
+	assert.Equal(t, 24, entries[0].Manifest.Rows)
+	assert.Equal(t, "238-316", entries[1].Name)
+	assert.Equal(t, start.Add(47*time.Hour), entries[1].Manifest.End)
+
+	_, err = VerifyCachedMsm(entries[0].Path)
+	assert.NoError(t, err)
+
+	// 数値として読めない値を含むファイル
+	broken := makeMsmGzFromCSV("date,TMP,MR,DSWRF_est,DSWRF_msm,Ld,VGRD,UGRD,PRES,APCP01\n" +
+		"2011-01-01 00:00:00,x,5.0,0.5,,300.0,1.0,-1.0,101325.0,0.0\n")
+	assert.NoError(t, os.WriteFile(entries[1].Path, broken, 0644))
+	os.Remove(manifestPath(entries[1].Path))
+	_, err = VerifyCachedMsm(entries[1].Path)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 2: TMP")
+}
+
+func Test_PruneCandidates(t *testing.T) {
+	now := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	entries := []MsmCacheEntry{
+		{Name: "a", Size: 100, LastUsed: now.Add(-1 * time.Hour)},
+		{Name: "b", Size: 100, LastUsed: now.Add(-72 * time.Hour)},
+		{Name: "c", Size: 100, LastUsed: now.Add(-24 * time.Hour)},
+	}
+
+	// 合計サイズの上限
+	prune := PruneCandidates(entries, 150, 0, now)
+	assert.Equal(t, 2, len(prune))
+	assert.Equal(t, "b", prune[0].Name)
+	assert.Equal(t, "c", prune[1].Name)
+
+	// 最終使用日時からの経過時間の上限
+	prune = PruneCandidates(entries, 0, 48*time.Hour, now)
+	assert.Equal(t, 1, len(prune))
+	assert.Equal(t, "b", prune[0].Name)
+
+	// 上限なし
+	assert.Empty(t, PruneCandidates(entries, 0, 0, now))
+}
+
+func Test_RequiredMsmListInBounds(t *testing.T) {
+	// 1地点の場合は RequiredMsmList と同じ
+	list := RequiredMsmListInBounds(35.658, 139.741, 35.658, 139.741)
+	assert.ElementsMatch(t, RequiredMsmList(35.658, 139.741), list)
+
+	// 緯度方向に2格子、経度方向に1格子広い範囲
+	list = RequiredMsmListInBounds(35.658, 139.741, 35.758, 139.801)
+	assert.Equal(t, 4*3, len(list))
+	assert.Subset(t, list, RequiredMsmList(35.758, 139.801))
+
+	// 周囲16地点の場合は外周の1行・1列を含む
+	list = RequiredMsmListInBoundsN(35.658, 139.741, 35.658, 139.741, Neighborhood16)
+	assert.ElementsMatch(t, RequiredMsmListN(35.658, 139.741, Neighborhood16), list)
+	list = RequiredMsmListInBoundsN(35.658, 139.741, 35.758, 139.801, Neighborhood16)
+	assert.Equal(t, 6*5, len(list))
+	for _, p := range [][2]float64{{35.658, 139.741}, {35.758, 139.801}, {35.658, 139.801}, {35.758, 139.741}} {
+		assert.Subset(t, list, RequiredMsmListN(p[0], p[1], Neighborhood16))
+	}
+}
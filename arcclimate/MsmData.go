@@ -1,14 +1,19 @@
 package arcclimate
 
 import (
+	"errors"
+	"fmt"
 	"math"
+	"sort"
 	"time"
+
+	"github.com/udawtr/arcclimate-go/arcclimate/psychro"
 )
 
 // MSMファイルから読み取ったデータ
 type MsmData struct {
 	name string //ファイル名
-	Rows *[87687]MsmDataRow
+	Rows []MsmDataRow
 }
 
 type MsmDataRow struct {
@@ -28,41 +33,192 @@ type MsmDataSet struct {
 	Data []MsmData
 }
 
+// 各MSMファイルのメッシュ地点番号の一覧
+func (msms *MsmDataSet) Names() []string {
+	names := make([]string, len(msms.Data))
+	for i, msm := range msms.Data {
+		names[i] = msm.name
+	}
+	return names
+}
+
 func (msm *MsmData) Length() int {
 	return len(msm.Rows)
 }
 
+// 期間 window のデータを抜き出します。
+// 期間にデータが含まれない場合は ErrOutOfPeriod をラップしたエラーを返します。
+func (msm *MsmData) window(window MsmWindow) (MsmData, error) {
+	if window.IsZero() || msm.Length() == 0 {
+		return *msm, nil
+	}
+	lo := sort.Search(msm.Length(), func(i int) bool { return !window.before(msm.Rows[i].date) })
+	hi := sort.Search(msm.Length(), func(i int) bool { return window.after(msm.Rows[i].date) })
+	if lo >= hi {
+		return MsmData{}, window.outOfPeriod(msm.Rows[0].date, msm.Rows[msm.Length()-1].date)
+	}
+	return MsmData{name: msm.name, Rows: msm.Rows[lo:hi]}, nil
+}
+
+// MSMデータの期間が要求された年を含まないことを表すエラー
+var ErrOutOfPeriod = errors.New("outside the available msm period")
+
+// MSMデータの期間(最初と最後の参照時刻)
+type MsmPeriod struct {
+	Start time.Time
+	End   time.Time
+}
+
+// 期間に含まれる最初の年と最後の年を返します。
+// 先頭の年は1月1日、末尾の年は12月31日のデータを含む場合に、その年を含むものとします。
+// (積算値の参照時刻のずれにより、年始・年末の数時間が欠けるファイルがあるため)
+func (p MsmPeriod) Years() (int, int) {
+	start_year := p.Start.Year()
+	if !p.Start.Before(time.Date(start_year, 1, 2, 0, 0, 0, 0, p.Start.Location())) {
+		start_year++
+	}
+	end_year := p.End.Year()
+	if p.End.Before(time.Date(end_year, 12, 31, 0, 0, 0, 0, p.End.Location())) {
+		end_year--
+	}
+	return start_year, end_year
+}
+
+// 開始年 start_year から終了年 end_year までが期間に含まれるか確認します。
+func (p MsmPeriod) CheckYears(start_year int, end_year int) error {
+	first, last := p.Years()
+	if start_year < first || end_year > last {
+		return fmt.Errorf("years %d-%d: %w %d-%d (%s - %s)", start_year, end_year, ErrOutOfPeriod,
+			first, last, p.Start.Format("2006-01-02 15:04"), p.End.Format("2006-01-02 15:04"))
+	}
+	return nil
+}
+
+// MSMデータを読み込む期間(ゼロ値の場合は全期間)
+type MsmWindow struct {
+	From time.Time // 最初の参照時刻
+	To   time.Time // 最後の参照時刻
+}
+
+// 開始年 start_year から終了年 end_year までの計算に必要な期間を返します。
+// 直散分離(Perez)の前後1時間と、標準年の接合部の円滑化(前後6時間)のため、前後に1日の余裕を持たせます。
+func YearWindow(start_year int, end_year int) MsmWindow {
+	return MsmWindow{
+		From: time.Date(start_year, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1),
+		To:   time.Date(end_year, 12, 31, 23, 0, 0, 0, time.UTC).AddDate(0, 0, 1),
+	}
+}
+
+func (w MsmWindow) IsZero() bool {
+	return w.From.IsZero() && w.To.IsZero()
+}
+
+// 参照時刻 t が期間の開始前か
+func (w MsmWindow) before(t time.Time) bool {
+	return !w.From.IsZero() && t.Before(w.From)
+}
+
+// 参照時刻 t が期間の終了後か
+func (w MsmWindow) after(t time.Time) bool {
+	return !w.To.IsZero() && t.After(w.To)
+}
+
+// 期間の時間数(全期間の場合は n)
+func (w MsmWindow) capacity(n int) int {
+	if w.From.IsZero() || w.To.IsZero() {
+		return n
+	}
+	if h := int(w.To.Sub(w.From)/time.Hour) + 1; h < n {
+		return h
+	}
+	return n
+}
+
+// 最初の参照時刻 first から最後の参照時刻 last までのデータが期間を含まないことを表すエラー
+func (w MsmWindow) outOfPeriod(first time.Time, last time.Time) error {
+	return fmt.Errorf("%s - %s: %w %s - %s", w.From.Format("2006-01-02 15:04"), w.To.Format("2006-01-02 15:04"),
+		ErrOutOfPeriod, first.Format("2006-01-02 15:04"), last.Format("2006-01-02 15:04"))
+}
+
+// 周囲4地点のMSMデータの参照時刻が、いずれも同一かつ1時間間隔で連続しているか確認し、その期間を返します。
+func (msms *MsmDataSet) Period() (MsmPeriod, error) {
+	if len(msms.Data) == 0 || msms.Data[0].Length() == 0 {
+		return MsmPeriod{}, fmt.Errorf("no msm data")
+	}
+
+	base := &msms.Data[0]
+	for i := 1; i < base.Length(); i++ {
+		if d := base.Rows[i].date.Sub(base.Rows[i-1].date); d != time.Hour {
+			return MsmPeriod{}, fmt.Errorf("%s.csv.gz: row %d: %s follows %s (not hourly)", base.name, i+1,
+				base.Rows[i].date.Format("2006-01-02 15:04"), base.Rows[i-1].date.Format("2006-01-02 15:04"))
+		}
+	}
+
+	for _, msm := range msms.Data[1:] {
+		if msm.Length() != base.Length() {
+			return MsmPeriod{}, fmt.Errorf("%s.csv.gz has %d rows but %s.csv.gz has %d rows",
+				msm.name, msm.Length(), base.name, base.Length())
+		}
+		for i := range msm.Rows {
+			if !msm.Rows[i].date.Equal(base.Rows[i].date) {
+				return MsmPeriod{}, fmt.Errorf("%s.csv.gz: row %d: date %s differs from %s in %s.csv.gz", msm.name, i+1,
+					msm.Rows[i].date.Format("2006-01-02 15:04"), base.Rows[i].date.Format("2006-01-02 15:04"), base.name)
+			}
+		}
+	}
+
+	return MsmPeriod{Start: base.Rows[0].date, End: base.Rows[base.Length()-1].date}, nil
+}
+
 // MSMデータフレームの気温 TMP 、気圧 PRES、重量絶対湿度 MR を標高補正する(標高 elevation [m] から ele_target [m] へ補正)。
-func (msm *MsmData) CorrectedMsm_TMP_PRES_MR(elevation float64, ele_target float64) *MsmData {
+// 気温減率は lapse の参照時刻ごとの値を使用します(nil の場合は DefaultLapseRate、NoLapseRate の場合は補正しない)。
+// 補正結果は新しいデータフレームとして返し、元のデータフレーム msm は変更しません(複数地点の計算で共有するため)。
+func (msm *MsmData) CorrectedMsm_TMP_PRES_MR(elevation float64, ele_target float64, lapse LapseRate) *MsmData {
+	if lapse == nil {
+		lapse = DefaultLapseRate
+	}
 
 	// 標高差
 	ele_gap := ele_target - elevation
 
-	for i := 0; i < msm.Length(); i++ {
+	corrected := &MsmData{
+		name: msm.name,
+		Rows: make([]MsmDataRow, msm.Length()),
+	}
+	copy(corrected.Rows, msm.Rows)
+
+	if lapse == NoLapseRate {
+		return corrected
+	}
+
+	for i := 0; i < corrected.Length(); i++ {
+
+		TMP := corrected.Rows[i].TMP
+		PRES := corrected.Rows[i].PRES
+		MR := corrected.Rows[i].MR
 
-		TMP := msm.Rows[i].TMP
-		PRES := msm.Rows[i].PRES
-		MR := msm.Rows[i].MR
+		// 参照時刻の気温減率
+		rate := lapse.At(corrected.Rows[i].date)
 
 		// 気温補正
-		TMP_corr := CorrectTMP(TMP, ele_gap)
+		TMP_corr := CorrectTMPWithLapseRate(TMP, ele_gap, rate)
 
 		// 気圧補正
-		PRES_corr := CorrectPRES(PRES, ele_gap, TMP_corr)
+		PRES_corr := CorrectPRESWithLapseRate(PRES, ele_gap, TMP_corr, rate)
 
 		// 重量絶対湿度補正
 		MR_corr := CorrectMR(MR, TMP_corr, PRES_corr)
 
 		// 補正値をデータフレームに戻す
-		msm.Rows[i].TMP = TMP_corr
-		msm.Rows[i].PRES = PRES_corr
-		msm.Rows[i].MR = MR_corr
+		corrected.Rows[i].TMP = TMP_corr
+		corrected.Rows[i].PRES = PRES_corr
+		corrected.Rows[i].MR = MR_corr
 	}
 
 	// なぜ 気圧消すのか？
 	// msm.drop(['PRES'], axis=1, inplace=True)
 
-	return msm
+	return corrected
 }
 
 //--------------------------------------
@@ -75,6 +231,14 @@ func CorrectTMP(TMP float64, ele_gap float64) float64 {
 	return TMP + ele_gap*-0.0065
 }
 
+// 気温 TMP [℃] を、 標高差 ele_gap [m] と気温減率 rate [℃/m] を用いて補正します。
+func CorrectTMPWithLapseRate(TMP float64, ele_gap float64, rate float64) float64 {
+	if rate == standardLapseRate {
+		return CorrectTMP(TMP, ele_gap)
+	}
+	return TMP - ele_gap*rate
+}
+
 //--------------------------------------
 // 気圧
 //--------------------------------------
@@ -85,6 +249,19 @@ func CorrectPRES(PRES float64, ele_gap float64, TMP float64) float64 {
 	return PRES * math.Pow(1-((ele_gap*0.0065)/(TMP+273.15)), 5.257)
 }
 
+// 気圧 PRES [hPa] を 標高差 ele_gap [m] と 気温 TMP [℃]、気温減率 rate [℃/m] を用いて補正します。
+// べき数は g/(R・rate) (0.0065℃/m で 5.257) とし、気温減率が0の場合は等温大気とします。
+func CorrectPRESWithLapseRate(PRES float64, ele_gap float64, TMP float64, rate float64) float64 {
+	if rate == standardLapseRate {
+		return CorrectPRES(PRES, ele_gap, TMP)
+	}
+	const g_R = 5.257 * standardLapseRate // g/R [K/m]
+	if rate == 0 {
+		return PRES * math.Exp(-g_R*ele_gap/(TMP+273.15))
+	}
+	return PRES * math.Pow(1-((ele_gap*rate)/(TMP+273.15)), g_R/rate)
+}
+
 //--------------------------------------
 // 重量絶対湿度の計算
 //--------------------------------------
@@ -102,28 +279,12 @@ func CorrectMR(MR float64, TMP float64, PRES float64) float64 {
 
 // 気圧 PRES [hPa] と 気温 TMP [℃] から 重量絶対湿度 [g/kg(DA)] を求める。
 func mixingRatio(PRES float64, TMP float64) float64 {
-	// 絶対温度 [K]
-	T := TMP + 273.15
-
-	// 飽和水蒸気圧 [hPa]
-	eSAT := eSAT(T)
-
-	// 飽和水蒸気量 [g/m3]
-	aT := aT(eSAT, T)
-
-	// 重量絶対湿度 [g/kg(DA)]
-	MR := aT / ((PRES / 100) / (2.87 * T))
-
-	return MR
+	return psychro.SaturationMixingRatio(PRES, TMP)
 }
 
 // 絶対温度 T [K] から 飽和水蒸気圧 [hPa] を求める。
 func eSAT(T float64) float64 {
-	return math.Exp(-5800.2206/T+
-		1.3914993-0.048640239*T+
-		0.41764768*0.0001*T*T-
-		0.14452093*0.0000001*T*T*T+
-		6.5459673*math.Log(T)) / 100
+	return psychro.SaturationPressure(T)
 }
 
 // 飽和水蒸気圧 eSAT [hPa] と 絶対温度 T [K] から 飽和水蒸気量 aT [g/m^3] を求める。
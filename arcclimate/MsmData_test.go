@@ -1,8 +1,12 @@
 package arcclimate
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"math"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -91,3 +95,155 @@ func Test_VH(t *testing.T) {
 
 	assert.InDelta(t, _VH, VH(aT, RH), 0.0000000001)
 }
+
+// 開始日時 start から hours 時間分のMSMデータを作成します。
+func makeMsmData(t *testing.T, name string, start time.Time, hours int) MsmData {
+	msm, err := parseMsm(name, bytes.NewReader(makeMsmGz(start, hours, 0.0)))
+	assert.NoError(t, err)
+	return msm
+}
+
+func Test_MsmDataSet_Period(t *testing.T) {
+	start := time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// ファイルの行数に応じた長さで読み込む
+	msms := MsmDataSet{Data: []MsmData{
+		makeMsmData(t, "238-315", start, 100),
+		makeMsmData(t, "238-316", start, 100),
+	}}
+	assert.Equal(t, 100, msms.Data[0].Length())
+	p, err := msms.Period()
+	assert.NoError(t, err)
+	assert.Equal(t, start, p.Start)
+	assert.Equal(t, start.Add(99*time.Hour), p.End)
+
+	// 行数が異なる
+	msms.Data[1] = makeMsmData(t, "238-316", start, 99)
+	_, err = msms.Period()
+	assert.Error(t, err)
+
+	// 参照時刻が異なる
+	msms.Data[1] = makeMsmData(t, "238-316", start.Add(time.Hour), 100)
+	_, err = msms.Period()
+	assert.Error(t, err)
+
+	// 欠測がある
+	msms.Data[1] = makeMsmData(t, "238-316", start, 100)
+	msms.Data[0].Rows[50].date = msms.Data[0].Rows[50].date.Add(time.Hour)
+	_, err = msms.Period()
+	assert.Error(t, err)
+}
+
+func Test_MsmPeriod_Years(t *testing.T) {
+	// 年始・年末の数時間の欠けは許容する
+	p := MsmPeriod{
+		Start: time.Date(2011, 1, 1, 1, 0, 0, 0, time.UTC),
+		End:   time.Date(2023, 12, 31, 15, 0, 0, 0, time.UTC),
+	}
+	first, last := p.Years()
+	assert.Equal(t, 2011, first)
+	assert.Equal(t, 2023, last)
+	assert.NoError(t, p.CheckYears(2011, 2023))
+	assert.ErrorIs(t, p.CheckYears(2010, 2020), ErrOutOfPeriod)
+	assert.ErrorIs(t, p.CheckYears(2011, 2024), ErrOutOfPeriod)
+
+	p = MsmPeriod{
+		Start: time.Date(2010, 12, 31, 15, 0, 0, 0, time.UTC),
+		End:   time.Date(2021, 1, 1, 8, 0, 0, 0, time.UTC),
+	}
+	first, last = p.Years()
+	assert.Equal(t, 2011, first)
+	assert.Equal(t, 2020, last)
+}
+
+func Test_InterpolateWithOptions_OutOfPeriod(t *testing.T) {
+	lat, lon := 35.658, 139.741
+	src := NewMemoryMsmSource()
+	data := makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 365*24, 0.0)
+	for _, name := range RequiredMsmList(lat, lon) {
+		src.Put(name, data)
+	}
+
+	opts := NewOptions(lat, lon)
+	opts.ElevationMode = ElevationMesh
+	opts.Source = src
+	opts.UseCache, opts.SaveCache = false, false
+
+	opts.StartYear, opts.EndYear = 2011, 2012
+	_, err := InterpolateWithOptions(context.Background(), opts)
+	assert.ErrorIs(t, err, ErrOutOfPeriod)
+
+	opts.StartYear, opts.EndYear = 2011, 2011
+	res, err := InterpolateWithOptions(context.Background(), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, 365*24, len(res.date))
+	assert.Equal(t, time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), res.Metadata.DataStart)
+}
+
+func Test_ParseMsmWindow(t *testing.T) {
+	start := time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+	data := makeMsmGz(start, 3*24, 0.0)
+
+	window := MsmWindow{From: start.Add(24 * time.Hour), To: start.Add(47 * time.Hour)}
+	msm, err := parseMsmWindow("238-315", bytes.NewReader(data), window)
+	assert.NoError(t, err)
+	assert.Equal(t, 24, msm.Length())
+	assert.Equal(t, 24.0, msm.Rows[0].TMP)
+
+	// バイナリキャッシュからも同じ期間を読み込む
+	full, _ := parseMsm("238-315", bytes.NewReader(data))
+	m, _ := inspectMsmGz(data)
+	bin, err := encodeMsmBinary(&full, m.SHA256)
+	assert.NoError(t, err)
+	got, _, err := decodeMsmBinaryWindow("238-315", bin, window)
+	assert.NoError(t, err)
+	assert.True(t, equalMsmRows(msm.Rows, got.Rows))
+
+	// 期間外
+	window = YearWindow(2013, 2013)
+	_, err = parseMsmWindow("238-315", bytes.NewReader(data), window)
+	assert.ErrorIs(t, err, ErrOutOfPeriod)
+	_, _, err = decodeMsmBinaryWindow("238-315", bin, window)
+	assert.ErrorIs(t, err, ErrOutOfPeriod)
+}
+
+func Test_InterpolateWithOptions_Window(t *testing.T) {
+	lat, lon := 35.658, 139.741
+	src := NewMemoryMsmSource()
+	start := time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range RequiredMsmList(lat, lon) {
+		// 気温・日射量が日変化するデータ
+		var csv bytes.Buffer
+		csv.WriteString("date,TMP,MR,DSWRF_est,DSWRF_msm,Ld,VGRD,UGRD,PRES,APCP01\n")
+		for h := 0; h < (365+366+365)*24; h++ {
+			date := start.Add(time.Duration(h) * time.Hour)
+			sun := math.Max(0, math.Sin(float64(h%24-6)/12*math.Pi))
+			fmt.Fprintf(&csv, "%s,%g,5.0,%g,%g,300.0,1.0,-1.0,101325.0,0.0\n",
+				date.Format("2006-01-02 15:04:05"), 10+float64(i)+5*sun, 2.5*sun, 2.4*sun)
+		}
+		src.Put(name, makeMsmGzFromCSV(csv.String()))
+	}
+
+	opts := NewOptions(lat, lon)
+	opts.ElevationMode = ElevationMesh
+	opts.Source = src
+	opts.UseCache, opts.SaveCache = false, false
+	opts.StartYear, opts.EndYear = 2012, 2012
+	res, err := InterpolateWithOptions(context.Background(), opts)
+	assert.NoError(t, err)
+
+	// 全期間を計算してから抜き出した結果と一致する
+	ele, _ := NewElevationMaster(lat, lon)
+	msms, err := LoadMsmFiles(context.Background(), RequiredMsmList(lat, lon), src)
+	assert.NoError(t, err)
+	all, err := PrportionalDivided(context.Background(), lat, lon, msms, ele, ElevationMesh, SeparationPerez)
+	assert.NoError(t, err)
+	want := all.ExctactMsmYear(2012, 2012)
+
+	assert.Equal(t, want.date, res.date)
+	assert.Equal(t, want.TMP, res.TMP)
+	assert.Equal(t, want.RH, res.RH)
+	assert.Equal(t, want.SR_est, res.SR_est)
+	assert.Equal(t, want.SR_msm, res.SR_msm)
+	assert.Equal(t, want.W_spd, res.W_spd)
+}
@@ -1,191 +1,243 @@
 package arcclimate
 
 import (
-	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
-	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"time"
 )
 
-// """MSMファイルを読み込みます。必要に応じてダウンロードを行います。
+// """MSMファイルを取得元 src から読み込みます。
 // Args:
 //
-//	lat(float64): 推計対象地点の緯度（10進法）
-//	lon(float64): 推計対象地点の経度（10進法）
-//	msm_file_dir(str): MSMファイルの格納ディレクトリ
+//	msm_list([]string): 読み込むMSMファイルのメッシュ地点番号("{SN}-{WE}")の一覧
+//	src(MsmSource): MSMファイルの取得元
 //
 // Returns:
 //
 //	MsmDataSet: 読み込んだデータフレームのリスト
+//	error: いずれかのMSMファイルの読み込みに失敗した場合のエラー
 //
 // """
-func LoadMsmFiles(msm_list []string, useCache bool, saveCache bool, msm_file_dir string) MsmDataSet {
-	// 計算に必要なMSMを算出して、ダウンロード⇒ファイルpathをリストで返す
+func LoadMsmFiles(ctx context.Context, msm_list []string, src MsmSource) (MsmDataSet, error) {
+	return LoadMsmArchives(ctx, msm_list, []MsmSource{src}, PrecedenceFirst, MsmWindow{})
+}
 
-	// 保存先ディレクトリの作成
-	if useCache || saveCache {
-		os.Mkdir(msm_file_dir, os.ModePerm)
-	}
+// """MSMファイルを複数の期間別アーカイブの取得元 srcs から読み込み、地点ごとに参照時刻順に結合します。
+// Args:
+//
+//	msm_list([]string): 読み込むMSMファイルのメッシュ地点番号("{SN}-{WE}")の一覧
+//	srcs([]MsmSource): アーカイブの取得元の一覧。いずれかのアーカイブに存在すれば読み込みます。
+//	precedence(MsmPrecedence): 参照時刻が重複した場合に優先するアーカイブ
+//	window(MsmWindow): 読み込む期間(ゼロ値の場合は全期間)
+//
+// Returns:
+//
+//	MsmDataSet: 読み込んだデータフレームのリスト
+//	error: いずれかのMSMファイルの読み込みに失敗した場合、または結合後に欠測がある場合(*MsmGapError)のエラー
+//
+// """
+func LoadMsmArchives(ctx context.Context, msm_list []string, srcs []MsmSource, precedence MsmPrecedence, window MsmWindow) (MsmDataSet, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	// MSMファイル読み込み
 	df_msm_list := make([]MsmData, len(msm_list))
-	c := make(chan MsmAndIndex, 4)
+	c := make(chan MsmAndIndex, len(msm_list))
 	for index, msm := range msm_list {
-		// MSMファイルのパス
 		// MSMファイル読み込み
 		// 負の日射量が存在した際に日射量を0とする
-		go load_msm(index, msm_file_dir, msm, c, useCache, saveCache, msm_file_dir)
+		go func(index int, msm string) {
+			df_msm, err := load_msm_archives(ctx, srcs, precedence, window, msm)
+			c <- MsmAndIndex{index, df_msm, err}
+		}(index, msm)
 	}
 
+	var firstErr error
 	for i := 0; i < len(msm_list); i++ {
 		ret := <-c
+		if ret.Err != nil {
+			if firstErr == nil {
+				// 残りの読み込みを中断する
+				firstErr = ret.Err
+				cancel()
+			}
+			continue
+		}
 		df_msm_list[ret.Index] = ret.Msm
 		log.Printf("MSM読み込み完了 %s", ret.Msm.name)
 	}
+	if firstErr != nil {
+		return MsmDataSet{}, firstErr
+	}
 
-	return MsmDataSet{Data: df_msm_list}
+	return MsmDataSet{Data: df_msm_list}, nil
 }
 
 type MsmAndIndex struct {
 	Index int
 	Msm   MsmData
+	Err   error
 }
 
-func load_msm(index int, msm_file_dir string, msm string, c chan MsmAndIndex, useCache bool, saveCache bool, output_dir string) {
-	msm_path := filepath.Join(msm_file_dir, fmt.Sprintf("%s.csv.gz", msm))
-
-	var gf *gzip.Reader
-	var gerr error
-	if useCache == false || !fileExists(msm_path) {
-		// ダウンロード元URL
-		dl_url := "https://s3.ap-northeast-1.wasabisys.com/arcclimate-ja/msm_2011_2020/"
-		//dl_url := "https://storage.googleapis.com/arcclimate-msm/"
-
-		var err error = nil
-		src_url := fmt.Sprintf("%s%s.csv.gz", dl_url, msm)
-		save_path := filepath.Join(output_dir, fmt.Sprintf("%s.csv.gz", msm))
+// 各アーカイブの取得元 srcs からメッシュ地点番号 msm のMSMファイルを読み込んで結合します。
+// 一部のアーカイブにのみ存在する(または期間 window を含む)場合は、そのアーカイブのデータのみを結合します。
+func load_msm_archives(ctx context.Context, srcs []MsmSource, precedence MsmPrecedence, window MsmWindow, msm string) (MsmData, error) {
+	if len(srcs) == 1 {
+		return load_msm(ctx, srcs[0], msm, window)
+	}
 
-		if saveCache {
-			log.Printf("MSMダウンロード %s => %s", src_url, save_path)
-		} else {
-			log.Printf("MSMダウンロード %s", src_url)
+	parts := make([]MsmData, 0, len(srcs))
+	var notFound error
+	for _, src := range srcs {
+		df_msm, err := load_msm(ctx, src, msm, window)
+		if errors.Is(err, ErrMsmNotFound) || errors.Is(err, ErrOutOfPeriod) {
+			notFound = err
+			continue
 		}
-
-		// Get the data
-		resp, err := http.Get(src_url)
 		if err != nil {
-			panic(err)
+			return MsmData{}, err
 		}
-		defer resp.Body.Close()
+		parts = append(parts, df_msm)
+	}
+	if len(parts) == 0 {
+		return MsmData{}, notFound
+	}
 
-		if saveCache {
-			b, err := io.ReadAll(resp.Body)
-			if err != nil {
-				log.Fatalln(err)
-			}
+	return mergeMsm(msm, parts, precedence)
+}
 
-			// Create the file
-			var out *os.File
-			out, err = os.Create(save_path)
-			if err != nil {
-				panic(err)
-			}
-			defer out.Close()
+// 取得元 src からメッシュ地点番号 msm のMSMファイルの期間 window のデータを読み込みます。
+// 取得元が解析済みのデータを提供できる場合(MsmDataSource)はそちらを使用します。
+func load_msm(ctx context.Context, src MsmSource, msm string, window MsmWindow) (MsmData, error) {
+	if ds, ok := src.(MsmDataSource); ok {
+		return ds.LoadMsm(ctx, msm, window)
+	}
+	return parseMsmFrom(ctx, src, msm, window)
+}
 
-			// Write the body to file
-			_, err = out.Write(b)
-			if err != nil {
-				panic(err)
-			}
+// 取得元 src からメッシュ地点番号 msm のMSMファイルを開いて、期間 window のCSVを解析します。
+func parseMsmFrom(ctx context.Context, src MsmSource, msm string, window MsmWindow) (MsmData, error) {
+	r, err := src.Open(ctx, msm)
+	if err != nil {
+		return MsmData{}, fmt.Errorf("open %s: %w", msmFileName(msm), err)
+	}
+	defer r.Close()
 
-			buf := bytes.NewBuffer(b)
-			gf, gerr = gzip.NewReader(buf)
-		} else {
-			gf, gerr = gzip.NewReader(resp.Body)
-		}
-	} else {
-		log.Printf("MSMファイル読み込み: %s", msm_path)
-		f, ferr := os.Open(msm_path)
-		if ferr != nil {
-			log.Fatal(ferr)
-			panic(ferr)
-		}
-		defer f.Close()
-		gf, gerr = gzip.NewReader(f)
+	df_msm, err := parseMsmWindow(msm, r, window)
+	if err != nil {
+		return MsmData{}, fmt.Errorf("read %s: %w", msmFileName(msm), err)
 	}
 
-	if gerr != nil {
-		fmt.Println("gzipエラー")
-		panic(gerr)
+	return df_msm, nil
+}
+
+// gzip圧縮されたMSMファイルの内容 r を読み込みます。
+// 負の日射量が存在した際には日射量を0とします。
+func parseMsm(msm string, r io.Reader) (MsmData, error) {
+	return parseMsmWindow(msm, r, MsmWindow{})
+}
+
+// gzip圧縮されたMSMファイルの内容 r のうち、参照時刻が期間 window に含まれる行のみを読み込みます。
+// 期間外の行は数値を解析せず、期間の終了後の行は読み込みません(ファイルは参照時刻順であるものとします)。
+// 期間にデータが含まれない場合は ErrOutOfPeriod をラップしたエラーを返します。
+func parseMsmWindow(msm string, r io.Reader, window MsmWindow) (MsmData, error) {
+	gf, err := gzip.NewReader(r)
+	if err != nil {
+		return MsmData{}, fmt.Errorf("gzip: %w", err)
 	}
 	defer gf.Close()
 
 	csvReader := csv.NewReader(gf)
 	csvReader.ReuseRecord = true
-	_, _ = csvReader.Read()
+	if _, err := csvReader.Read(); err != nil {
+		return MsmData{}, fmt.Errorf("header: %w", err)
+	}
 
-	var rows [87687]MsmDataRow
+	// 2011-2020年のファイルの行数(期間指定時は期間の時間数)を初期容量とする
+	rows := make([]MsmDataRow, 0, window.capacity(87687))
+	var first, last time.Time
 
-	for i := 0; i < len(rows); i++ {
+	// 数値項目の読み取り(行番号 line はヘッダーを1行目とする)
+	parseField := func(row []string, line int, col int, name string) (float64, error) {
+		v, err := strconv.ParseFloat(row[col], 64)
+		if err != nil {
+			return 0, fmt.Errorf("line %d: %s: %w", line, name, err)
+		}
+		return v, nil
+	}
+
+	for line := 2; ; line++ {
 		row, cerr := csvReader.Read()
 		if cerr == io.EOF {
 			break
 		}
 		if cerr != nil {
-			log.Fatal(cerr)
-			panic(cerr)
+			return MsmData{}, cerr
+		}
+		if len(row) < 10 {
+			return MsmData{}, fmt.Errorf("line %d: expected 10 fields, got %d", line, len(row))
 		}
 
 		date, err := time.Parse("2006-01-02 15:04:05", row[0])
 		if err != nil {
-			panic(err)
+			return MsmData{}, fmt.Errorf("line %d: date: %w", line, err)
+		}
+		if first.IsZero() {
+			first = date
 		}
-		TMP, err := strconv.ParseFloat(row[1], 64)
+		last = date
+		if window.before(date) {
+			continue
+		}
+		if window.after(date) {
+			break
+		}
+		TMP, err := parseField(row, line, 1, "TMP")
 		if err != nil {
-			panic(err)
+			return MsmData{}, err
 		}
-		MR, err := strconv.ParseFloat(row[2], 64)
+		MR, err := parseField(row, line, 2, "MR")
 		if err != nil {
-			panic(err)
+			return MsmData{}, err
 		}
-		DSWRF_est, err := strconv.ParseFloat(row[3], 64)
+		DSWRF_est, err := parseField(row, line, 3, "DSWRF_est")
 		if err != nil {
-			panic(err)
+			return MsmData{}, err
 		}
 		var DSWRF_msm float64 = math.NaN()
 		if row[4] != "" {
-			DSWRF_msm, err = strconv.ParseFloat(row[4], 64)
+			DSWRF_msm, err = parseField(row, line, 4, "DSWRF_msm")
 			if err != nil {
-				panic(err)
+				return MsmData{}, err
 			}
 		}
-		Ld, err := strconv.ParseFloat(row[5], 64)
+		Ld, err := parseField(row, line, 5, "Ld")
 		if err != nil {
-			panic(err)
+			return MsmData{}, err
 		}
-		VGRD, err := strconv.ParseFloat(row[6], 64)
+		VGRD, err := parseField(row, line, 6, "VGRD")
 		if err != nil {
-			panic(err)
+			return MsmData{}, err
 		}
-		UGRD, err := strconv.ParseFloat(row[7], 64)
+		UGRD, err := parseField(row, line, 7, "UGRD")
 		if err != nil {
-			panic(err)
+			return MsmData{}, err
 		}
-		PRES, err := strconv.ParseFloat(row[8], 64)
+		PRES, err := parseField(row, line, 8, "PRES")
 		if err != nil {
-			panic(err)
+			return MsmData{}, err
 		}
-		APCP01, err := strconv.ParseFloat(row[9], 64)
+		APCP01, err := parseField(row, line, 9, "APCP01")
 		if err != nil {
-			panic(err)
+			return MsmData{}, err
 		}
 
 		if !math.IsNaN(DSWRF_msm) && DSWRF_msm < 0.0 {
@@ -195,7 +247,7 @@ func load_msm(index int, msm_file_dir string, msm string, c chan MsmAndIndex, us
 			DSWRF_est = 0.0
 		}
 
-		rows[i] = MsmDataRow{
+		rows = append(rows, MsmDataRow{
 			date:      date,
 			TMP:       TMP,
 			MR:        MR,
@@ -206,15 +258,19 @@ func load_msm(index int, msm_file_dir string, msm string, c chan MsmAndIndex, us
 			UGRD:      UGRD,
 			PRES:      PRES,
 			APCP01:    APCP01,
-		}
+		})
+	}
+
+	if len(rows) == 0 && !window.IsZero() {
+		return MsmData{}, window.outOfPeriod(first, last)
 	}
 
 	df_msm := MsmData{
 		name: msm,
-		Rows: &rows,
+		Rows: rows,
 	}
 
-	c <- MsmAndIndex{index, df_msm}
+	return df_msm, nil
 }
 
 func fileExists(path string) bool {
@@ -0,0 +1,132 @@
+package arcclimate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+//--------------------------------------
+// プロセス内のMSMデータキャッシュ
+//--------------------------------------
+
+// 読み込み済みのMSMデータをプロセス内で共有するキャッシュ
+// HTTPサーバーのように同じ地点の計算を繰り返す場合に、MSMファイルの読み込み・解析を省略します。
+// キャッシュしたデータは全期間を保持し、読み取り専用として複数の計算で同時に使用します。
+// 取得元の異なる計算条件で同じキャッシュを共有しないでください。
+type MsmMemoryCache struct {
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	tick    uint64
+}
+
+// キャッシュの1ファイル
+type memoryEntry struct {
+	ready chan struct{} // 読み込み完了時に閉じる
+	msm   MsmData
+	err   error
+	used  uint64 // 最後に使用した順番
+}
+
+// MSMファイルを最大 maxEntries 件まで保持するキャッシュを作成します(0以下の場合は無制限)。
+// 上限を超えた場合は、最後に使用した時期の古いファイルから破棄します。
+func NewMsmMemoryCache(maxEntries int) *MsmMemoryCache {
+	return &MsmMemoryCache{
+		maxEntries: maxEntries,
+		entries:    make(map[string]*memoryEntry),
+	}
+}
+
+// キャッシュしているMSMファイル数
+func (c *MsmMemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+// キー key のMSMデータを返します。キャッシュに無い場合は load で読み込みます。
+// 同じキーの読み込みが実行中の場合は、その完了を待って結果を共有します。
+// 読み込みに失敗した場合はキャッシュせず、次回に再度読み込みます。
+func (c *MsmMemoryCache) get(ctx context.Context, key string, load func(ctx context.Context) (MsmData, error)) (MsmData, error) {
+	for {
+		c.mu.Lock()
+		c.tick++
+		e, ok := c.entries[key]
+		if ok {
+			e.used = c.tick
+			c.mu.Unlock()
+			countStat(&stats.MsmMemoryHits, 1)
+
+			select {
+			case <-e.ready:
+			case <-ctx.Done():
+				return MsmData{}, ctx.Err()
+			}
+			if e.err != nil && isContextError(e.err) && ctx.Err() == nil {
+				// 読み込みを開始した計算が中断された場合は読み込み直す
+				continue
+			}
+			return e.msm, e.err
+		}
+
+		e = &memoryEntry{ready: make(chan struct{}), used: c.tick}
+		c.entries[key] = e
+		c.mu.Unlock()
+		countStat(&stats.MsmMemoryMisses, 1)
+
+		e.msm, e.err = load(ctx)
+		c.mu.Lock()
+		if e.err != nil {
+			if c.entries[key] == e {
+				delete(c.entries, key)
+			}
+		} else {
+			c.evict()
+		}
+		c.mu.Unlock()
+		close(e.ready)
+
+		return e.msm, e.err
+	}
+}
+
+// 上限を超えたファイルを破棄します。c.mu を取得してから呼び出します。
+func (c *MsmMemoryCache) evict() {
+	for c.maxEntries > 0 && len(c.entries) > c.maxEntries {
+		oldest := ""
+		for key, e := range c.entries {
+			if oldest == "" || e.used < c.entries[oldest].used {
+				oldest = key
+			}
+		}
+		delete(c.entries, oldest)
+	}
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// 取得元 MsmSource から読み込んだMSMデータをキャッシュ cache に保持する取得元
+type memoryMsmSource struct {
+	MsmSource
+	cache  *MsmMemoryCache
+	prefix string // キャッシュのキーの接頭辞 (アーカイブ名)
+}
+
+func (s *memoryMsmSource) LoadMsm(ctx context.Context, name string, window MsmWindow) (MsmData, error) {
+	msm, err := s.cache.get(ctx, s.prefix+"/"+name, func(ctx context.Context) (MsmData, error) {
+		return load_msm(ctx, s.MsmSource, name, MsmWindow{})
+	})
+	if err != nil {
+		return MsmData{}, err
+	}
+	msm, err = msm.window(window)
+	if err != nil {
+		return MsmData{}, fmt.Errorf("read %s: %w", msmFileName(name), err)
+	}
+	return msm, nil
+}
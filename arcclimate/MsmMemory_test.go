@@ -0,0 +1,80 @@
+package arcclimate
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MsmMemoryCache(t *testing.T) {
+	start := time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+	mem := NewMemoryMsmSource()
+	for _, name := range []string{"238-315", "238-316", "239-315"} {
+		mem.Put(name, makeMsmGz(start, 72, 0.0))
+	}
+	counting := &syncCountingMsmSource{MsmSource: mem, opened: map[string]int{}}
+	cache := NewMsmMemoryCache(2)
+	src := &memoryMsmSource{MsmSource: counting, cache: cache}
+
+	// 同時に読み込んでもMSMファイルは1回のみ開く
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msm, err := load_msm(context.Background(), src, "238-315", MsmWindow{})
+			assert.NoError(t, err)
+			assert.Equal(t, 72, msm.Length())
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, counting.opened["238-315"])
+
+	// 期間を指定した場合はキャッシュから抜き出す
+	msm, err := load_msm(context.Background(), src, "238-315", MsmWindow{From: start.Add(24 * time.Hour), To: start.Add(47 * time.Hour)})
+	assert.NoError(t, err)
+	assert.Equal(t, 24, msm.Length())
+	assert.Equal(t, 1, counting.opened["238-315"])
+
+	// 上限を超えた場合は古いファイルから破棄する
+	_, err = load_msm(context.Background(), src, "238-316", MsmWindow{})
+	assert.NoError(t, err)
+	_, err = load_msm(context.Background(), src, "239-315", MsmWindow{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, cache.Len())
+	_, err = load_msm(context.Background(), src, "238-315", MsmWindow{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, counting.opened["238-315"])
+
+	// 読み込みに失敗した場合はキャッシュしない
+	_, err = load_msm(context.Background(), src, "240-315", MsmWindow{})
+	assert.ErrorIs(t, err, ErrMsmNotFound)
+	assert.Equal(t, 2, cache.Len())
+}
+
+func Test_InterpolateWithOptions_MemoryCache(t *testing.T) {
+	lat, lon := 35.658, 139.741
+	mem := NewMemoryMsmSource()
+	for _, name := range RequiredMsmList(lat, lon) {
+		mem.Put(name, makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 365*24, 0.0))
+	}
+	counting := &syncCountingMsmSource{MsmSource: mem, opened: map[string]int{}}
+
+	opts := NewOptions(lat, lon)
+	opts.StartYear, opts.EndYear = 2011, 2011
+	opts.ElevationMode = ElevationMesh
+	opts.Source = counting
+	opts.UseCache, opts.SaveCache = false, false
+	opts.MemoryCache = NewMsmMemoryCache(0)
+
+	for i := 0; i < 3; i++ {
+		_, err := InterpolateWithOptions(context.Background(), opts)
+		assert.NoError(t, err)
+	}
+	for _, name := range RequiredMsmList(lat, lon) {
+		assert.Equal(t, 1, counting.opened[name], name)
+	}
+}
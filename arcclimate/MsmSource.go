@@ -0,0 +1,286 @@
+package arcclimate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+//--------------------------------------
+// MSMファイルの取得元
+//--------------------------------------
+
+// MSMファイルの既定のダウンロード元URL
+const DefaultMsmURL = "https://s3.ap-northeast-1.wasabisys.com/arcclimate-ja/msm_2011_2020/"
+
+//const DefaultMsmURL = "https://storage.googleapis.com/arcclimate-msm/"
+
+// MSMファイルが取得元に存在しないことを表すエラー
+var ErrMsmNotFound = errors.New("msm file not found")
+
+// MSMファイル {SN}-{WE}.csv.gz の取得元
+type MsmSource interface {
+	// メッシュ地点番号 name ("{SN}-{WE}") のMSMファイル(gzip圧縮CSV)を開きます。
+	// ファイルが存在しない場合は ErrMsmNotFound をラップしたエラーを返します。
+	Open(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// MSMファイル名
+func msmFileName(name string) string {
+	return fmt.Sprintf("%s.csv.gz", name)
+}
+
+// 取得元の指定 spec からMSMファイルの取得元を作成します。
+// spec が空の場合は既定のダウンロード元、"http://" または "https://" で始まる場合はHTTPミラー
+// (カンマ区切りで複数指定した場合は先頭から順に試行)、それ以外の場合はローカルディレクトリとして扱います。
+func ParseMsmSource(spec string) (MsmSource, error) {
+	if spec == "" {
+		return NewHTTPMsmSource(DefaultMsmURL), nil
+	}
+
+	if strings.HasPrefix(spec, "http://") || strings.HasPrefix(spec, "https://") {
+		urls := []string{}
+		for _, u := range strings.Split(spec, ",") {
+			u = strings.TrimSpace(u)
+			if u == "" {
+				continue
+			}
+			if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+				return nil, fmt.Errorf("invalid msm mirror url %q", u)
+			}
+			urls = append(urls, u)
+		}
+		return NewHTTPMsmSource(urls...), nil
+	}
+
+	info, err := os.Stat(spec)
+	if err != nil {
+		return nil, fmt.Errorf("msm source directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("msm source %s is not a directory", spec)
+	}
+	return NewDirMsmSource(spec), nil
+}
+
+//--------------------------------------
+// ローカルディレクトリ
+//--------------------------------------
+
+// ローカルディレクトリ Dir に格納されたMSMファイルを読み取る取得元(読み取り専用)
+type DirMsmSource struct {
+	Dir string
+}
+
+func NewDirMsmSource(dir string) *DirMsmSource {
+	return &DirMsmSource{Dir: dir}
+}
+
+func (s *DirMsmSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	path := filepath.Join(s.Dir, msmFileName(name))
+	log.Printf("MSMファイル読み込み: %s", path)
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", path, ErrMsmNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *DirMsmSource) String() string {
+	return s.Dir
+}
+
+//--------------------------------------
+// HTTPミラー
+//--------------------------------------
+
+// ベースURL BaseURLs からMSMファイルをダウンロードする取得元
+// 複数のベースURLが指定されている場合は、取得に成功するまで先頭から順に試行します。
+// 通信エラーやサーバーエラー(5xx, 429)の場合は、待ち時間を倍にしながら Retries 回まで再試行します。
+type HTTPMsmSource struct {
+	BaseURLs  []string
+	Client    *http.Client  // nil の場合は http.DefaultClient を使用
+	Retries   int           // 1つのURLあたりの再試行回数
+	RetryWait time.Duration // 最初の再試行までの待ち時間
+	Timeout   time.Duration // 1回のダウンロードの制限時間(0の場合は無制限)
+}
+
+func NewHTTPMsmSource(baseURLs ...string) *HTTPMsmSource {
+	urls := make([]string, len(baseURLs))
+	for i, u := range baseURLs {
+		if !strings.HasSuffix(u, "/") {
+			u += "/"
+		}
+		urls[i] = u
+	}
+	return &HTTPMsmSource{
+		BaseURLs:  urls,
+		Retries:   3,
+		RetryWait: time.Second,
+		Timeout:   2 * time.Minute,
+	}
+}
+
+// 再試行の待ち時間の上限
+const maxRetryWait = 30 * time.Second
+
+func (s *HTTPMsmSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	if len(s.BaseURLs) == 0 {
+		return nil, fmt.Errorf("no msm mirror url")
+	}
+
+	notFound := 0
+	var lastErr error
+	for _, base := range s.BaseURLs {
+		src_url := base + msmFileName(name)
+
+		b, err := s.download(ctx, src_url)
+		if err == nil {
+			return io.NopCloser(bytes.NewReader(b)), nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if errors.Is(err, ErrMsmNotFound) {
+			notFound++
+		}
+		log.Printf("MSMダウンロード失敗 %v", err)
+		lastErr = err
+	}
+
+	// すべてのミラーに存在しない場合
+	if notFound == len(s.BaseURLs) {
+		return nil, fmt.Errorf("%s: %w", msmFileName(name), ErrMsmNotFound)
+	}
+	return nil, lastErr
+}
+
+// src_url からダウンロードします。再試行可能なエラーの場合は再試行します。
+func (s *HTTPMsmSource) download(ctx context.Context, src_url string) ([]byte, error) {
+	wait := s.RetryWait
+	for attempt := 0; ; attempt++ {
+		log.Printf("MSMダウンロード %s", src_url)
+
+		b, retryable, err := s.get(ctx, src_url)
+		if err == nil {
+			return b, nil
+		}
+		if !retryable || attempt >= s.Retries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		log.Printf("MSMダウンロード再試行 %v 後 (%d/%d): %v", wait, attempt+1, s.Retries, err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > maxRetryWait {
+			wait = maxRetryWait
+		}
+	}
+}
+
+// src_url から1回ダウンロードします。エラーの場合は再試行によって回復する可能性があるかも返します。
+func (s *HTTPMsmSource) get(ctx context.Context, src_url string) ([]byte, bool, error) {
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, src_url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, true, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		switch {
+		case resp.StatusCode == http.StatusNotFound:
+			return nil, false, fmt.Errorf("%s: %w", src_url, ErrMsmNotFound)
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			return nil, true, fmt.Errorf("%s: %s", src_url, resp.Status)
+		default:
+			return nil, false, fmt.Errorf("%s: %s", src_url, resp.Status)
+		}
+	}
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, true, fmt.Errorf("%s: %w", src_url, err)
+	}
+	if resp.ContentLength >= 0 && int64(len(b)) != resp.ContentLength {
+		return nil, true, fmt.Errorf("%s: truncated response (%d of %d bytes)", src_url, len(b), resp.ContentLength)
+	}
+
+	// エラーページ等を受け取っていないか確認
+	if !isGzip(b) {
+		return nil, false, fmt.Errorf("%s: response is not gzip data", src_url)
+	}
+	countStat(&stats.DownloadBytes, uint64(len(b)))
+
+	return b, false, nil
+}
+
+// gzip形式のデータであるかをマジックナンバーで判定します。
+func isGzip(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0x1f && b[1] == 0x8b
+}
+
+func (s *HTTPMsmSource) String() string {
+	return strings.Join(s.BaseURLs, ",")
+}
+
+//--------------------------------------
+// メモリ
+//--------------------------------------
+
+// メモリ上に保持したMSMファイルを返す取得元(主にテスト用)
+type MemoryMsmSource struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+func NewMemoryMsmSource() *MemoryMsmSource {
+	return &MemoryMsmSource{files: make(map[string][]byte)}
+}
+
+// メッシュ地点番号 name のMSMファイルの内容(gzip圧縮CSV) data を登録します。
+func (s *MemoryMsmSource) Put(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = data
+}
+
+func (s *MemoryMsmSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", msmFileName(name), ErrMsmNotFound)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
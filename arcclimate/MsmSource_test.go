@@ -0,0 +1,208 @@
+package arcclimate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 開始日時 start から hours 時間分のMSMファイル(gzip圧縮CSV)を作成します。
+// 気温 TMP は経過時間 [h] を基準値 base に加えた値とします。
+func makeMsmGz(start time.Time, hours int, base float64) []byte {
+	var csv bytes.Buffer
+	csv.WriteString("date,TMP,MR,DSWRF_est,DSWRF_msm,Ld,VGRD,UGRD,PRES,APCP01\n")
+	for i := 0; i < hours; i++ {
+		date := start.Add(time.Duration(i) * time.Hour)
+		fmt.Fprintf(&csv, "%s,%g,5.0,0.5,,300.0,1.0,-1.0,101325.0,0.0\n",
+			date.Format("2006-01-02 15:04:05"), base+float64(i))
+	}
+
+	return makeMsmGzFromCSV(csv.String())
+}
+
+// CSV csv をgzip圧縮します。
+func makeMsmGzFromCSV(csv string) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte(csv))
+	gw.Close()
+	return buf.Bytes()
+}
+
+func Test_LoadMsmFiles_MemorySource(t *testing.T) {
+	start := time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+	src := NewMemoryMsmSource()
+	src.Put("238-315", makeMsmGz(start, 24, 0.0))
+	src.Put("238-316", makeMsmGz(start, 24, 10.0))
+
+	msms, err := LoadMsmFiles(context.Background(), []string{"238-316", "238-315"}, src)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(msms.Data))
+
+	// 指定した順に格納される
+	assert.Equal(t, "238-316", msms.Data[0].name)
+	assert.Equal(t, 10.0, msms.Data[0].Rows[0].TMP)
+	assert.Equal(t, "238-315", msms.Data[1].name)
+	assert.Equal(t, start.Add(23*time.Hour), msms.Data[1].Rows[23].date)
+}
+
+func Test_LoadMsmFiles_NotFound(t *testing.T) {
+	src := NewMemoryMsmSource()
+	_, err := LoadMsmFiles(context.Background(), []string{"238-315"}, src)
+	assert.ErrorIs(t, err, ErrMsmNotFound)
+	assert.Contains(t, err.Error(), "238-315.csv.gz")
+}
+
+func Test_DirMsmSource(t *testing.T) {
+	dir := t.TempDir()
+	data := makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 3, 0.0)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "238-315.csv.gz"), data, 0644))
+
+	src := NewDirMsmSource(dir)
+
+	r, err := src.Open(context.Background(), "238-315")
+	assert.NoError(t, err)
+	b, _ := io.ReadAll(r)
+	r.Close()
+	assert.Equal(t, data, b)
+
+	_, err = src.Open(context.Background(), "238-316")
+	assert.ErrorIs(t, err, ErrMsmNotFound)
+}
+
+func Test_HTTPMsmSource_Failover(t *testing.T) {
+	data := makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 3, 0.0)
+
+	// 1番目のミラーは障害中
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/msm/238-315.csv.gz" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	defer mirror.Close()
+
+	src := NewHTTPMsmSource(broken.URL+"/msm", mirror.URL+"/msm/")
+	src.RetryWait = time.Millisecond
+
+	r, err := src.Open(context.Background(), "238-315")
+	assert.NoError(t, err)
+	b, _ := io.ReadAll(r)
+	r.Close()
+	assert.Equal(t, data, b)
+
+	// どのミラーにも存在しない
+	_, err = NewHTTPMsmSource(mirror.URL+"/msm/").Open(context.Background(), "238-316")
+	assert.ErrorIs(t, err, ErrMsmNotFound)
+}
+
+func Test_ParseMsmSource(t *testing.T) {
+	src, err := ParseMsmSource("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{DefaultMsmURL}, src.(*HTTPMsmSource).BaseURLs)
+
+	src, err = ParseMsmSource("http://a.example/msm, https://b.example/msm/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://a.example/msm/", "https://b.example/msm/"}, src.(*HTTPMsmSource).BaseURLs)
+
+	dir := t.TempDir()
+	src, err = ParseMsmSource(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, dir, src.(*DirMsmSource).Dir)
+
+	_, err = ParseMsmSource(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func Test_HTTPMsmSource_Retry(t *testing.T) {
+	data := makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 3, 0.0)
+
+	// 2回失敗した後に成功する
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case 2:
+			// 途中で切断された応答
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			w.Write(data[:len(data)/2])
+		default:
+			w.Write(data)
+		}
+	}))
+	defer server.Close()
+
+	src := NewHTTPMsmSource(server.URL)
+	src.RetryWait = time.Millisecond
+
+	r, err := src.Open(context.Background(), "238-315")
+	assert.NoError(t, err)
+	b, _ := io.ReadAll(r)
+	r.Close()
+	assert.Equal(t, data, b)
+	assert.Equal(t, 3, requests)
+}
+
+func Test_HTTPMsmSource_NoRetry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/html/238-315.csv.gz" {
+			w.Write([]byte("<html>maintenance</html>"))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	// 存在しない場合は再試行しない
+	src := NewHTTPMsmSource(server.URL)
+	src.RetryWait = time.Millisecond
+	_, err := src.Open(context.Background(), "238-315")
+	assert.ErrorIs(t, err, ErrMsmNotFound)
+	assert.Equal(t, 1, requests)
+
+	// gzip形式でない応答は受け付けない
+	src = NewHTTPMsmSource(server.URL + "/html")
+	src.RetryWait = time.Millisecond
+	_, err = src.Open(context.Background(), "238-315")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrMsmNotFound)
+}
+
+func Test_HTTPMsmSource_Canceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	src := NewHTTPMsmSource(server.URL)
+	src.Retries = 100
+	src.RetryWait = 10 * time.Millisecond
+
+	begin := time.Now()
+	_, err := src.Open(ctx, "238-315")
+	assert.Error(t, err)
+	assert.Less(t, time.Since(begin), 5*time.Second)
+}
@@ -28,6 +28,11 @@ type MsmTarget struct {
 	h     []float64 //13.参照時刻時点の太陽高度角 (単位:°)
 	A     []float64 //14.参照時刻時点の太陽方位角 (単位:°)
 
+	// 風向風速 W_spd, W_dir の計算条件 (nil の場合は出力形式ごとの既定値)
+	WindDirection *WindDirection
+	// 静穏とみなす風速の上限 [m/s] (HAS形式の風向 0)
+	WindCalm float64
+
 	NR []float64 //夜間放射量[MJ/m2]
 
 	RH []float64 //	float64: 相対湿度[%]
@@ -36,9 +41,20 @@ type MsmTarget struct {
 	//計算対象時刻の露点温度(℃)
 	DT []float64
 
+	//湿り空気の状態値 (Psychrometrics で計算した場合のみ)
+	TWB  []float64 //湿球温度 [℃]
+	HR   []float64 //絶対湿度(湿度比) [kg/kg(DA)]
+	ENTH []float64 //比エンタルピー [kJ/kg(DA)]
+	RHO  []float64 //湿り空気の密度 [kg/m3]
+	SV   []float64 //比容積 [m3/kg(DA)]
+
 	//直散分離用
 	SR_est []SolarRadiation //直散分離結果(推定日射量 DSWRF_est に基づく)
 	SR_msm []SolarRadiation //直散分離結果(日射量 DSWRF_msm に基づく)
+
+	Spread *MsmSpread //周囲のMSM地点の標高補正後の値とばらつき (Options.Spread を指定した場合のみ)
+
+	Metadata *RunMetadata //推定結果の作成に使用した条件
 }
 
 // 開始年 start_year から 終了年 end_year までのデータを抜き出して新しい構造体を作成します。
@@ -73,6 +89,11 @@ func (df_msm *MsmTarget) ExctactMsm(start_time time.Time, end_time time.Time) *M
 		DT:     append([]float64{}, df_msm.DT[start_index:end_index+1]...),
 		SR_est: append([]SolarRadiation{}, df_msm.SR_est[start_index:end_index+1]...),
 		SR_msm: append([]SolarRadiation{}, df_msm.SR_msm[start_index:end_index+1]...),
+
+		WindDirection: df_msm.WindDirection,
+		WindCalm:      df_msm.WindCalm,
+
+		Metadata: df_msm.Metadata,
 	}
 	if df_msm.DSWRF != nil {
 		msm.DSWRF = append([]float64{}, df_msm.DSWRF[start_index:end_index+1]...)
@@ -89,6 +110,16 @@ func (df_msm *MsmTarget) ExctactMsm(start_time time.Time, end_time time.Time) *M
 	if df_msm.W_dir != nil {
 		msm.W_dir = append([]float64{}, df_msm.W_dir[start_index:end_index+1]...)
 	}
+	if df_msm.Spread != nil {
+		msm.Spread = df_msm.Spread.slice(start_index, end_index+1)
+	}
+	if df_msm.TWB != nil {
+		msm.TWB = append([]float64{}, df_msm.TWB[start_index:end_index+1]...)
+		msm.HR = append([]float64{}, df_msm.HR[start_index:end_index+1]...)
+		msm.ENTH = append([]float64{}, df_msm.ENTH[start_index:end_index+1]...)
+		msm.RHO = append([]float64{}, df_msm.RHO[start_index:end_index+1]...)
+		msm.SV = append([]float64{}, df_msm.SV[start_index:end_index+1]...)
+	}
 
 	return &msm
 }
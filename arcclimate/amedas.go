@@ -0,0 +1,200 @@
+package arcclimate
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+//--------------------------------------
+// アメダス観測所
+//--------------------------------------
+
+// アメダス観測所
+type AmedasStation struct {
+	ID        string  `json:"id"`        // 観測所番号 (例: "44132")
+	Name      string  `json:"name"`      // 観測所名
+	Lat       float64 `json:"lat"`       // 緯度 (10進法)
+	Lon       float64 `json:"lon"`       // 経度 (10進法)
+	Elevation float64 `json:"elevation"` // 観測所の標高 [m]
+}
+
+// 観測所番号をキーとするアメダス観測所の一覧
+type AmedasTable map[string]AmedasStation
+
+// 組み込みの観測所一覧。gen_amedas.go で気象庁のアメダス観測所一覧 (amedastable.json) から作成します。
+//
+//go:generate go run gen_amedas.go
+const amedasStationsFile = "data/amedas_stations.csv"
+
+//go:embed data/amedas_stations.csv
+var amedasStationsFS embed.FS
+
+// 組み込みのアメダス観測所一覧を返します。
+// 組み込みの一覧は主な観測所(各都道府県の気象台等)のみです。
+// その他の観測所は、ReadAmedasTable で気象庁のアメダス観測所一覧等を読み込んでください。
+func DefaultAmedasTable() (AmedasTable, error) {
+	content, err := amedasStationsFS.ReadFile(amedasStationsFile)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", amedasStationsFile, err)
+	}
+	table, err := ReadAmedasTable(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", amedasStationsFile, err)
+	}
+	return table, nil
+}
+
+// 組み込みの一覧から観測所番号 id のアメダス観測所を返します。
+func LookupAmedasStation(id string) (AmedasStation, error) {
+	table, err := DefaultAmedasTable()
+	if err != nil {
+		return AmedasStation{}, err
+	}
+	return table.Lookup(id)
+}
+
+// 観測所番号 id のアメダス観測所を返します。
+func (table AmedasTable) Lookup(id string) (AmedasStation, error) {
+	st, ok := table[strings.TrimSpace(id)]
+	if !ok {
+		return AmedasStation{}, fmt.Errorf("unknown AMeDAS station %q", id)
+	}
+	return st, nil
+}
+
+// アメダス観測所一覧を読み込みます。
+// CSV形式の場合は、1行目は列名 (id,lat,lon,elevation は必須、name は省略可) とします。
+// 気象庁のアメダス観測所一覧 (amedastable.json) も読み込めます。
+func ReadAmedasTable(r io.Reader) (AmedasTable, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil || !unicode.IsSpace(rune(b[0])) {
+			break
+		}
+		br.ReadByte()
+	}
+	if b, err := br.Peek(1); err == nil && b[0] == '{' {
+		return readJMAAmedasTable(br)
+	}
+	return readAmedasTableCSV(br)
+}
+
+// 気象庁のアメダス観測所一覧 (JSON) の観測所
+// 緯度・経度は [度, 分] です。
+type jmaAmedasStation struct {
+	Lat    []float64 `json:"lat"`
+	Lon    []float64 `json:"lon"`
+	Alt    *float64  `json:"alt"`
+	KjName string    `json:"kjName"`
+}
+
+// 気象庁のアメダス観測所一覧 (JSON、観測所番号をキーとする) を読み込みます。
+func readJMAAmedasTable(r io.Reader) (AmedasTable, error) {
+	var stations map[string]jmaAmedasStation
+	if err := json.NewDecoder(r).Decode(&stations); err != nil {
+		return nil, err
+	}
+
+	table := make(AmedasTable, len(stations))
+	for id, s := range stations {
+		if len(s.Lat) != 2 || len(s.Lon) != 2 || s.Alt == nil {
+			return nil, fmt.Errorf("station %q: lat, lon ([degrees, minutes]) and alt are required", id)
+		}
+		table[id] = AmedasStation{
+			ID:        id,
+			Name:      s.KjName,
+			Lat:       s.Lat[0] + s.Lat[1]/60,
+			Lon:       s.Lon[0] + s.Lon[1]/60,
+			Elevation: *s.Alt,
+		}
+	}
+	if len(table) == 0 {
+		return nil, fmt.Errorf("no stations")
+	}
+	return table, nil
+}
+
+// CSV形式のアメダス観測所一覧を読み込みます。
+func readAmedasTableCSV(r io.Reader) (AmedasTable, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no header")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	for _, name := range []string{"id", "lat", "lon", "elevation"} {
+		if _, ok := columns[name]; !ok {
+			return nil, fmt.Errorf("column %q is required", name)
+		}
+	}
+
+	table := make(AmedasTable, len(records)-1)
+	for line, record := range records[1:] {
+		number := func(name string) (float64, error) {
+			v := strings.TrimSpace(record[columns[name]])
+			x, err := strconv.ParseFloat(v, 64)
+			if err != nil || math.IsNaN(x) || math.IsInf(x, 0) {
+				return math.NaN(), fmt.Errorf("line %d: invalid %s %q", line+2, name, v)
+			}
+			return x, nil
+		}
+
+		st := AmedasStation{ID: strings.TrimSpace(record[columns["id"]])}
+		if i, ok := columns["name"]; ok {
+			st.Name = strings.TrimSpace(record[i])
+		}
+		if st.ID == "" {
+			return nil, fmt.Errorf("line %d: id is required", line+2)
+		}
+		if _, ok := table[st.ID]; ok {
+			return nil, fmt.Errorf("line %d: duplicate id %q", line+2, st.ID)
+		}
+		if st.Lat, err = number("lat"); err != nil {
+			return nil, err
+		}
+		if st.Lon, err = number("lon"); err != nil {
+			return nil, err
+		}
+		if st.Elevation, err = number("elevation"); err != nil {
+			return nil, err
+		}
+		table[st.ID] = st
+	}
+
+	return table, nil
+}
+
+// アメダス観測所 st の位置を推計対象地点とし、観測所の標高を推計対象地点の標高とします。
+func (opts *Options) SetAmedasStation(st AmedasStation) {
+	elevation := st.Elevation
+	opts.Lat, opts.Lon = st.Lat, st.Lon
+	opts.Elevation = &elevation
+}
+
+// 標準地域メッシュコード meshcode の中心を推計対象地点とします。
+func (opts *Options) SetMeshCode(meshcode string) error {
+	lat, lon, err := MeshCodeLatLon(meshcode)
+	if err != nil {
+		return err
+	}
+	opts.Lat, opts.Lon = lat, lon
+	return nil
+}
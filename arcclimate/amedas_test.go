@@ -0,0 +1,83 @@
+package arcclimate
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LookupAmedasStation(t *testing.T) {
+	st, err := LookupAmedasStation("44132")
+	assert.NoError(t, err)
+	assert.Equal(t, "東京", st.Name)
+	assert.InDelta(t, 35.69, st.Lat, 0.01)
+	assert.InDelta(t, 139.75, st.Lon, 0.01)
+	assert.InDelta(t, 25.2, st.Elevation, 0.01)
+
+	_, err = LookupAmedasStation("00000")
+	assert.Error(t, err)
+
+	// 観測所の位置と標高を推計対象地点とする
+	opts := NewOptions(0, 0)
+	opts.SetAmedasStation(st)
+	assert.Equal(t, st.Lat, opts.Lat)
+	assert.Equal(t, st.Lon, opts.Lon)
+	if assert.NotNil(t, opts.Elevation) {
+		assert.Equal(t, st.Elevation, *opts.Elevation)
+	}
+	assert.NoError(t, opts.Validate())
+}
+
+func Test_ReadAmedasTable(t *testing.T) {
+	table, err := ReadAmedasTable(strings.NewReader("id,lat,lon,elevation\n99999,35.1,139.2,10.5\n"))
+	assert.NoError(t, err)
+	st, err := table.Lookup("99999")
+	assert.NoError(t, err)
+	assert.Equal(t, AmedasStation{ID: "99999", Lat: 35.1, Lon: 139.2, Elevation: 10.5}, st)
+
+	// 不正な一覧
+	for _, s := range []string{
+		"",
+		"id,lat,lon\n1,35,139\n",
+		"id,lat,lon,elevation\n1,35,x,0\n",
+		"id,lat,lon,elevation\n1,35,139,0\n1,36,139,0\n",
+	} {
+		_, err := ReadAmedasTable(strings.NewReader(s))
+		assert.Error(t, err, s)
+	}
+}
+
+// 気象庁のアメダス観測所一覧 (amedastable.json) の形式
+func Test_ReadAmedasTable_JMA(t *testing.T) {
+	const table = ` {
+		"11001":{"type":"C","elems":"11112010","lat":[45,31.2],"lon":[141,56.1],"alt":26,"kjName":"宗谷岬","knName":"ソウヤミサキ","enName":"Soyamisaki"},
+		"44132":{"type":"A","elems":"11111111","lat":[35,41.5],"lon":[139,45.0],"alt":25,"kjName":"東京","knName":"トウキョウ","enName":"Tokyo"}
+	}`
+	tbl, err := ReadAmedasTable(strings.NewReader(table))
+	assert.NoError(t, err)
+	assert.Len(t, tbl, 2)
+
+	// 気象台以外の観測所
+	st, err := tbl.Lookup("11001")
+	assert.NoError(t, err)
+	assert.Equal(t, "宗谷岬", st.Name)
+	assert.InDelta(t, 45.52, st.Lat, 1e-9)
+	assert.InDelta(t, 141.935, st.Lon, 1e-9)
+	assert.Equal(t, 26.0, st.Elevation)
+
+	st, err = tbl.Lookup("44132")
+	assert.NoError(t, err)
+	assert.InDelta(t, 35.6917, st.Lat, 1e-4)
+	assert.InDelta(t, 139.75, st.Lon, 1e-9)
+
+	for _, s := range []string{
+		`{}`,
+		`{"11001":{"lat":[45],"lon":[141,56.1],"alt":26}}`,
+		`{"11001":{"lat":[45,31.2],"lon":[141,56.1]}}`,
+		`{"11001":`,
+	} {
+		_, err := ReadAmedasTable(strings.NewReader(s))
+		assert.Error(t, err, s)
+	}
+}
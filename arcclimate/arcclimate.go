@@ -2,8 +2,10 @@
 package arcclimate
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"math"
 	"time"
 
 	"github.com/hhkbp2/go-logging"
@@ -13,6 +15,8 @@ import (
 // 標準年の計算を行う場合は mode = "EA" とし、それ以外の場合は EA = "normal" とします。
 // 標準年データの検討に日射量の推計値を使用する場合は useEst = True とします。（使用しない場合2018年以降のデータのみで作成）
 // 出力する気象データの期間は開始年startYearから終了年endYearまでです。ただし、標準年の計算をする場合は、検討期間として解釈します。
+//
+// Deprecated: 計算に失敗した場合にパニックが発生します。 InterpolateWithOptions を使用してください。
 func Interpolate(
 	lat float64,
 	lon float64,
@@ -26,45 +30,236 @@ func Interpolate(
 	saveCache bool,
 	msmFileDir string) *MsmTarget {
 
+	opts := Options{
+		Lat:              lat,
+		Lon:              lon,
+		StartYear:        startYear,
+		EndYear:          endYear,
+		Mode:             Mode(mode),
+		ElevationMode:    ElevationMode(modeEle),
+		SeparationMethod: SeparationMethod(modeSep),
+		UseEst:           useEst,
+		UseCache:         useCache,
+		SaveCache:        saveCache,
+		MsmFileDir:       msmFileDir,
+	}
+
+	msm, err := InterpolateWithOptions(context.Background(), opts)
+	if err != nil {
+		panic(err)
+	}
+
+	return msm
+}
+
+// 計算条件 opts で表される推計対象地点の周囲のMSMデータを利用して空間補間計算を行います。
+// 計算条件が不正な場合や、MSMファイル・標高データの読み込みに失敗した場合はエラーを返します。
+func InterpolateWithOptions(ctx context.Context, opts Options) (*MsmTarget, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+
+	lat, lon := opts.Lat, opts.Lon
+
 	log.Printf("データ読み込み")
 
 	// MSM地点の標高データの読込
-	ele := NewElevationMaster(lat, lon)
+	ele, err := NewElevationMaster(lat, lon)
+	if err != nil {
+		return nil, err
+	}
 
 	// 必要なMSMファイル名の一覧を緯度経度から取得
-	msmList := RequiredMsmList(lat, lon)
+	msmList := RequiredMsmListN(lat, lon, opts.neighborhood())
+
+	// MSMファイルの取得元
+	srcs, err := opts.msmSources(msmList)
+	if err != nil {
+		return nil, err
+	}
 
 	// MSMファイルの読込 (0.2s; 4 MSM from cache)
-	msms := LoadMsmFiles(msmList, useCache, saveCache, msmFileDir)
+	// 計算に必要な期間(前後の余裕を含む)のみを読み込む
+	msms, err := LoadMsmArchives(ctx, msmList, srcs, opts.Precedence, opts.window())
+	if err != nil {
+		return nil, err
+	}
+
+	return opts.interpolate(ctx, lat, lon, msms, ele)
+}
+
+// 推計対象地点の空間補間に使用する周囲のMSM地点と標高
+type neighborPoints struct {
+	method InterpolationMethod // 空間補間の方法
+	power  float64             // 逆距離加重のべき数
+	n      int                 // 周囲のMSM地点数
+
+	names      []string  // 周囲のMSM地点のメッシュ地点番号 (RequiredMsmListN と同じ順)
+	weights    []float64 // 各MSM地点の按分の重み (海上の地点を除外した場合は補正後)
+	elevations []float64 // 各MSM地点の平均標高 [m]
+	excluded   []string  // 除外した海上のMSM地点
+
+	elevation float64       // 推計対象地点の標高 [m]
+	modeEle   ElevationMode // 実際に使用した標高の判定方法
+}
+
+// 計算条件 opts に従い、緯度 lat, 経度 lon の地点の空間補間に使用する周囲のMSM地点と重み、標高を求めます。
+// MSMファイルは読み込みません。
+func (opts *Options) neighborPoints(ctx context.Context, lat float64, lon float64, ele *ElevationMaster) (*neighborPoints, error) {
+	// オフラインの場合は国土地理院のAPIは使用しない
+	modeEle := opts.ElevationMode
+	if p := opts.ElevationProvider; p != nil {
+		modeEle = p.Mode()
+	}
+	if opts.Offline && modeEle == ElevationAPI {
+		log.Printf("オフラインのため3次メッシュの平均標高を使用します")
+		modeEle = ElevationMesh
+	}
+
+	// 空間補間の重みと周囲のMSMの標高
+	p := &neighborPoints{n: opts.neighborhood()}
+	p.method, p.power = opts.interpolation()
+	p.names = RequiredMsmListN(lat, lon, p.n)
+	var err error
+	p.weights, err = InterpolationWeightsN(lat, lon, p.method, p.power, p.n)
+	if err != nil {
+		return nil, err
+	}
+	p.elevations = ElevationsN(lat, lon, ele, p.n)
+	if opts.ExcludeSea {
+		var idx []int
+		p.weights, idx = excludeSeaPoints(p.weights, p.elevations)
+		for _, i := range idx {
+			p.excluded = append(p.excluded, p.names[i])
+		}
+	}
+	log.Printf("空間補間 %s (%d地点) 重み %v", p.method, p.n, p.weights)
+
+	// 推計対象地点の標高
+	if opts.Elevation != nil {
+		log.Printf("指定された標高 %fm で計算します", *opts.Elevation)
+		p.elevation, p.modeEle = *opts.Elevation, ElevationFixed
+	} else if pr := opts.ElevationProvider; pr != nil && modeEle != ElevationMesh {
+		p.elevation, p.modeEle, err = elevationFromProvider(ctx, pr, lat, lon, ele)
+	} else {
+		p.elevation, p.modeEle, err = elevationFromLatLon(ctx, lat, lon, modeEle, ele)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// 計算条件 opts に従い、読み込み済みの周囲4地点のMSMデータ msms から緯度 lat, 経度 lon の地点の気象データを作成します。
+// msms は変更しないため、複数の地点で共有できます。
+func (opts *Options) interpolate(
+	ctx context.Context,
+	lat float64,
+	lon float64,
+	msms MsmDataSet,
+	ele *ElevationMaster) (*MsmTarget, error) {
+
+	// 周囲4地点のデータの期間の確認
+	period, err := msms.Period()
+	if err != nil {
+		return nil, err
+	}
+	first, last := period.Years()
+	log.Printf("MSMデータの期間 %d-%d (%s - %s)", first, last,
+		period.Start.Format("2006-01-02 15:04"), period.End.Format("2006-01-02 15:04"))
+	if err := period.CheckYears(opts.StartYear, opts.EndYear); err != nil {
+		return nil, err
+	}
 
 	log.Printf("補正計算")
 
-	// 周囲4地点のMSMデータフレームから標高補正したMSMデータフレームを作成
-	msm := PrportionalDivided(lat, lon, msms, ele, modeEle, modeSep)
+	// 空間補間の重み、周囲のMSMの標高と推計対象地点の標高
+	p, err := opts.neighborPoints(ctx, lat, lon, ele)
+	if err != nil {
+		return nil, err
+	}
+	method, power, n := p.method, p.power, p.n
+	weights, elevations, excluded := p.weights, p.elevations, p.excluded
+	ele_target, modeEle := p.elevation, p.modeEle
+
+	// 周囲のMSMデータフレームから標高補正したMSMデータフレームを作成
+	lapse := opts.lapseRate()
+	if lapse != DefaultLapseRate {
+		log.Printf("気温減率 %s で標高補正します", lapse)
+	}
+	msm := prportionalDividedAt(lat, lon, msms, weights, elevations, ele_target, lapse, opts.Spread, opts.Wind, modeEle, opts.SeparationMethod)
+
+	meta := msm.Metadata
+	meta.Interpolation = method
+	meta.Neighborhood = n
+	meta.ExcludedSeaPoints = excluded
+	if method == InterpolationIDW {
+		meta.IDWPower = power
+	}
+	meta.Mode = opts.Mode
+	meta.StartYear = opts.StartYear
+	meta.EndYear = opts.EndYear
+	meta.UseEst = opts.UseEst
+	meta.Offline = opts.Offline
+	meta.DataStart = period.Start
+	meta.DataEnd = period.End
+
+	// 推計対象地点の標高の確認
+	if meta.ElevationMode == ElevationMesh && !ele.hasElevation3d(lat, lon) {
+		w := "no mesh elevation data for the target point (sea or outside the data), 0m is used"
+		log.Printf("警告: %s", w)
+		meta.Warnings = append(meta.Warnings, w)
+	}
+	if len(excluded) > 0 {
+		log.Printf("海上のMSM地点 %v を除外しました", excluded)
+	}
+	names := msms.Names()
+	if n == Neighborhood4 {
+		names = []string{"SW", "SE", "NW", "NE"}
+	}
+	meta.Warnings = append(meta.Warnings, elevationWarnings(meta.Elevation, elevations, weights, names, opts.ElevationWarning)...)
 
-	if mode == "normal" {
-		// 保存用に年月日をフィルタ
-		return msm.ExctactMsmYear(startYear, endYear)
-	} else if mode == "EA" {
+	if len(opts.Archives) > 0 {
+		for _, a := range opts.Archives {
+			meta.Archives = append(meta.Archives, a.Name)
+		}
+		meta.Precedence = opts.Precedence
+	}
+
+	var res *MsmTarget
+	if opts.Mode == ModeEA {
 		// 標準年の計算
-		log.Printf("標準年計算 %d-%d", startYear, endYear)
-		return msm.EA(startYear, endYear, useEst)
+		log.Printf("標準年計算 %d-%d", opts.StartYear, opts.EndYear)
+		res = msm.EA(opts.StartYear, opts.EndYear, opts.UseEst)
+	} else {
+		// 保存用に年月日をフィルタ
+		res = msm.ExctactMsmYear(opts.StartYear, opts.EndYear)
+	}
+
+	// 風向風速の計算条件の指定
+	if opts.WindDirection != nil {
+		log.Printf("風向風速を再計算 (%s)", opts.WindDirection)
+		res.WindVectorToDirAndSpeed(*opts.WindDirection)
+		res.WindDirection = opts.WindDirection
+	}
+	res.WindCalm = opts.WindCalm
+
+	// 湿り空気の状態値の計算
+	if opts.Psychrometrics {
+		log.Print("湿球温度・比エンタルピー・比容積の計算")
+		res.Psychrometrics()
 	}
+	meta.WindDirection = opts.WindDirection
+	meta.WindCalm = opts.WindCalm
+	res.Metadata = meta
 
-	panic(mode)
+	return res, nil
 }
 
 // 緯度 lat, 経度 lon の周囲4地点のメッシュ地点番号を返します。
 func RequiredMsmList(lat float64, lon float64) []string {
-	MSM_S, MSM_N, MSM_W, MSM_E := Meshcode1d(lat, lon)
-
-	// 周囲4地点のメッシュ地点番号
-	MSM_SW := fmt.Sprintf("%d-%d", MSM_S, MSM_W)
-	MSM_SE := fmt.Sprintf("%d-%d", MSM_S, MSM_E)
-	MSM_NW := fmt.Sprintf("%d-%d", MSM_N, MSM_W)
-	MSM_NE := fmt.Sprintf("%d-%d", MSM_N, MSM_E)
-
-	return []string{MSM_SW, MSM_SE, MSM_NW, MSM_NE}
+	// 周囲4地点のメッシュ地点番号 (SW,SE,NW,NEの順)
+	return RequiredMsmListN(lat, lon, Neighborhood4)
 }
 
 // 緯度 lat, 経度 lon から計算に必要なMSMを決定し、各地点の標高を返す。
@@ -81,35 +276,74 @@ func Elevations(lat float64, lon float64, eleMstr *ElevationMaster) [4]float64 {
 	return [4]float64{ele_SW, ele_SE, ele_NW, ele_NE}
 }
 
-// 緯度 lat, 経度 lon の標高補正を行います。
+// 緯度 lat, 経度 lon の標高補正を行います。周囲4地点の按分には逆距離加重(べき数1)の重みを使用します。
+// 空間補間の方法を選択する場合は Options.Interpolation を指定して InterpolateWithOptions を使用してください。
 func PrportionalDivided(
+	ctx context.Context,
 	lat float64,
 	lon float64,
 	msms MsmDataSet,
 	eleMstr *ElevationMaster,
-	modeEle string,
-	modeSep string) *MsmTarget {
+	modeEle ElevationMode,
+	modeSep SeparationMethod) (*MsmTarget, error) {
 	logger := logging.GetLogger("arcclimate")
 	logger.Infof("補間計算を実行します")
 
+	// 周囲4地点の参照時刻が一致しない場合は按分できない
+	if _, err := msms.Period(); err != nil {
+		return nil, err
+	}
+
 	// 緯度経度から標高を取得
-	ele_target := ElevationFromLatLon(
+	ele_target, modeEle, err := elevationFromLatLon(
+		ctx,
 		lat,
 		lon,
 		modeEle,
 		eleMstr,
 	)
+	if err != nil {
+		return nil, err
+	}
 
 	// 補間計算 リストはいずれもSW南西,SE南東,NW北西,NE北東の順
 	// 入力した緯度経度から周囲のMSMまでの距離を算出して、距離の重みづけ係数をリストで返す
-	weights := MsmWeights(lat, lon)
+	weights, err := MsmWeights(lat, lon)
+	if err != nil {
+		return nil, err
+	}
 
 	// 計算に必要なMSMを算出して、MSM位置の標高を探してリストで返す
 	elevations := Elevations(lat, lon, eleMstr)
 
+	msm_target := prportionalDividedAt(lat, lon, msms, weights[:], elevations[:], ele_target, DefaultLapseRate, false, nil, modeEle, modeSep)
+	msm_target.Metadata.Interpolation = InterpolationIDW
+	msm_target.Metadata.IDWPower = 1
+	msm_target.Metadata.Neighborhood = Neighborhood4
+	return msm_target, nil
+}
+
+// PrportionalDivided と同様に、周囲のMSM地点の重み weights と標高 elevations (msms と同じ順) で按分し、
+// 標高 ele_target [m] の地点の気象データを作成します。標高補正には気温減率 lapse を使用します。
+// spread が true の場合は、周囲の地点の標高補正後の値とばらつきを MsmTarget.Spread に保持します。
+// wind を指定した場合は、風向風速の計算前に風速を換算します。
+// modeEle は標高の判定方法として計算条件の記録に使用します。周囲の地点の参照時刻は確認済みとします。
+func prportionalDividedAt(
+	lat float64,
+	lon float64,
+	msms MsmDataSet,
+	weights []float64,
+	elevations []float64,
+	ele_target float64,
+	lapse LapseRate,
+	spread bool,
+	wind *WindConversion,
+	modeEle ElevationMode,
+	modeSep SeparationMethod) *MsmTarget {
+
 	// 周囲のMSMの気象データを読み込んで標高補正後に按分する
 	log.Print("周囲のMSMの気象データを読み込んで標高補正後に按分する")
-	msm_target := msms.PrportionalDivided(weights, elevations, ele_target)
+	msm_target := msms.prportionalDivided(weights, elevations, ele_target, lapse, spread)
 
 	// 相対湿度・飽和水蒸気圧・露点温度の計算
 	log.Print("相対湿度・飽和水蒸気圧・露点温度の計算")
@@ -117,7 +351,7 @@ func PrportionalDivided(
 
 	// 水平面全天日射量の直散分離
 	log.Print("水平面全天日射量の直散分離")
-	msm_target.SeparateSolarRadiation(lat, lon, ele_target, modeSep)
+	msm_target.SeparateSolarRadiation(lat, lon, ele_target, string(modeSep))
 
 	// 大気放射量の単位をMJ/m2に換算
 	log.Print("大気放射量の単位をMJ/m2に換算")
@@ -127,29 +361,66 @@ func PrportionalDivided(
 	log.Print("夜間放射量の計算")
 	msm_target.CalcNocturnalRadiation()
 
+	// 風速の高さ・地表面粗度の換算
+	var windFactor float64
+	if wind != nil {
+		windFactor = wind.Factor()
+		log.Printf("風速を高さ %gm (%s) に換算 倍率 %f", wind.Height, wind.Profile, windFactor)
+		msm_target.ScaleWind(windFactor)
+	}
+
 	// ベクトル風速から16方位の風向風速を計算
 	log.Print("ベクトル風速から16方位の風向風速を計算")
-	msm_target.WindVectorToDirAndSpeed()
+	msm_target.WindVectorToDirAndSpeed(DefaultWindDirection)
+
+	msm_target.Metadata = &RunMetadata{
+		Lat:              lat,
+		Lon:              lon,
+		SeparationMethod: modeSep,
+		Elevation:        ele_target,
+		ElevationMode:    modeEle,
+		MsmFiles:         msms.Names(),
+		MsmWeights:       weights,
+		LapseRate:        lapse.String(),
+		WindConversion:   wind,
+		WindFactor:       windFactor,
+	}
 
 	return msm_target
 }
 
 // 周囲のMSMの気象データから目標地点(標高 ele_target [m])の気象データを作成する。
 // 按分には、目標地点と各周辺の地点の平均標高 elevations [m] と 地点間の距離から求めた重み weights を用いる。
+// weights, elevations は msms.Data と同じ順 (4地点の場合は SW,SE,NW,NE) とします。
+// 標高補正の気温減率は lapse とします(nil の場合は DefaultLapseRate)。
 func (msms *MsmDataSet) PrportionalDivided(
-	weights [4]float64,
-	elevations [4]float64,
-	ele_target float64) *MsmTarget {
+	weights []float64,
+	elevations []float64,
+	ele_target float64,
+	lapse LapseRate) *MsmTarget {
+	return msms.prportionalDivided(weights, elevations, ele_target, lapse, false)
+}
 
-	// 標高補正 (SW,SE,NW,NE)
-	msm_SW := msms.Data[0].CorrectedMsm_TMP_PRES_MR(elevations[0], ele_target)
-	msm_SE := msms.Data[1].CorrectedMsm_TMP_PRES_MR(elevations[1], ele_target)
-	msm_NW := msms.Data[2].CorrectedMsm_TMP_PRES_MR(elevations[2], ele_target)
-	msm_NE := msms.Data[3].CorrectedMsm_TMP_PRES_MR(elevations[3], ele_target)
+// MsmDataSet.PrportionalDivided と同様に按分します。
+// spread が true の場合は、周囲の地点の標高補正後の値とばらつきを MsmTarget.Spread に保持します。
+func (msms *MsmDataSet) prportionalDivided(
+	weights []float64,
+	elevations []float64,
+	ele_target float64,
+	lapse LapseRate,
+	spread bool) *MsmTarget {
+	if lapse == nil {
+		lapse = DefaultLapseRate
+	}
+
+	// 標高補正
+	corrected := make([]*MsmData, len(msms.Data))
+	for j := range msms.Data {
+		corrected[j] = msms.Data[j].CorrectedMsm_TMP_PRES_MR(elevations[j], ele_target, lapse)
+	}
 
 	// 重みづけによる按分
-	l := msm_SW.Length()
-	w_SW, w_SE, w_NW, w_NE := weights[0], weights[1], weights[2], weights[3]
+	l := corrected[0].Length()
 
 	msm_target := MsmTarget{
 		date:      make([]time.Time, l),
@@ -164,20 +435,30 @@ func (msms *MsmDataSet) PrportionalDivided(
 		APCP01:    make([]float64, l),
 	}
 	for i := 0; i < l; i++ {
-		row_SW := msm_SW.Rows[i]
-		row_SE := msm_SE.Rows[i]
-		row_NW := msm_NW.Rows[i]
-		row_NE := msm_NE.Rows[i]
-		msm_target.date[i] = row_SW.date
-		msm_target.TMP[i] = w_SW*row_SW.TMP + w_SE*row_SE.TMP + w_NW*row_NW.TMP + w_NE*row_NE.TMP
-		msm_target.MR[i] = w_SW*row_SW.MR + w_SE*row_SE.MR + w_NW*row_NW.MR + w_NE*row_NE.MR
-		msm_target.DSWRF_est[i] = w_SW*row_SW.DSWRF_est + w_SE*row_SE.DSWRF_est + w_NW*row_NW.DSWRF_est + w_NE*row_NE.DSWRF_est
-		msm_target.DSWRF_msm[i] = w_SW*row_SW.DSWRF_msm + w_SE*row_SE.DSWRF_msm + w_NW*row_NW.DSWRF_msm + w_NE*row_NE.DSWRF_msm
-		msm_target.Ld[i] = w_SW*row_SW.Ld + w_SE*row_SE.Ld + w_NW*row_NW.Ld + w_NE*row_NE.Ld
-		msm_target.VGRD[i] = w_SW*row_SW.VGRD + w_SE*row_SE.VGRD + w_NW*row_NW.VGRD + w_NE*row_NE.VGRD
-		msm_target.UGRD[i] = w_SW*row_SW.UGRD + w_SE*row_SE.UGRD + w_NW*row_NW.UGRD + w_NE*row_NE.UGRD
-		msm_target.PRES[i] = w_SW*row_SW.PRES + w_SE*row_SE.PRES + w_NW*row_NW.PRES + w_NE*row_NE.PRES
-		msm_target.APCP01[i] = w_SW*row_SW.APCP01 + w_SE*row_SE.APCP01 + w_NW*row_NW.APCP01 + w_NE*row_NE.APCP01
+		msm_target.date[i] = corrected[0].Rows[i].date
+		for j := range corrected {
+			w := weights[j]
+			row := &corrected[j].Rows[i]
+			msm_target.TMP[i] += w * row.TMP
+			msm_target.MR[i] += w * row.MR
+			msm_target.DSWRF_est[i] += w * row.DSWRF_est
+			msm_target.DSWRF_msm[i] += w * row.DSWRF_msm
+			msm_target.Ld[i] += w * row.Ld
+			msm_target.VGRD[i] += w * row.VGRD
+			msm_target.UGRD[i] += w * row.UGRD
+			msm_target.PRES[i] += w * row.PRES
+			msm_target.APCP01[i] += w * row.APCP01
+		}
+
+		// 双3次補間の重みは負の値を含むため、負とならない量は0以上とする
+		msm_target.MR[i] = math.Max(msm_target.MR[i], 0)
+		msm_target.DSWRF_est[i] = math.Max(msm_target.DSWRF_est[i], 0)
+		msm_target.DSWRF_msm[i] = math.Max(msm_target.DSWRF_msm[i], 0)
+		msm_target.APCP01[i] = math.Max(msm_target.APCP01[i], 0)
+	}
+
+	if spread {
+		msm_target.Spread = newMsmSpread(corrected, msms.Names(), weights)
 	}
 
 	return &msm_target
@@ -188,6 +469,9 @@ func (msm_target *MsmTarget) ConvertLdUnit() {
 	for i := 0; i < len(msm_target.date); i++ {
 		msm_target.Ld[i] = msm_target.Ld[i] * (3.6 / 1000)
 	}
+	if msm_target.Spread != nil {
+		msm_target.Spread.convertLdUnit()
+	}
 }
 
 var sigma float64
@@ -0,0 +1,289 @@
+package arcclimate
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//--------------------------------------
+// 国土地理院の標高タイル
+//--------------------------------------
+
+// 標高タイルの種類とズームレベル
+// ref: https://maps.gsi.go.jp/development/ichiran.html#dem
+var demTileZoom = map[string]int{
+	"dem5a":  15, // 基盤地図情報数値標高モデル DEM5A (航空レーザ測量)
+	"dem5b":  15, // 基盤地図情報数値標高モデル DEM5B (写真測量)
+	"dem5c":  15, // 基盤地図情報数値標高モデル DEM5C (写真測量)
+	"dem10b": 14, // 基盤地図情報数値標高モデル DEM10B
+}
+
+// 既定で使用する標高タイルの種類 (精度の高い順)
+var DefaultDEMTileTypes = []string{"dem5a", "dem5b", "dem10b"}
+
+// 標高タイル1辺の画素数
+const demTileSize = 256
+
+// 既定で保持する標高タイル数の上限 (1タイル256KB)
+const DefaultDEMTileCacheSize = 64
+
+// ローカルに保存した国土地理院の標高タイル (PNG形式またはテキスト形式)
+// タイルは Dir 以下に配信元と同じ構成で保存します。
+//
+//	{Dir}/{種類}_png/{z}/{x}/{y}.png (PNG形式、例: dem5a_png/15/29105/12903.png)
+//	{Dir}/{種類}/{z}/{x}/{y}.txt     (テキスト形式、例: dem10b/14/14552/6451.txt)
+//
+// 種類 Types の順に探し、最初に標高が得られたタイルの値を使用します。
+// 読み込んだタイルは最大 MaxTiles 枚まで保持し、上限を超えた場合は最後に使用した時期の古いタイルから破棄します。
+// ref: https://maps.gsi.go.jp/development/demtile.html
+type DEMTileElevationProvider struct {
+	Dir      string   // 標高タイルの格納ディレクトリ
+	Types    []string // 使用する標高タイルの種類 (nil の場合は DefaultDEMTileTypes)
+	MaxTiles int      // 保持するタイル数の上限 (0以下の場合は DefaultDEMTileCacheSize)
+
+	mu    sync.Mutex
+	tiles map[string]*demTileEntry // 読み込んだタイル
+	tick  uint64
+}
+
+// 標高タイル (demTileSize×demTileSize、標高データが無い画素は NaN)
+// 標高は 0.01m 単位のため float32 で保持し、取り出す際に 0.01m 単位に丸めます。
+type demTile [demTileSize * demTileSize]float32
+
+// 保持している標高タイル1枚
+type demTileEntry struct {
+	ready chan struct{} // 読み込み完了時に閉じる
+	tile  *demTile      // ファイルが無い場合は nil
+	err   error
+	used  uint64 // 最後に使用した順番
+}
+
+func NewDEMTileElevationProvider(dir string) *DEMTileElevationProvider {
+	return &DEMTileElevationProvider{Dir: dir}
+}
+
+func (p *DEMTileElevationProvider) Mode() ElevationMode { return ElevationTile }
+
+func (p *DEMTileElevationProvider) String() string {
+	return string(ElevationTile) + ":" + strings.Join(p.types(), ",") + ":" + p.Dir
+}
+
+func (p *DEMTileElevationProvider) types() []string {
+	if p.Types == nil {
+		return DefaultDEMTileTypes
+	}
+	return p.Types
+}
+
+func (p *DEMTileElevationProvider) Elevation(ctx context.Context, lat float64, lon float64) (float64, error) {
+	for _, typ := range p.types() {
+		z, ok := demTileZoom[typ]
+		if !ok {
+			return math.NaN(), fmt.Errorf("unknown dem tile type %q", typ)
+		}
+		x, y, px, py := demTilePixel(lat, lon, z)
+
+		tile, err := p.tile(ctx, typ, z, x, y)
+		if err != nil {
+			return math.NaN(), err
+		}
+		if tile == nil {
+			continue
+		}
+		if v := float64(tile[py*demTileSize+px]); !math.IsNaN(v) {
+			return math.Round(v*100) / 100, nil
+		}
+	}
+	return math.NaN(), fmt.Errorf("latitude %v, longitude %v: %w in dem tiles %v under %s", lat, lon, ErrNoElevation, p.types(), p.Dir)
+}
+
+// 緯度 lat, 経度 lon の地点を含むズームレベル z のタイル座標 x, y とタイル内の画素 px, py (ウェブメルカトル)
+func demTilePixel(lat float64, lon float64, z int) (int, int, int, int) {
+	n := math.Exp2(float64(z)) * demTileSize
+	lat_rad := lat * math.Pi / 180
+	gx := int(math.Floor((lon + 180) / 360 * n))
+	gy := int(math.Floor((1 - math.Log(math.Tan(lat_rad)+1/math.Cos(lat_rad))/math.Pi) / 2 * n))
+	return gx / demTileSize, gy / demTileSize, gx % demTileSize, gy % demTileSize
+}
+
+// 種類 typ のタイル (z, x, y) を読み込みます。ファイルが無い場合は nil を返します。
+// ファイルの読み込みはロックの外で行い、同じタイルの読み込みが実行中の場合は、その完了を待って結果を共有します。
+// 読み込みに失敗した場合は保持せず、次回に再度読み込みます。
+func (p *DEMTileElevationProvider) tile(ctx context.Context, typ string, z int, x int, y int) (*demTile, error) {
+	key := fmt.Sprintf("%s/%d/%d/%d", typ, z, x, y)
+
+	p.mu.Lock()
+	p.tick++
+	e, ok := p.tiles[key]
+	if ok {
+		e.used = p.tick
+		p.mu.Unlock()
+
+		select {
+		case <-e.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return e.tile, e.err
+	}
+
+	e = &demTileEntry{ready: make(chan struct{}), used: p.tick}
+	if p.tiles == nil {
+		p.tiles = make(map[string]*demTileEntry)
+	}
+	p.tiles[key] = e
+	p.mu.Unlock()
+
+	e.tile, e.err = p.readTile(typ, z, x, y)
+	p.mu.Lock()
+	if e.err != nil {
+		if p.tiles[key] == e {
+			delete(p.tiles, key)
+		}
+	} else {
+		p.evict()
+	}
+	p.mu.Unlock()
+	close(e.ready)
+
+	return e.tile, e.err
+}
+
+// 種類 typ のタイル (z, x, y) をPNG形式、テキスト形式の順に探して読み込みます。ファイルが無い場合は nil を返します。
+func (p *DEMTileElevationProvider) readTile(typ string, z int, x int, y int) (*demTile, error) {
+	tile, err := readDEMTilePNG(filepath.Join(p.Dir, typ+"_png", strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+".png"))
+	if errors.Is(err, fs.ErrNotExist) {
+		tile, err = readDEMTileText(filepath.Join(p.Dir, typ, strconv.Itoa(z), strconv.Itoa(x), strconv.Itoa(y)+".txt"))
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return tile, err
+}
+
+// 上限を超えたタイルを破棄します。p.mu を取得してから呼び出します。
+// 読み込み中のタイルは破棄しません。
+func (p *DEMTileElevationProvider) evict() {
+	limit := p.MaxTiles
+	if limit <= 0 {
+		limit = DefaultDEMTileCacheSize
+	}
+	for len(p.tiles) > limit {
+		oldest := ""
+		for key, e := range p.tiles {
+			select {
+			case <-e.ready:
+			default:
+				continue
+			}
+			if oldest == "" || e.used < p.tiles[oldest].used {
+				oldest = key
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		delete(p.tiles, oldest)
+	}
+}
+
+// PNG形式の標高タイル name を読み込みます。
+// 画素値 x = 2^16R + 2^8G + B が 2^23 未満の場合は 0.01x [m]、2^23 を超える場合は 0.01(x-2^24) [m]、
+// 2^23 (R,G,B = 128,0,0) の場合は標高データ無しとします。
+func readDEMTilePNG(name string) (*demTile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := png.Decode(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	b := img.Bounds()
+	if b.Dx() != demTileSize || b.Dy() != demTileSize {
+		return nil, fmt.Errorf("read %s: tile size %dx%d (want %dx%d)", name, b.Dx(), b.Dy(), demTileSize, demTileSize)
+	}
+
+	var tile demTile
+	for py := 0; py < demTileSize; py++ {
+		for px := 0; px < demTileSize; px++ {
+			tile[py*demTileSize+px] = float32(demPixelElevation(img, b.Min.X+px, b.Min.Y+py))
+		}
+	}
+	return &tile, nil
+}
+
+// PNG形式の標高タイルの画素 (x, y) の標高 [m]
+func demPixelElevation(img image.Image, x int, y int) float64 {
+	r, g, b, a := img.At(x, y).RGBA()
+	if a == 0 {
+		return math.NaN()
+	}
+	v := int(r>>8)<<16 | int(g>>8)<<8 | int(b>>8)
+	switch {
+	case v < 1<<23:
+		return float64(v) * 0.01
+	case v > 1<<23:
+		return float64(v-1<<24) * 0.01
+	}
+	return math.NaN()
+}
+
+// テキスト形式の標高タイル name を読み込みます。
+// 256行×256列のカンマ区切りの標高 [m] で、標高データが無い画素は "e" とします。
+func readDEMTileText(name string) (*demTile, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tile demTile
+	scanner := bufio.NewScanner(file)
+	py := 0
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if py >= demTileSize {
+			return nil, fmt.Errorf("read %s: more than %d lines", name, demTileSize)
+		}
+		values := strings.Split(line, ",")
+		if len(values) != demTileSize {
+			return nil, fmt.Errorf("read %s: line %d: %d values (want %d)", name, py+1, len(values), demTileSize)
+		}
+		for px, s := range values {
+			if s == "e" {
+				tile[py*demTileSize+px] = float32(math.NaN())
+				continue
+			}
+			v, err := strconv.ParseFloat(s, 32)
+			if err != nil {
+				return nil, fmt.Errorf("read %s: line %d: %w", name, py+1, err)
+			}
+			tile[py*demTileSize+px] = float32(v)
+		}
+		py++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", name, err)
+	}
+	if py != demTileSize {
+		return nil, fmt.Errorf("read %s: %d lines (want %d)", name, py, demTileSize)
+	}
+	return &tile, nil
+}
@@ -0,0 +1,137 @@
+package arcclimate
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 画素 (px, py) の値を value とし、それ以外を標高データ無しとしたPNG形式の標高タイルを作成します。
+func writeDEMTilePNG(t *testing.T, name string, px int, py int, value color.NRGBA) {
+	img := image.NewNRGBA(image.Rect(0, 0, demTileSize, demTileSize))
+	for y := 0; y < demTileSize; y++ {
+		for x := 0; x < demTileSize; x++ {
+			img.Set(x, y, color.NRGBA{128, 0, 0, 255})
+		}
+	}
+	img.Set(px, py, value)
+
+	assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+	file, err := os.Create(name)
+	assert.NoError(t, err)
+	defer file.Close()
+	assert.NoError(t, png.Encode(file, img))
+}
+
+func Test_demTilePixel(t *testing.T) {
+	// 赤道・本初子午線はズームレベル1の南東のタイルの北西端
+	x, y, px, py := demTilePixel(0, 0, 1)
+	assert.Equal(t, []int{1, 1, 0, 0}, []int{x, y, px, py})
+
+	// 東京タワー付近
+	x, y, px, py = demTilePixel(35.658581, 139.745433, 15)
+	assert.Equal(t, 29103, x)
+	assert.Equal(t, 12905, y)
+	assert.True(t, 0 <= px && px < demTileSize && 0 <= py && py < demTileSize)
+}
+
+func Test_DEMTileElevationProvider(t *testing.T) {
+	dir := t.TempDir()
+	lat, lon := 35.658581, 139.745433
+	ctx := context.Background()
+
+	// dem5a (PNG): 25.30m
+	x, y, px, py := demTilePixel(lat, lon, 15)
+	name := filepath.Join(dir, "dem5a_png", "15", strconv.Itoa(x), strconv.Itoa(y)+".png")
+	writeDEMTilePNG(t, name, px, py, color.NRGBA{0, 0x09, 0xe2, 255}) // 2530 * 0.01
+
+	p := NewDEMTileElevationProvider(dir)
+	ele, err := p.Elevation(ctx, lat, lon)
+	assert.NoError(t, err)
+	assert.InDelta(t, 25.30, ele, 1e-9)
+	assert.Equal(t, ElevationTile, p.Mode())
+
+	// dem5a に標高が無い画素は dem10b (テキスト) を使用する
+	lat2 := lat + 0.0002
+	x, y, px, py = demTilePixel(lat2, lon, 14)
+	lines := make([]string, demTileSize)
+	for i := range lines {
+		values := strings.Split(strings.Repeat("e,", demTileSize-1)+"e", ",")
+		if i == py {
+			values[px] = "-1.5"
+		}
+		lines[i] = strings.Join(values, ",")
+	}
+	name = filepath.Join(dir, "dem10b", "14", strconv.Itoa(x), strconv.Itoa(y)+".txt")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+	assert.NoError(t, os.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"), 0644))
+
+	ele, err = p.Elevation(ctx, lat2, lon)
+	assert.NoError(t, err)
+	assert.Equal(t, -1.5, ele)
+
+	// タイルが無い地点
+	_, err = p.Elevation(ctx, 43.0, 141.3)
+	assert.True(t, errors.Is(err, ErrNoElevation))
+}
+
+func Test_demPixelElevation(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	img.Set(0, 0, color.NRGBA{0, 0x27, 0x10, 255})    // 10000 * 0.01
+	img.Set(1, 0, color.NRGBA{0xff, 0xff, 0x9c, 255}) // (2^24 - 100) -> -1.00
+	img.Set(2, 0, color.NRGBA{128, 0, 0, 255})        // 標高データ無し
+	assert.InDelta(t, 100.0, demPixelElevation(img, 0, 0), 1e-9)
+	assert.InDelta(t, -1.0, demPixelElevation(img, 1, 0), 1e-9)
+	assert.True(t, math.IsNaN(demPixelElevation(img, 2, 0)))
+}
+
+// 保持するタイル数の上限を超えた場合は最後に使用した時期の古いタイルから破棄する
+func Test_DEMTileElevationProvider_MaxTiles(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+	lat, lon := 35.658581, 139.745433
+	step := 360.0 / math.Exp2(15) // ズームレベル15のタイル1枚の経度幅
+
+	p := NewDEMTileElevationProvider(dir)
+	p.MaxTiles = 2
+	keys := make([]string, 3)
+	for i := range keys {
+		x, y, px, py := demTilePixel(lat, lon+float64(i)*step, 15)
+		writeDEMTilePNG(t, filepath.Join(dir, "dem5a_png", "15", strconv.Itoa(x), strconv.Itoa(y)+".png"), px, py, color.NRGBA{0, 0, byte(i + 1), 255})
+		keys[i] = "dem5a/15/" + strconv.Itoa(x) + "/" + strconv.Itoa(y)
+	}
+
+	for _, i := range []int{0, 1, 0, 2} {
+		ele, err := p.Elevation(ctx, lat, lon+float64(i)*step)
+		assert.NoError(t, err)
+		assert.Equal(t, float64(i+1)*0.01, ele)
+	}
+	assert.Len(t, p.tiles, 2)
+	assert.Contains(t, p.tiles, keys[0])
+	assert.Contains(t, p.tiles, keys[2])
+
+	// 複数の計算から同時に使用できる
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ele, err := p.Elevation(ctx, lat, lon+float64(i%3)*step)
+			assert.NoError(t, err)
+			assert.Equal(t, float64(i%3+1)*0.01, ele)
+		}(i)
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, len(p.tiles), 2)
+}
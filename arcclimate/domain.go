@@ -0,0 +1,84 @@
+package arcclimate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+)
+
+//--------------------------------------
+// 推計対象地点の範囲の確認と警告
+//--------------------------------------
+
+// 推計対象地点が計算できる範囲外であることを表すエラー
+var ErrOutOfDomain = errors.New("outside the supported area")
+
+// MSMの格子点数 (data/src/MSM_elevation.csv の行数・列数)
+const (
+	msmRows = 505 // 北緯47.6度から22.4度まで 0.05度間隔
+	msmCols = 481 // 東経120度から150度まで 0.0625度間隔
+)
+
+// 緯度 lat, 経度 lon の地点が計算できる範囲にあるか確認します。
+// 周囲4地点のMSMが格子の範囲内にあり、地点を含む1次メッシュの標高データが組み込まれている必要があります。
+// 範囲外の場合は ErrOutOfDomain をラップしたエラーを返します。
+func CheckDomain(lat float64, lon float64) error {
+	return checkDomain(lat, lon, Neighborhood4)
+}
+
+// CheckDomain と同様に、周囲 n 地点のMSMを使用する場合に計算できる範囲にあるか確認します。
+func checkDomain(lat float64, lon float64, n int) error {
+	if err := validateLatLon(lat, lon); err != nil {
+		return err
+	}
+
+	for _, p := range msmNeighborhood(lat, lon, n) {
+		if p[0] < 0 || p[0] >= msmRows || p[1] < 0 || p[1] >= msmCols {
+			return fmt.Errorf("latitude %v, longitude %v: %w of the MSM grid (22.4-47.6N, 120-150E)", lat, lon, ErrOutOfDomain)
+		}
+	}
+
+	mesh1d, _ := MeshCodeFromLatLon(lat, lon)
+	if !hasMesh3dElevation(mesh1d) {
+		return fmt.Errorf("latitude %v, longitude %v: %w (no elevation data for 1st mesh code %d)", lat, lon, ErrOutOfDomain, mesh1d)
+	}
+
+	return nil
+}
+
+// 推計対象地点の標高 ele_target [m] と周囲のMSMの標高 elevations (地点名 names と同じ順) から警告を作成します。
+// 標高 ele_target と重み weights で平均したMSMの標高との差が threshold [m] を超える場合、
+// および重みが0でないMSMに海上の地点(標高0m)が含まれる場合に警告します。threshold が0以下の場合は標高差を確認しません。
+func elevationWarnings(ele_target float64, elevations []float64, weights []float64, names []string, threshold float64) []string {
+	var warnings []string
+
+	if threshold > 0 {
+		ele_msm := 0.0
+		for i := range elevations {
+			ele_msm += weights[i] * elevations[i]
+		}
+		if math.Abs(ele_target-ele_msm) > threshold {
+			warnings = append(warnings, fmt.Sprintf(
+				"target elevation %.1fm differs from the weighted MSM elevation %.1fm by more than %gm",
+				ele_target, ele_msm, threshold))
+		}
+	}
+
+	var sea []string
+	for i, name := range names {
+		if elevations[i] == 0 && weights[i] != 0 {
+			sea = append(sea, name)
+		}
+	}
+	if len(sea) > 0 {
+		warnings = append(warnings, fmt.Sprintf("MSM grid points %s are sea points (elevation 0m)", strings.Join(sea, ", ")))
+	}
+
+	for _, w := range warnings {
+		log.Printf("警告: %s", w)
+	}
+
+	return warnings
+}
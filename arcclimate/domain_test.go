@@ -0,0 +1,58 @@
+package arcclimate
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_CheckDomain(t *testing.T) {
+	assert.NoError(t, CheckDomain(35.658, 139.741))
+
+	// MSMの格子の範囲外
+	for _, p := range [][2]float64{{22.3, 125}, {47.6, 140}, {35, 119.9}, {35, 150}} {
+		assert.ErrorIs(t, CheckDomain(p[0], p[1]), ErrOutOfDomain, p)
+	}
+
+	// 標高データの無い1次メッシュ(海上)
+	assert.ErrorIs(t, CheckDomain(30, 139), ErrOutOfDomain)
+
+	// 緯度経度が不正
+	assert.Error(t, CheckDomain(math.NaN(), 139))
+
+	opts := NewOptions(30, 139)
+	assert.ErrorIs(t, opts.Validate(), ErrOutOfDomain)
+}
+
+func Test_Elevation2d_OutOfGrid(t *testing.T) {
+	ele, err := NewElevationMaster(35.658, 139.741)
+	assert.NoError(t, err)
+	assert.False(t, math.IsNaN(ele.Elevation2d(0, 0)))
+	assert.True(t, math.IsNaN(ele.Elevation2d(-1, 0)))
+	assert.True(t, math.IsNaN(ele.Elevation2d(msmRows, 0)))
+	assert.True(t, math.IsNaN(ele.Elevation2d(0, msmCols)))
+}
+
+func Test_elevationWarnings(t *testing.T) {
+	weights := []float64{0.25, 0.25, 0.25, 0.25}
+	names := []string{"SW", "SE", "NW", "NE"}
+
+	assert.Empty(t, elevationWarnings(110, []float64{100, 100, 120, 120}, weights, names, 50))
+
+	// 標高差
+	w := elevationWarnings(500, []float64{100, 100, 120, 120}, weights, names, 50)
+	assert.Len(t, w, 1)
+	assert.Contains(t, w[0], "weighted MSM elevation 110.0m")
+
+	// 0以下の場合は標高差を確認しない
+	assert.Empty(t, elevationWarnings(500, []float64{100, 100, 120, 120}, weights, names, 0))
+
+	// 海上のMSM地点
+	w = elevationWarnings(5, []float64{0, 10, 0, 20}, weights, names, 50)
+	assert.Equal(t, []string{"MSM grid points SW, NW are sea points (elevation 0m)"}, w)
+
+	// 重みが0の海上の地点は警告しない
+	w = elevationWarnings(5, []float64{0, 10, 0, 20}, []float64{0, 0.5, 0.25, 0.25}, names, 50)
+	assert.Equal(t, []string{"MSM grid points NW are sea points (elevation 0m)"}, w)
+}
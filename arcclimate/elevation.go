@@ -1,16 +1,14 @@
 package arcclimate
 
 import (
-	"bytes"
-	"embed"
-	"encoding/csv"
-	"encoding/json"
+	"context"
+	_ "embed"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"math"
-	"net/http"
-	"strconv"
+	"sync"
+
+	"github.com/udawtr/arcclimate-go/arcclimate/internal/elevdata"
 )
 
 type ElevationMaster struct {
@@ -29,42 +27,43 @@ type ElevationMaster struct {
 // 緯度 lat, 経度 lonの地点の標高[m]の取得します。
 // 取得の方法 mode_elevation は、 "mesh" または "api" を指定します。
 // "mesh"の場合は、標高補正に3次メッシュ（1㎞メッシュ）の平均標高データ mesh_elevation_master を使用します。
-// "api"の場合は、国土地理院のAPIを使用します。そのため、平均標高データ mesh_elevation_masterは不要です。
+// "api"の場合は、国土地理院のAPIを使用します。APIから取得できなかった場合は "mesh" と同様に平均標高データを使用します。
 func ElevationFromLatLon(
+	ctx context.Context,
 	lat float64,
 	lon float64,
-	mode_elevation string,
-	mesh_elevation_master *ElevationMaster) float64 {
+	mode_elevation ElevationMode,
+	mesh_elevation_master *ElevationMaster) (float64, error) {
 
-	var elevation float64
+	elevation, _, err := elevationFromLatLon(ctx, lat, lon, mode_elevation, mesh_elevation_master)
+	return elevation, err
+}
 
-	if mode_elevation == "mesh" {
-		// 標高補正に3次メッシュ（1㎞メッシュ）の平均標高データを使用する場合
-		elevation = mesh_elevation_master.Elevation3d(lat, lon)
+// ElevationFromLatLon と同様に標高[m]を取得し、実際に使用した取得の方法もあわせて返します。
+func elevationFromLatLon(
+	ctx context.Context,
+	lat float64,
+	lon float64,
+	mode_elevation ElevationMode,
+	mesh_elevation_master *ElevationMaster) (float64, ElevationMode, error) {
 
+	switch mode_elevation {
+	case ElevationMesh:
+		// 標高補正に3次メッシュ（1㎞メッシュ）の平均標高データを使用する場合
+		elevation := mesh_elevation_master.Elevation3d(lat, lon)
 		log.Printf("入力された緯度・経度が含まれる3次メッシュの平均標高 %fm で計算します", elevation)
+		return elevation, ElevationMesh, nil
 
-	} else if mode_elevation == "api" {
-		// 国土地理院のAPIを使用して入力した緯度f経度位置の標高を返す
+	case ElevationAPI:
+		// 国土地理院のAPIを使用して入力した緯度経度位置の標高を返す
+		// 取得できなかった場合は3次メッシュ（1㎞メッシュ）の平均標高データにフォールバック
+		// ref: https://maps.gsi.go.jp/development/elevation_s.html
+		// ref: https://github.com/gsi-cyberjapan/elevation-php/blob/master/getelevation.php
 		log.Printf("入力された緯度・経度位置の標高データを国土地理院のAPIから取得します")
-		var err error
-		elevation, err = elevationFromCyberjapandata2(lat, lon)
-		if err == nil {
-			log.Printf("成功  標高 %fm で計算します", elevation)
-		} else {
-			// 国土地理院のAPIから標高データを取得できなかった場合の判断
-			// 標高補正に3次メッシュ（1㎞メッシュ）の平均標高データにフォールバック
-			// ref: https://maps.gsi.go.jp/development/elevation_s.html
-			// ref: https://github.com/gsi-cyberjapan/elevation-php/blob/master/getelevation.php
-			elevation = mesh_elevation_master.Elevation3d(lat, lon)
-			log.Printf("国土地理院のAPIから標高データを取得できなかったため、\n"+
-				"入力された緯度・経度が含まれる3次メッシュの平均標高 %fm で計算します", elevation)
-		}
-	} else {
-		panic(mode_elevation)
+		return elevationFromProvider(ctx, defaultElevationAPI, lat, lon, mesh_elevation_master)
 	}
 
-	return elevation
+	return math.NaN(), mode_elevation, fmt.Errorf("unknown elevation mode %q", mode_elevation)
 }
 
 // 3次メッシュ（1㎞メッシュ）の平均標高データ mesh_elevation_master を用いて、緯度 lat, 経度 lonの地点の標高[m]の取得します。
@@ -74,43 +73,52 @@ func (mesh_elevation_master *ElevationMaster) Elevation3d(lat float64, lon float
 	return elevation
 }
 
-func (msm_elevation_master *ElevationMaster) Elevation2d(codeSN int, codeWE int) float64 {
-	return msm_elevation_master.DfMsmEle[codeSN][codeWE]
+// 緯度 lat, 経度 lonの地点を含む3次メッシュの平均標高データがあるか (無い場合 Elevation3d は 0 を返します)
+func (mesh_elevation_master *ElevationMaster) hasElevation3d(lat float64, lon float64) bool {
+	meshcode1d, meshcode23d := MeshCodeFromLatLon(lat, lon)
+	_, ok := mesh_elevation_master.DfMeshEle[meshcode1d][meshcode23d]
+	return ok
 }
 
-// 国土地理院のAPI を用いて、緯度 lat, 経度 lonの地点の標高[m]の取得します。
-func elevationFromCyberjapandata2(lat float64, lon float64) (float64, error) {
-	cyberjapandata2_endpoint := "http://cyberjapandata2.gsi.go.jp/general/dem/scripts/getelevation.php"
-	url := fmt.Sprintf("%s?lon=%f&lat=%f&outtype=%s", cyberjapandata2_endpoint, lon, lat, "JSON")
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return math.NaN(), err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body) // response body is []byte
-	if err != nil {
-		panic(err)
-	}
-
-	var eleApiRes ElevationApiResnponse
-	if err := json.Unmarshal(body, &eleApiRes); err != nil {
-		return math.NaN(), err
+// MSMの格子点 (北始まりの番号 codeSN, 西始まりの番号 codeWE) の標高[m]を返します。格子の範囲外の場合は NaN を返します。
+func (msm_elevation_master *ElevationMaster) Elevation2d(codeSN int, codeWE int) float64 {
+	if codeSN < 0 || codeSN >= len(msm_elevation_master.DfMsmEle) ||
+		codeWE < 0 || codeWE >= len(msm_elevation_master.DfMsmEle[codeSN]) {
+		return math.NaN()
 	}
-
-	return eleApiRes.Elevation.(float64), nil
+	return msm_elevation_master.DfMsmEle[codeSN][codeWE]
 }
 
+// 国土地理院の標高APIの応答 (標高データが無い地点の場合 Elevation は "-----")
 type ElevationApiResnponse struct {
 	Elevation interface{} `json:"elevation"`
 	HSrc      interface{} `json:"hsrc"`
 }
 
-//go:embed data/*.csv
-var f embed.FS
+// 組み込み標高データ (MSM格子点の標高と3次メッシュの平均標高)。gen_elevation.go で data/src のCSVから作成します。
+//
+//go:generate go run gen_elevation.go
+//go:embed data/elevation.bin
+var elevationData []byte
+
+// 組み込み標高データは最初に使用したときに一度だけ展開し、すべての地点で共有します。
+var (
+	elevationOnce  sync.Once
+	elevationIndex *elevdata.Index
+	elevationErr   error
+)
+
+// 展開した組み込み標高データを返します。
+func loadElevationIndex() (*elevdata.Index, error) {
+	elevationOnce.Do(func() {
+		elevationIndex, elevationErr = elevdata.Decode(elevationData)
+	})
+	return elevationIndex, elevationErr
+}
 
 // 経度 lon, 緯度 lat の補完に必要なマスタ読み取り
-func NewElevationMaster(lat float64, lon float64) *ElevationMaster {
+// 標高データは共有するため、DfMsmEle, DfMeshEle の値は変更しないでください。
+func NewElevationMaster(lat float64, lon float64) (*ElevationMaster, error) {
 	ele := &ElevationMaster{
 		DfMsmEle:  make([][]float64, 0),
 		DfMeshEle: make(map[int]map[int]float64),
@@ -119,78 +127,49 @@ func NewElevationMaster(lat float64, lon float64) *ElevationMaster {
 	mesh1d, _ := MeshCodeFromLatLon(lat, lon)
 
 	// MSM地点の標高データの読込
-	ele.ReadMsmElevation()
+	if err := ele.ReadMsmElevation(); err != nil {
+		return nil, err
+	}
 
 	// 3次メッシュの標高データの読込
-	ele.Read3dMeshElevation(mesh1d)
+	if err := ele.Read3dMeshElevation(mesh1d); err != nil {
+		return nil, err
+	}
 
-	return ele
+	return ele, nil
 }
 
 // 2次メッシュコードまでの標高データを読み取り
-func (ele *ElevationMaster) ReadMsmElevation() {
-	// Open the CSV file
-	content, err := f.ReadFile("data/MSM_elevation.csv")
-	if err != nil {
-		panic(err)
-	}
-
-	// Create a new CSV reader
-	reader := csv.NewReader(bytes.NewBuffer(content))
-
-	// Read all records at once
-	records, err := reader.ReadAll()
+func (ele *ElevationMaster) ReadMsmElevation() error {
+	index, err := loadElevationIndex()
 	if err != nil {
-		panic(err)
-	}
-
-	// Print the records
-	elemap := make([][]float64, len(records))
-	for i, record := range records {
-		elemap[i] = make([]float64, len(record))
-		for j := 0; j < len(record); j++ {
-			elemap[i][j], err = strconv.ParseFloat(record[j], 64)
-			if err != nil {
-				panic(err)
-			}
-		}
+		return err
 	}
+	ele.DfMsmEle = index.MSM
+	return nil
+}
 
-	ele.DfMsmEle = elemap
+// 1次メッシュコード meshcode_1d の3次メッシュの標高データが組み込まれているか
+func hasMesh3dElevation(meshcode_1d int) bool {
+	index, err := loadElevationIndex()
+	return err == nil && index.HasMesh(meshcode_1d)
 }
 
-func (ele *ElevationMaster) Read3dMeshElevation(meshcode_1d int) {
-	// Open the CSV file
-	content, err := f.ReadFile(fmt.Sprintf("data/mesh_3d_ele_%d.csv", meshcode_1d))
+// 1次メッシュコード meshcode_1d の3次メッシュの標高データを読み取り
+func (ele *ElevationMaster) Read3dMeshElevation(meshcode_1d int) error {
+	index, err := loadElevationIndex()
 	if err != nil {
-		panic(err)
+		return err
 	}
-
-	// Create a new CSV reader
-	reader := csv.NewReader(bytes.NewBuffer(content))
-
-	// Skip a header
-	_, _ = reader.Read()
-
-	// Read all records at once
-	records, err := reader.ReadAll()
+	elemap, err := index.Mesh(meshcode_1d)
 	if err != nil {
-		panic(err)
+		return err
 	}
-
-	// Print the records
-	elemap := make(map[int]float64, len(records))
-	for _, record := range records {
-		meshcode, err := strconv.Atoi(record[0])
-		if err != nil {
-			panic(err)
-		}
-		elevation, err := strconv.ParseFloat(record[1], 64)
-		if err != nil {
-			panic(err)
-		}
-		elemap[meshcode] = elevation
+	if elemap == nil {
+		return fmt.Errorf("no elevation data for 1st mesh code %d", meshcode_1d)
 	}
 
 	ele.DfMeshEle[meshcode_1d] = elemap
+
+	return nil
 }
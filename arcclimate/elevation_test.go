@@ -0,0 +1,134 @@
+package arcclimate
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/udawtr/arcclimate-go/arcclimate/internal/elevdata"
+)
+
+// 元のCSVのMSM格子点の標高データを読み込みます。
+func readMSMElevationCSV(t testing.TB) [][]string {
+	file, err := os.Open(filepath.Join("data", "src", "MSM_elevation.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	msm, err := elevdata.ReadMSMCSV(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msm
+}
+
+// 元のCSVの1次メッシュコード mesh1d の3次メッシュの平均標高を読み込みます。
+func readMeshElevationCSV(t testing.TB, mesh1d int) map[int]string {
+	mesh := make(map[int]map[int]string)
+	file, err := os.Open(filepath.Join("data", "src", "mesh_3d_ele_"+strconv.Itoa(mesh1d)+".csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := elevdata.ReadMeshCSV(file, mesh1d, mesh); err != nil {
+		t.Fatal(err)
+	}
+	return mesh[mesh1d]
+}
+
+// 元のCSVの標高データを読み込みます。
+func readElevationCSV(t testing.TB, mesh1d int) ([][]string, map[int]map[int]string) {
+	return readMSMElevationCSV(t), map[int]map[int]string{mesh1d: readMeshElevationCSV(t, mesh1d)}
+}
+
+// 組み込み標高データがすべての元のCSVと一致するか (data/elevation.bin の更新漏れの確認)
+func Test_ElevationData_MatchesCSV(t *testing.T) {
+	ele, err := NewElevationMaster(35.658, 139.741)
+	assert.NoError(t, err)
+
+	msm := readMSMElevationCSV(t)
+	assert.Len(t, ele.DfMsmEle, len(msm))
+	for i := range msm {
+		assert.Len(t, ele.DfMsmEle[i], len(msm[i]))
+		for j, s := range msm[i] {
+			v, _ := strconv.ParseFloat(s, 64)
+			if math.Float64bits(v) != math.Float64bits(ele.DfMsmEle[i][j]) {
+				t.Fatalf("msm elevation (%d, %d): got %v, want %s", i, j, ele.DfMsmEle[i][j], s)
+			}
+		}
+	}
+
+	names, err := filepath.Glob(filepath.Join("data", "src", "mesh_3d_ele_*.csv"))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, names)
+	for _, name := range names {
+		mesh1d, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), "mesh_3d_ele_"), ".csv"))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := ele.Read3dMeshElevation(mesh1d); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		mesh := readMeshElevationCSV(t, mesh1d)
+		assert.Len(t, ele.DfMeshEle[mesh1d], len(mesh), name)
+		for code, s := range mesh {
+			v, _ := strconv.ParseFloat(s, 64)
+			if math.Float64bits(v) != math.Float64bits(ele.DfMeshEle[mesh1d][code]) {
+				t.Fatalf("mesh %d%04d: got %v, want %s", mesh1d, code, ele.DfMeshEle[mesh1d][code], s)
+			}
+		}
+	}
+
+	// 組み込まれていない1次メッシュ
+	assert.Error(t, ele.Read3dMeshElevation(9999))
+	assert.False(t, hasMesh3dElevation(9999))
+}
+
+// 組み込み標高データの展開 (起動時に一度だけ)
+func Benchmark_ElevationData_Decode(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		index, err := elevdata.Decode(elevationData)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if _, err := index.Mesh(5339); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 地点ごとの標高データの読み込み (展開済み)
+func Benchmark_NewElevationMaster(b *testing.B) {
+	if _, err := NewElevationMaster(35.658, 139.741); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewElevationMaster(35.658, 139.741); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 比較: 地点ごとにCSVを読み込む場合
+func Benchmark_NewElevationMaster_CSV(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		msm, mesh := readElevationCSV(b, 5339)
+		grid := make([][]float64, len(msm))
+		for i, row := range msm {
+			grid[i] = make([]float64, len(row))
+			for j, s := range row {
+				grid[i][j], _ = strconv.ParseFloat(s, 64)
+			}
+		}
+		elemap := make(map[int]float64, len(mesh[5339]))
+		for code, s := range mesh[5339] {
+			elemap[code], _ = strconv.ParseFloat(s, 64)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package arcclimate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//--------------------------------------
+// 標高の取得元
+//--------------------------------------
+
+// 地点の標高データが無いことを表すエラー (海上や標高データの範囲外)
+var ErrNoElevation = errors.New("no elevation data")
+
+// 推計対象地点の標高の取得元
+type ElevationProvider interface {
+	// 緯度 lat, 経度 lon の地点の標高 [m] を返します。
+	// 標高データが無い地点の場合は ErrNoElevation をラップしたエラーを返します。
+	Elevation(ctx context.Context, lat float64, lon float64) (float64, error)
+
+	// 計算条件の記録に使用する標高の判定方法
+	Mode() ElevationMode
+
+	// 取得元を表す文字列 (計算結果のキャッシュのキーに使用)
+	String() string
+}
+
+// 3次メッシュ（1㎞メッシュ）の平均標高 (組み込みデータ)
+// 3次メッシュの平均標高データが無い地点(海上など)は 0m とします。
+type MeshElevationProvider struct {
+	Master *ElevationMaster // 推計対象地点を含む1次メッシュを読み込んだ標高データ
+}
+
+func (p *MeshElevationProvider) Elevation(ctx context.Context, lat float64, lon float64) (float64, error) {
+	return p.Master.Elevation3d(lat, lon), nil
+}
+
+func (p *MeshElevationProvider) Mode() ElevationMode { return ElevationMesh }
+
+func (p *MeshElevationProvider) String() string { return string(ElevationMesh) }
+
+// 指定した標高 [m] (地点によらず一定)
+type FixedElevation float64
+
+func (p FixedElevation) Elevation(ctx context.Context, lat float64, lon float64) (float64, error) {
+	return float64(p), nil
+}
+
+func (p FixedElevation) Mode() ElevationMode { return ElevationFixed }
+
+func (p FixedElevation) String() string {
+	return string(ElevationFixed) + ":" + strconv.FormatFloat(float64(p), 'g', -1, 64)
+}
+
+// 国土地理院の標高APIのURL
+// ref: https://maps.gsi.go.jp/development/elevation_s.html
+const DefaultElevationAPIURL = "https://cyberjapandata2.gsi.go.jp/general/dem/scripts/getelevation.php"
+
+// 国土地理院の標高API
+// 同じ地点の標高は取得済みの値を再利用します。
+type APIElevationProvider struct {
+	URL     string        // APIのURL (空の場合は DefaultElevationAPIURL)
+	Client  *http.Client  // nil の場合は http.DefaultClient を使用
+	Timeout time.Duration // 1回の問い合わせの制限時間(0の場合は無制限)
+
+	mu    sync.Mutex
+	cache map[string]float64 // 緯度・経度(問い合わせの精度) -> 標高 (標高データが無い地点は NaN)
+}
+
+// 既定の国土地理院の標高API (問い合わせの制限時間 10秒)
+func NewAPIElevationProvider() *APIElevationProvider {
+	return &APIElevationProvider{URL: DefaultElevationAPIURL, Timeout: 10 * time.Second}
+}
+
+// "api" で共有する国土地理院の標高API
+var defaultElevationAPI = NewAPIElevationProvider()
+
+func (p *APIElevationProvider) Mode() ElevationMode { return ElevationAPI }
+
+func (p *APIElevationProvider) String() string { return string(ElevationAPI) + ":" + p.endpoint() }
+
+func (p *APIElevationProvider) endpoint() string {
+	if p.URL == "" {
+		return DefaultElevationAPIURL
+	}
+	return p.URL
+}
+
+func (p *APIElevationProvider) Elevation(ctx context.Context, lat float64, lon float64) (float64, error) {
+	key := fmt.Sprintf("%f,%f", lat, lon)
+	p.mu.Lock()
+	elevation, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok {
+		if math.IsNaN(elevation) {
+			return elevation, p.noElevation(lat, lon)
+		}
+		return elevation, nil
+	}
+
+	countStat(&stats.ElevationAPIRequests, 1)
+	elevation, err := p.fetch(ctx, lat, lon)
+	if err != nil && !errors.Is(err, ErrNoElevation) {
+		// 通信やAPIの応答の異常のみ失敗とし、再度問い合わせる
+		countStat(&stats.ElevationAPIFailures, 1)
+		return math.NaN(), err
+	}
+
+	// 標高データが無い地点は NaN として記録し、再度問い合わせない
+	p.mu.Lock()
+	if p.cache == nil {
+		p.cache = make(map[string]float64)
+	}
+	p.cache[key] = elevation
+	p.mu.Unlock()
+
+	return elevation, err
+}
+
+// 緯度 lat, 経度 lon の地点の標高データが無いことを表すエラー
+func (p *APIElevationProvider) noElevation(lat float64, lon float64) error {
+	return fmt.Errorf("latitude %v, longitude %v: %w from %s", lat, lon, ErrNoElevation, p.endpoint())
+}
+
+// 国土地理院の標高APIに緯度 lat, 経度 lonの地点の標高[m]を問い合わせます。
+func (p *APIElevationProvider) fetch(ctx context.Context, lat float64, lon float64) (float64, error) {
+	q := url.Values{}
+	q.Set("lon", fmt.Sprintf("%f", lon))
+	q.Set("lat", fmt.Sprintf("%f", lat))
+	q.Set("outtype", "JSON")
+	u := p.endpoint() + "?" + q.Encode()
+
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return math.NaN(), fmt.Errorf("request %s: %w", u, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return math.NaN(), fmt.Errorf("request %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return math.NaN(), fmt.Errorf("request %s: %s", u, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return math.NaN(), fmt.Errorf("read response from %s: %w", u, err)
+	}
+
+	var eleApiRes ElevationApiResnponse
+	if err := json.Unmarshal(body, &eleApiRes); err != nil {
+		return math.NaN(), fmt.Errorf("decode response from %s: %w", u, err)
+	}
+
+	switch v := eleApiRes.Elevation.(type) {
+	case float64:
+		return v, nil
+	case string:
+		// 標高データが無い地点(海上など)は "-----" を返す
+		if v == "-----" {
+			return math.NaN(), p.noElevation(lat, lon)
+		}
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, nil
+		}
+	}
+	return math.NaN(), fmt.Errorf("unexpected elevation in response from %s: %v", u, eleApiRes.Elevation)
+}
+
+// 標高の取得元 p から緯度 lat, 経度 lon の地点の標高[m]を取得し、実際に使用した標高の判定方法もあわせて返します。
+// 取得できなかった場合は3次メッシュ（1㎞メッシュ）の平均標高データ mesh_elevation_master を使用します。
+func elevationFromProvider(
+	ctx context.Context,
+	p ElevationProvider,
+	lat float64,
+	lon float64,
+	mesh_elevation_master *ElevationMaster) (float64, ElevationMode, error) {
+
+	elevation, err := p.Elevation(ctx, lat, lon)
+	if err == nil {
+		log.Printf("標高 %fm (%s) で計算します", elevation, p.Mode())
+		return elevation, p.Mode(), nil
+	}
+	if ctx.Err() != nil {
+		// 計算自体が中断された場合はフォールバックしない
+		return math.NaN(), p.Mode(), ctx.Err()
+	}
+
+	// 標高を取得できなかった場合は3次メッシュ（1㎞メッシュ）の平均標高データにフォールバック
+	elevation = mesh_elevation_master.Elevation3d(lat, lon)
+	log.Printf("標高データを取得できなかったため(%v)、\n"+
+		"入力された緯度・経度が含まれる3次メッシュの平均標高 %fm で計算します", err, elevation)
+	return elevation, ElevationMesh, nil
+}
@@ -0,0 +1,97 @@
+package arcclimate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_APIElevationProvider(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Query().Get("lat") == "35.000000" {
+			// 海上など標高データが無い地点
+			fmt.Fprint(w, `{"elevation":"-----","hsrc":"-----"}`)
+			return
+		}
+		fmt.Fprint(w, `{"elevation":25.3,"hsrc":"5m（レーザ）"}`)
+	}))
+	defer srv.Close()
+
+	p := &APIElevationProvider{URL: srv.URL}
+	ctx := context.Background()
+
+	ele, err := p.Elevation(ctx, 35.658, 139.741)
+	assert.NoError(t, err)
+	assert.Equal(t, 25.3, ele)
+
+	// 同じ地点は再利用する
+	ele, err = p.Elevation(ctx, 35.658, 139.741)
+	assert.NoError(t, err)
+	assert.Equal(t, 25.3, ele)
+	assert.Equal(t, 1, requests)
+
+	// 標高データが無い地点も再利用し、失敗には数えない
+	before := ReadStats()
+	for i := 0; i < 2; i++ {
+		ele, err = p.Elevation(ctx, 35.0, 139.741)
+		assert.True(t, errors.Is(err, ErrNoElevation))
+		assert.True(t, math.IsNaN(ele))
+	}
+	assert.Equal(t, 2, requests)
+	after := ReadStats()
+	assert.Equal(t, uint64(1), after.ElevationAPIRequests-before.ElevationAPIRequests)
+	assert.Equal(t, uint64(0), after.ElevationAPIFailures-before.ElevationAPIFailures)
+	assert.Equal(t, ElevationAPI, p.Mode())
+}
+
+// 通信やAPIの応答の異常は失敗に数え、次回は再度問い合わせる
+func Test_APIElevationProvider_Failure(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"elevation":"abc","hsrc":"-----"}`)
+	}))
+	defer srv.Close()
+
+	p := &APIElevationProvider{URL: srv.URL}
+	before := ReadStats()
+	for i := 0; i < 2; i++ {
+		ele, err := p.Elevation(context.Background(), 35.658, 139.741)
+		assert.Error(t, err)
+		assert.False(t, errors.Is(err, ErrNoElevation))
+		assert.True(t, math.IsNaN(ele))
+	}
+	assert.Equal(t, 2, requests)
+	after := ReadStats()
+	assert.Equal(t, uint64(2), after.ElevationAPIRequests-before.ElevationAPIRequests)
+	assert.Equal(t, uint64(2), after.ElevationAPIFailures-before.ElevationAPIFailures)
+}
+
+func Test_elevationFromProvider(t *testing.T) {
+	ele, err := NewElevationMaster(35.658, 139.741)
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	v, mode, err := elevationFromProvider(ctx, FixedElevation(12.5), 35.658, 139.741, ele)
+	assert.NoError(t, err)
+	assert.Equal(t, 12.5, v)
+	assert.Equal(t, ElevationFixed, mode)
+
+	// 取得できない場合は3次メッシュの平均標高
+	v, mode, err = elevationFromProvider(ctx, NewDEMTileElevationProvider(t.TempDir()), 35.658, 139.741, ele)
+	assert.NoError(t, err)
+	assert.Equal(t, ele.Elevation3d(35.658, 139.741), v)
+	assert.Equal(t, ElevationMesh, mode)
+}
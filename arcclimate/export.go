@@ -2,6 +2,7 @@ package arcclimate
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"math"
 	"strconv"
@@ -9,6 +10,8 @@ import (
 
 // CSV形式
 func (df_save *MsmTarget) ToCSV(buf *bytes.Buffer) {
+	W_spd, W_dir := df_save.windFor(CSVWindDirection)
+
 	buf.WriteString("date")
 	buf.WriteString(",TMP")
 	buf.WriteString(",MR")
@@ -39,6 +42,9 @@ func (df_save *MsmTarget) ToCSV(buf *bytes.Buffer) {
 	}
 	buf.WriteString(",w_spd")
 	buf.WriteString(",w_dir")
+	if df_save.TWB != nil {
+		buf.WriteString(",TWB,HR,ENTH,RHO,SV")
+	}
 	buf.WriteString("\n")
 
 	writeFloat := func(v float64) {
@@ -86,19 +92,118 @@ func (df_save *MsmTarget) ToCSV(buf *bytes.Buffer) {
 		if df_save.NR != nil {
 			writeFloat(df_save.NR[i])
 		}
-		writeFloat(df_save.W_spd[i])
-		writeFloat(df_save.W_dir[i])
+		writeFloat(W_spd[i])
+		writeFloat(W_dir[i])
+		if df_save.TWB != nil {
+			writeFloat(df_save.TWB[i])
+			writeFloat(df_save.HR[i])
+			writeFloat(df_save.ENTH[i])
+			writeFloat(df_save.RHO[i])
+			writeFloat(df_save.SV[i])
+		}
 		buf.WriteString("\n")
 	}
 }
 
+// JSON形式
+//
+// Note:
+//
+//	{"metadata": 計算条件, "columns": 列名, "data": 行ごとの値} の形式で出力します。
+//	列はCSV形式と同じです。値が無い場合(NaN)は null を出力します。
+func (df *MsmTarget) ToJSON(buf *bytes.Buffer) error {
+	type column struct {
+		name  string
+		value func(i int) float64
+	}
+	series := func(name string, v []float64) column {
+		return column{name, func(i int) float64 { return v[i] }}
+	}
+	W_spd, W_dir := df.windFor(JSONWindDirection)
+
+	columns := []column{series("TMP", df.TMP), series("MR", df.MR)}
+	if df.DSWRF_est != nil {
+		columns = append(columns, series("DSWRF_est", df.DSWRF_est))
+	}
+	if df.DSWRF_msm != nil {
+		columns = append(columns, series("DSWRF_msm", df.DSWRF_msm))
+	}
+	columns = append(columns,
+		series("Ld", df.Ld),
+		series("VGRD", df.VGRD),
+		series("UGRD", df.UGRD),
+		series("PRES", df.PRES),
+		series("APCP01", df.APCP01),
+		series("RH", df.RH),
+		series("Pw", df.Pw))
+	if df.DT != nil {
+		columns = append(columns, series("DT", df.DT))
+	}
+	columns = append(columns,
+		series("h", df.h),
+		series("A", df.A),
+		column{"DN_est", func(i int) float64 { return df.SR_est[i].DN }},
+		column{"SH_est", func(i int) float64 { return df.SR_est[i].SH }},
+		column{"DN_msm", func(i int) float64 { return df.SR_msm[i].DN }},
+		column{"SH_msm", func(i int) float64 { return df.SR_msm[i].SH }})
+	if df.NR != nil {
+		columns = append(columns, series("NR", df.NR))
+	}
+	columns = append(columns, series("w_spd", W_spd), series("w_dir", W_dir))
+	if df.TWB != nil {
+		columns = append(columns,
+			series("TWB", df.TWB),
+			series("HR", df.HR),
+			series("ENTH", df.ENTH),
+			series("RHO", df.RHO),
+			series("SV", df.SV))
+	}
+
+	meta, err := json.Marshal(df.Metadata)
+	if err != nil {
+		return err
+	}
+	buf.WriteString(`{"metadata":`)
+	buf.Write(meta)
+
+	buf.WriteString(`,"columns":["date"`)
+	for _, c := range columns {
+		buf.WriteString(`,"` + c.name + `"`)
+	}
+	buf.WriteString("],\n\"data\":[")
+
+	for i := 0; i < len(df.date); i++ {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("\n[\"")
+		buf.WriteString(df.date[i].Format("2006-01-02 15:04:05"))
+		buf.WriteString(`"`)
+		for _, c := range columns {
+			buf.WriteString(",")
+			if v := c.value(i); math.IsNaN(v) || math.IsInf(v, 0) {
+				buf.WriteString("null")
+			} else {
+				buf.WriteString(strconv.FormatFloat(v, 'g', -1, 64))
+			}
+		}
+		buf.WriteString("]")
+	}
+	buf.WriteString("]}\n")
+
+	return nil
+}
+
 // HASP形式
 //
 // Note:
 //
 //	法線面直達日射量、水平面天空日射量、水平面夜間日射量は0を出力します。
 //	曜日の祝日判定を行っていません。
+//	風向は16方位に丸め、風速が WindCalm 以下の場合は 0 (無風) とします。
 func (df *MsmTarget) ToHAS(out *bytes.Buffer) {
+	W_spd, W_dir := df.windFor(HASWindDirection)
+
 	for d := 0; d < 365; d++ {
 		off := d * 24
 
@@ -137,13 +242,9 @@ func (df *MsmTarget) ToHAS(out *bytes.Buffer) {
 
 		// 風向 (0:無風,1:NNE,...,16:N)
 		for h := 0; h < 24; h++ {
-			w_dir := int(df.W_dir[off+h]/22.5) + 1
-			if w_dir == 0 {
-				// 真北の場合を0から16へ変更
-				w_dir = 16
-			}
-			if df.W_spd[off+h] == 0 {
-				w_dir = 0 // 無風の場合は0
+			w_dir := hasWindDirection(W_dir[off+h])
+			if W_spd[off+h] <= df.WindCalm {
+				w_dir = 0 // 無風(静穏)の場合は0
 			}
 
 			out.Write([]byte(fmt.Sprintf("%3d", w_dir)))
@@ -152,7 +253,7 @@ func (df *MsmTarget) ToHAS(out *bytes.Buffer) {
 
 		// 風速 (0.1m/s)
 		for h := 0; h < 24; h++ {
-			w_spd := int(df.W_dir[off+h] * 10)
+			w_spd := int(W_spd[off+h] * 10)
 			out.Write([]byte(fmt.Sprintf("%3d", w_spd)))
 		}
 		out.Write([]byte(fmt.Sprintf("%s7\n", day_signature)))
@@ -165,6 +266,7 @@ func (df *MsmTarget) ToHAS(out *bytes.Buffer) {
 //
 //	"EnergyPlus Auxilary Programs"を参考に記述されました。
 //	外気温(単位:℃)、風向(単位:°)、風速(単位:m/s)、降水量の積算値(単位:mm/h)のみを出力します。
+//	風向は方位に丸めない値、風速はベクトル風速の大きさを既定とします。
 //	それ以外の値については、"missing"に該当する値を出力します。
 func (msm *MsmTarget) ToEPW(out *bytes.Buffer, lat float64, lon float64) {
 
@@ -197,6 +299,7 @@ func (msm *MsmTarget) ToEPW(out *bytes.Buffer, lat float64, lon float64) {
 	// DATA HEADER
 	out.Write([]byte("DATA PERIODS,1,1,Data,Sunday,1/1,12/31\n"))
 
+	W_spd, W_dir := msm.windFor(EPWWindDirection)
 	for i := 0; i < len(msm.date); i++ {
 		// N1: 年
 		// N2: 月
@@ -210,6 +313,6 @@ func (msm *MsmTarget) ToEPW(out *bytes.Buffer, lat float64, lon float64) {
 		// N22-N32: missing
 		// N33: APCP01
 		// N34: missing
-		out.Write([]byte(fmt.Sprintf("%d,%d,%d,%d,60,-,%.1f,99.9,999,999999,999,9999,9999,9999,9999,9999,999999,999999,999999,9999,%d,%.1f,99,99,9999,99999,9,999999999,999,0.999,999,99,999,%.1f,99\n", msm.date[i].Year(), msm.date[i].Month(), msm.date[i].Day(), msm.date[i].Hour()+1, msm.TMP[i], int(msm.W_dir[i]), msm.W_spd[i], msm.APCP01[i])))
+		out.Write([]byte(fmt.Sprintf("%d,%d,%d,%d,60,-,%.1f,99.9,999,999999,999,9999,9999,9999,9999,9999,999999,999999,999999,9999,%d,%.1f,99,99,9999,99999,9,999999999,999,0.999,999,99,999,%.1f,99\n", msm.date[i].Year(), msm.date[i].Month(), msm.date[i].Day(), msm.date[i].Hour()+1, msm.TMP[i], int(math.Round(W_dir[i])), W_spd[i], msm.APCP01[i])))
 	}
 }
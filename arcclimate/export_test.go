@@ -0,0 +1,155 @@
+package arcclimate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ToJSON(t *testing.T) {
+	lat, lon := 35.658, 139.741
+	mem := NewMemoryMsmSource()
+	for _, name := range RequiredMsmList(lat, lon) {
+		mem.Put(name, makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 365*24, 0.0))
+	}
+	opts := NewOptions(lat, lon)
+	opts.StartYear, opts.EndYear = 2011, 2011
+	opts.ElevationMode = ElevationMesh
+	opts.Source = mem
+	opts.UseCache, opts.SaveCache = false, false
+	res, err := InterpolateWithOptions(context.Background(), opts)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, res.ToJSON(&buf))
+
+	var doc struct {
+		Metadata RunMetadata     `json:"metadata"`
+		Columns  []string        `json:"columns"`
+		Data     [][]interface{} `json:"data"`
+	}
+	assert.NoError(t, json.Unmarshal(buf.Bytes(), &doc))
+	assert.Equal(t, lat, doc.Metadata.Lat)
+	assert.Equal(t, "date", doc.Columns[0])
+	assert.Equal(t, "TMP", doc.Columns[1])
+	assert.Len(t, doc.Data, 365*24)
+	assert.Len(t, doc.Data[0], len(doc.Columns))
+	assert.Equal(t, "2011-01-01 00:00:00", doc.Data[0][0])
+
+	// CSVと同じ列
+	var csv bytes.Buffer
+	res.ToCSV(&csv)
+	header, _ := csv.ReadString('\n')
+	assert.Equal(t, strings.TrimSuffix(header, "\n"), strings.Join(doc.Columns, ","))
+}
+
+func Test_ToHAS_Wind(t *testing.T) {
+	n := 365 * 24
+	msm := &MsmTarget{
+		date: make([]time.Time, n),
+		TMP:  make([]float64, n),
+		MR:   make([]float64, n),
+		UGRD: make([]float64, n),
+		VGRD: make([]float64, n),
+	}
+	for i := range msm.date {
+		msm.date[i] = time.Date(2011, 1, 1, i, 0, 0, 0, time.UTC)
+		msm.VGRD[i] = -2.0 // 北風 2m/s
+	}
+	msm.UGRD[1], msm.VGRD[1] = -0.1, 0.0 // 東風 0.1m/s (静穏)
+	msm.UGRD[2], msm.VGRD[2] = 0.0, 3.0  // 南風 3m/s
+	msm.WindVectorToDirAndSpeed(DefaultWindDirection)
+	msm.WindCalm = 0.2
+
+	var buf bytes.Buffer
+	msm.ToHAS(&buf)
+	lines := strings.Split(buf.String(), "\n")
+	assert.Equal(t, " 16  0  8 16", lines[5][:12])
+	assert.Equal(t, " 20  1 30 20", lines[6][:12])
+}
+
+// HAS形式の風向・風速の出力を固定する。
+// 以前の出力では、風速の行に風向 [°] の10倍を書き込み、風向は真北を1 (NNE) とする1方位ずれたコードでした。
+func Test_ToHAS_WindCodes(t *testing.T) {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	cases := []struct {
+		name       string
+		UGRD, VGRD float64
+		code, spd  int // 風向 (1:NNE,...,16:N)、風速 [0.1m/s]
+	}{
+		{"N", 0, -2, 16, 20},
+		{"NNE", -3.05 * math.Sin(rad(22.5)), -3.05 * math.Cos(rad(22.5)), 1, 30},
+		{"NE", -1, -1, 2, 14},
+		{"E", -4, 0, 4, 40},
+		{"S", 0, 3, 8, 30},
+		{"W", 5, 0, 12, 50},
+		{"NNW", 2.05 * math.Sin(rad(22.5)), -2.05 * math.Cos(rad(22.5)), 15, 20},
+		{"30deg", -2 * math.Sin(rad(30)), -2 * math.Cos(rad(30)), 1, 19}, // NNEへ丸め、風速は 2 cos(7.5°)
+	}
+
+	n := 365 * 24
+	msm := &MsmTarget{
+		date: make([]time.Time, n),
+		TMP:  make([]float64, n),
+		MR:   make([]float64, n),
+		UGRD: make([]float64, n),
+		VGRD: make([]float64, n),
+	}
+	for i := range msm.date {
+		msm.date[i] = time.Date(2011, 1, 1, i, 0, 0, 0, time.UTC)
+	}
+	for h, c := range cases {
+		msm.UGRD[h], msm.VGRD[h] = c.UGRD, c.VGRD
+	}
+	msm.WindVectorToDirAndSpeed(DefaultWindDirection)
+
+	var buf bytes.Buffer
+	msm.ToHAS(&buf)
+	lines := strings.Split(buf.String(), "\n")
+	for h, c := range cases {
+		assert.Equal(t, fmt.Sprintf("%3d", c.code), lines[5][h*3:h*3+3], c.name)
+		assert.Equal(t, fmt.Sprintf("%3d", c.spd), lines[6][h*3:h*3+3], c.name)
+	}
+}
+
+func Test_ToCSV_Psychrometrics(t *testing.T) {
+	lat, lon := 35.658, 139.741
+	mem := NewMemoryMsmSource()
+	for _, name := range RequiredMsmList(lat, lon) {
+		mem.Put(name, makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 365*24, 0.0))
+	}
+	opts := NewOptions(lat, lon)
+	opts.StartYear, opts.EndYear = 2011, 2011
+	opts.ElevationMode = ElevationMesh
+	opts.Source = mem
+	opts.UseCache, opts.SaveCache = false, false
+	res, err := InterpolateWithOptions(context.Background(), opts)
+	assert.NoError(t, err)
+
+	// 既定では出力しない
+	var csv bytes.Buffer
+	res.ToCSV(&csv)
+	header, _ := csv.ReadString('\n')
+	assert.True(t, strings.HasSuffix(header, ",w_spd,w_dir\n"))
+
+	opts.Psychrometrics = true
+	res, err = InterpolateWithOptions(context.Background(), opts)
+	assert.NoError(t, err)
+	assert.Len(t, res.TWB, 365*24)
+	assert.LessOrEqual(t, res.TWB[0], res.TMP[0])
+	assert.InDelta(t, res.MR[0]/1000, res.HR[0], 1e-15)
+
+	csv.Reset()
+	res.ToCSV(&csv)
+	header, _ = csv.ReadString('\n')
+	assert.True(t, strings.HasSuffix(header, ",w_spd,w_dir,TWB,HR,ENTH,RHO,SV\n"))
+	row, _ := csv.ReadString('\n')
+	assert.Equal(t, strings.Count(header, ","), strings.Count(row, ","))
+}
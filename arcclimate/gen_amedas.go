@@ -0,0 +1,78 @@
+//go:build ignore
+// +build ignore
+
+// 組み込みのアメダス観測所一覧 data/amedas_stations.csv を気象庁のアメダス観測所一覧 (amedastable.json) から作成します。
+//
+//	go generate ./arcclimate
+//	go run gen_amedas.go -src data/src/amedastable.json -o data/amedas_stations.csv
+//
+// -src にはファイルまたはURLを指定できます。既定では気象庁のWebサイトから取得します。
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/udawtr/arcclimate-go/arcclimate"
+)
+
+// 気象庁のアメダス観測所一覧のURL
+const jmaAmedasTableURL = "https://www.jma.go.jp/bosai/amedas/const/amedastable.json"
+
+func main() {
+	src := flag.String("src", jmaAmedasTableURL, "気象庁のアメダス観測所一覧 (ファイルまたはURL)")
+	output := flag.String("o", "data/amedas_stations.csv", "出力ファイル")
+	flag.Parse()
+
+	r, err := open(*src)
+	if err != nil {
+		log.Fatal(err)
+	}
+	table, err := arcclimate.ReadAmedasTable(r)
+	r.Close()
+	if err != nil {
+		log.Fatalf("%s: %v", *src, err)
+	}
+
+	ids := make([]string, 0, len(table))
+	for id := range table {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var buf bytes.Buffer
+	buf.WriteString("id,name,lat,lon,elevation\n")
+	for _, id := range ids {
+		st := table[id]
+		fmt.Fprintf(&buf, "%s,%s,%.4f,%.4f,%s\n", st.ID, st.Name, st.Lat, st.Lon,
+			strconv.FormatFloat(st.Elevation, 'f', -1, 64))
+	}
+	if err := os.WriteFile(*output, buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %d stations, %d bytes\n", *output, len(ids), buf.Len())
+}
+
+// ファイルまたはURL src を開きます。
+func open(src string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(src, "http://") && !strings.HasPrefix(src, "https://") {
+		return os.Open(src)
+	}
+	resp, err := http.Get(src)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", src, resp.Status)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,89 @@
+//go:build ignore
+// +build ignore
+
+// 組み込み標高データ data/elevation.bin をCSVから作成します。
+//
+//	go generate ./arcclimate
+//	go run gen_elevation.go -msm data/src/MSM_elevation.csv -mesh 'data/src/mesh_3d_ele_*.csv' -o data/elevation.bin
+//
+// 3次メッシュの平均標高は、1次メッシュごとのファイル(mesh_3d_ele_{1次メッシュコード}.csv、ヘッダー mesh23d,elevation)
+// または全国のファイル(ヘッダー meshcode,elevation)を指定できます。
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/udawtr/arcclimate-go/arcclimate/internal/elevdata"
+)
+
+func main() {
+	msmFile := flag.String("msm", "data/src/MSM_elevation.csv", "MSM格子点の標高のCSV")
+	meshGlob := flag.String("mesh", "data/src/mesh_3d_ele_*.csv", "3次メッシュの平均標高のCSV (glob)")
+	output := flag.String("o", "data/elevation.bin", "出力ファイル")
+	flag.Parse()
+
+	d := elevdata.Data{Mesh: make(map[int]map[int]string)}
+
+	file, err := os.Open(*msmFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	d.MSM, err = elevdata.ReadMSMCSV(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("%s: %v", *msmFile, err)
+	}
+
+	names, err := filepath.Glob(*meshGlob)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(names) == 0 {
+		log.Fatalf("no files match %s", *meshGlob)
+	}
+	for _, name := range names {
+		// 1次メッシュごとのファイルはファイル名の1次メッシュコード
+		mesh1d := -1
+		base := strings.TrimSuffix(filepath.Base(name), ".csv")
+		if i := strings.LastIndexByte(base, '_'); i >= 0 {
+			if code, err := strconv.Atoi(base[i+1:]); err == nil {
+				mesh1d = code
+			}
+		}
+
+		file, err := os.Open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = elevdata.ReadMeshCSV(file, mesh1d, d.Mesh)
+		file.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+	}
+	if _, ok := d.Mesh[-1]; ok {
+		log.Fatalf("1st mesh code is missing in the file name (want mesh_3d_ele_{code}.csv)")
+	}
+
+	var buf bytes.Buffer
+	if err := elevdata.Encode(&buf, &d); err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(*output, buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	meshes := 0
+	for _, m := range d.Mesh {
+		meshes += len(m)
+	}
+	fmt.Printf("%s: MSM %d×%d, %d 1st meshes, %d 3rd meshes, %d bytes\n",
+		*output, len(d.MSM), len(d.MSM[0]), len(d.Mesh), meshes, buf.Len())
+}
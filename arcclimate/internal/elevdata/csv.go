@@ -0,0 +1,65 @@
+package elevdata
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// MSM格子点の標高のCSV (MSM_elevation.csv、ヘッダー無し) を r から読み込みます。
+func ReadMSMCSV(r io.Reader) ([][]string, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	for i, record := range records {
+		for j, s := range record {
+			if _, err := strconv.ParseFloat(s, 64); err != nil {
+				return nil, fmt.Errorf("line %d, column %d: %w", i+1, j+1, err)
+			}
+		}
+	}
+	return records, nil
+}
+
+// 3次メッシュの平均標高のCSVを r から読み込み、mesh に追加します。
+// ヘッダーが "mesh23d,elevation" の場合は1次メッシュコード mesh1d のメッシュ(1次メッシュごとのファイル)、
+// "meshcode,elevation" の場合は8桁の3次メッシュコードとします。
+func ReadMeshCSV(r io.Reader, mesh1d int, mesh map[int]map[int]string) error {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	if len(header) != 2 || header[1] != "elevation" || (header[0] != "mesh23d" && header[0] != "meshcode") {
+		return fmt.Errorf("unknown header %v (want mesh23d,elevation or meshcode,elevation)", header)
+	}
+	full := header[0] == "meshcode"
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		code, err := strconv.Atoi(record[0])
+		if err != nil || code < 0 {
+			return fmt.Errorf("line %d: invalid mesh code %q", line, record[0])
+		}
+		if _, err := strconv.ParseFloat(record[1], 64); err != nil {
+			return fmt.Errorf("line %d: elevation: %w", line, err)
+		}
+
+		code1d, code23d := mesh1d, code
+		if full {
+			code1d, code23d = code/10000, code%10000
+		}
+		if mesh[code1d] == nil {
+			mesh[code1d] = make(map[int]string)
+		}
+		mesh[code1d][code23d] = record[1]
+	}
+}
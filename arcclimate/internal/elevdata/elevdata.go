@@ -0,0 +1,302 @@
+// 組み込み標高データのバイナリ形式
+//
+// MSM格子点の標高と3次メッシュの平均標高を、元のCSVの10進数表記のまま(仮数と10のべき数)可変長整数で保存し、
+// 全体を gzip で圧縮します。読み込んだ値は元のCSVを strconv.ParseFloat で読み込んだ値と一致します。
+//
+//	"ARCELEV1"
+//	MSM格子の行数, 列数, 各格子点の標高 (北の行から、各行は西から)
+//	1次メッシュ数
+//	1次メッシュごとに: 1次メッシュコード, データのバイト数, データ
+//	  データ: 3次メッシュ数, 3次メッシュごとに 2次・3次メッシュコード(前のメッシュとの差), 標高
+package elevdata
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const magic = "ARCELEV1"
+
+// 10のべき数の最大値 (6bit)
+const maxDecimals = 63
+
+// 標高データ (元のCSVの10進数表記)
+type Data struct {
+	MSM  [][]string             // MSM格子点の標高 [m] (北の行から、各行は西から)
+	Mesh map[int]map[int]string // 1次メッシュコード -> 2次・3次メッシュコード(4桁) -> 平均標高 [m]
+}
+
+// 10進数表記 s を仮数 m と10のべき数 k (値 = m × 10^-k) に変換します。
+// 変換した値が strconv.ParseFloat の値と一致しない場合はエラーを返します。
+func parseDecimal(s string) (int64, int, error) {
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	mant, exp := s, 0
+	if i := strings.IndexAny(s, "eE"); i >= 0 {
+		mant = s[:i]
+		if exp, err = strconv.Atoi(s[i+1:]); err != nil {
+			return 0, 0, fmt.Errorf("invalid exponent in %q", s)
+		}
+	}
+	k := 0
+	if i := strings.IndexByte(mant, '.'); i >= 0 {
+		k = len(mant) - i - 1
+		mant = mant[:i] + mant[i+1:]
+	}
+	k -= exp
+	for k < 0 {
+		mant += "0"
+		k++
+	}
+
+	m, err := strconv.ParseInt(mant, 10, 64)
+	if err != nil || k > maxDecimals {
+		return 0, 0, fmt.Errorf("cannot encode %q", s)
+	}
+	if got := decimalValue(m, k); math.Float64bits(got) != math.Float64bits(v) {
+		return 0, 0, fmt.Errorf("cannot encode %q exactly (got %v)", s, got)
+	}
+	return m, k, nil
+}
+
+// 10のべき数 (float64 で正確に表せる範囲)
+var pow10 = [...]float64{1e0, 1e1, 1e2, 1e3, 1e4, 1e5, 1e6, 1e7, 1e8, 1e9, 1e10, 1e11,
+	1e12, 1e13, 1e14, 1e15, 1e16, 1e17, 1e18, 1e19, 1e20, 1e21, 1e22}
+
+// 仮数 m と10のべき数 k の値 m × 10^-k
+// 仮数と10^k が正確に表せる場合は除算の結果(正しく丸めた値)、それ以外は strconv.ParseFloat の値とします。
+func decimalValue(m int64, k int) float64 {
+	if k < len(pow10) && -1<<53 <= m && m <= 1<<53 {
+		return float64(m) / pow10[k]
+	}
+	v, _ := strconv.ParseFloat(strconv.FormatInt(m, 10)+"e-"+strconv.Itoa(k), 64)
+	return v
+}
+
+// 標高データの書き込み
+type writer struct {
+	buf bytes.Buffer
+	tmp [binary.MaxVarintLen64]byte
+}
+
+func (w *writer) uvarint(v uint64) {
+	n := binary.PutUvarint(w.tmp[:], v)
+	w.buf.Write(w.tmp[:n])
+}
+
+func (w *writer) value(s string) error {
+	m, k, err := parseDecimal(s)
+	if err != nil {
+		return err
+	}
+	// ジグザグ符号化した仮数と10のべき数
+	z := uint64(m<<1) ^ uint64(m>>63)
+	if z > math.MaxUint64>>6 {
+		return fmt.Errorf("cannot encode %q", s)
+	}
+	w.uvarint(z<<6 | uint64(k))
+	return nil
+}
+
+// 標高データ d をバイナリ形式で out に書き込みます。
+func Encode(out io.Writer, d *Data) error {
+	var w writer
+	w.buf.WriteString(magic)
+
+	rows := len(d.MSM)
+	if rows == 0 {
+		return errors.New("no msm elevation")
+	}
+	cols := len(d.MSM[0])
+	w.uvarint(uint64(rows))
+	w.uvarint(uint64(cols))
+	for i, row := range d.MSM {
+		if len(row) != cols {
+			return fmt.Errorf("msm elevation row %d: %d columns (want %d)", i+1, len(row), cols)
+		}
+		for j, s := range row {
+			if err := w.value(s); err != nil {
+				return fmt.Errorf("msm elevation row %d, column %d: %w", i+1, j+1, err)
+			}
+		}
+	}
+
+	codes := make([]int, 0, len(d.Mesh))
+	for code := range d.Mesh {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	w.uvarint(uint64(len(codes)))
+	for _, code := range codes {
+		var block writer
+		meshes := d.Mesh[code]
+		codes23 := make([]int, 0, len(meshes))
+		for code23 := range meshes {
+			codes23 = append(codes23, code23)
+		}
+		sort.Ints(codes23)
+		block.uvarint(uint64(len(codes23)))
+		prev := 0
+		for _, code23 := range codes23 {
+			block.uvarint(uint64(code23 - prev))
+			prev = code23
+			if err := block.value(meshes[code23]); err != nil {
+				return fmt.Errorf("mesh %04d%04d: %w", code, code23, err)
+			}
+		}
+		w.uvarint(uint64(code))
+		w.uvarint(uint64(block.buf.Len()))
+		w.buf.Write(block.buf.Bytes())
+	}
+
+	zw, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := zw.Write(w.buf.Bytes()); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// 標高データの読み込み
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = errors.New("corrupted elevation data")
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) value() float64 {
+	v := r.uvarint()
+	k := int(v & maxDecimals)
+	z := v >> 6
+	m := int64(z>>1) ^ -int64(z&1)
+	return decimalValue(m, k)
+}
+
+func (r *reader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.b)) {
+		r.err = errors.New("corrupted elevation data")
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+// 読み込んだ標高データ
+// 3次メッシュの標高は1次メッシュごとに最初に参照したときに展開します。
+type Index struct {
+	MSM [][]float64 // MSM格子点の標高 [m] (北の行から、各行は西から)
+
+	blocks map[int]*block
+}
+
+// 1次メッシュの3次メッシュの標高
+type block struct {
+	data []byte
+	once sync.Once
+	mesh map[int]float64
+	err  error
+}
+
+// バイナリ形式の標高データ b を読み込みます。
+func Decode(b []byte) (*Index, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("read elevation data: %w", err)
+	}
+	raw, err := io.ReadAll(bufio.NewReader(zr))
+	if err != nil {
+		return nil, fmt.Errorf("read elevation data: %w", err)
+	}
+	if !bytes.HasPrefix(raw, []byte(magic)) {
+		return nil, errors.New("read elevation data: unknown format")
+	}
+
+	r := &reader{b: raw[len(magic):]}
+	rows, cols := r.uvarint(), r.uvarint()
+	if r.err == nil && rows*cols > uint64(len(r.b)) {
+		return nil, errors.New("read elevation data: corrupted elevation data")
+	}
+	x := &Index{MSM: make([][]float64, rows), blocks: make(map[int]*block)}
+	values := make([]float64, rows*cols)
+	for i := range x.MSM {
+		x.MSM[i] = values[uint64(i)*cols : uint64(i+1)*cols]
+		for j := range x.MSM[i] {
+			x.MSM[i][j] = r.value()
+		}
+	}
+
+	n := r.uvarint()
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		code := int(r.uvarint())
+		x.blocks[code] = &block{data: r.bytes(r.uvarint())}
+	}
+	if r.err != nil {
+		return nil, fmt.Errorf("read elevation data: %w", r.err)
+	}
+	return x, nil
+}
+
+// 1次メッシュコード code の3次メッシュの平均標高があるか
+func (x *Index) HasMesh(code int) bool {
+	_, ok := x.blocks[code]
+	return ok
+}
+
+// 1次メッシュコード code の3次メッシュの平均標高 (2次・3次メッシュコード(4桁) -> 標高 [m]) を返します。
+// データが無い場合は nil を返します。返す map は共有するため変更しないでください。
+func (x *Index) Mesh(code int) (map[int]float64, error) {
+	blk, ok := x.blocks[code]
+	if !ok {
+		return nil, nil
+	}
+	blk.once.Do(func() {
+		r := &reader{b: blk.data}
+		n := r.uvarint()
+		if r.err == nil && n > uint64(len(blk.data)) {
+			r.err = errors.New("corrupted elevation data")
+		}
+		mesh := make(map[int]float64, n)
+		code23 := 0
+		for i := uint64(0); i < n && r.err == nil; i++ {
+			code23 += int(r.uvarint())
+			mesh[code23] = r.value()
+		}
+		if r.err != nil {
+			blk.err = fmt.Errorf("read elevation data of 1st mesh code %d: %w", code, r.err)
+			return
+		}
+		blk.mesh = mesh
+	})
+	return blk.mesh, blk.err
+}
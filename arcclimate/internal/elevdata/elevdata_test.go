@@ -0,0 +1,67 @@
+package elevdata
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseDecimal(t *testing.T) {
+	for _, s := range []string{"0", "554.8", "-0.7", "1188.4989", "819.82745", "0.00022608897",
+		"1.966914e-30", "8.5290924e-17", "1.5E+3", "12"} {
+		m, k, err := parseDecimal(s)
+		assert.NoError(t, err, s)
+		v, _ := strconv.ParseFloat(s, 64)
+		assert.Equal(t, math.Float64bits(v), math.Float64bits(decimalValue(m, k)), s)
+	}
+
+	_, _, err := parseDecimal("-0")
+	assert.Error(t, err)
+	_, _, err = parseDecimal("abc")
+	assert.Error(t, err)
+}
+
+func Test_EncodeDecode(t *testing.T) {
+	d := &Data{
+		MSM: [][]string{{"0", "1.5", "-2.25"}, {"1e-20", "3000", "0.1"}},
+		Mesh: map[int]map[int]string{
+			5339: {0: "554.8", 4611: "3.2", 9999: "-1"},
+			3036: {123: "0"},
+		},
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, Encode(&buf, d))
+
+	x, err := Decode(buf.Bytes())
+	assert.NoError(t, err)
+	assert.Equal(t, [][]float64{{0, 1.5, -2.25}, {1e-20, 3000, 0.1}}, x.MSM)
+	assert.True(t, x.HasMesh(5339))
+	assert.False(t, x.HasMesh(5340))
+
+	mesh, err := x.Mesh(5339)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int]float64{0: 554.8, 4611: 3.2, 9999: -1}, mesh)
+	mesh, err = x.Mesh(5340)
+	assert.NoError(t, err)
+	assert.Nil(t, mesh)
+
+	// 壊れたデータ
+	_, err = Decode(buf.Bytes()[:buf.Len()/2])
+	assert.Error(t, err)
+	_, err = Decode([]byte("ARCELEV1"))
+	assert.Error(t, err)
+}
+
+func Test_ReadMeshCSV(t *testing.T) {
+	mesh := make(map[int]map[int]string)
+	assert.NoError(t, ReadMeshCSV(strings.NewReader("mesh23d,elevation\n0000,554.8\n4611,3.2\n"), 5339, mesh))
+	assert.NoError(t, ReadMeshCSV(strings.NewReader("meshcode,elevation\n30360123,0\n"), -1, mesh))
+	assert.Equal(t, map[int]map[int]string{5339: {0: "554.8", 4611: "3.2"}, 3036: {123: "0"}}, mesh)
+
+	assert.Error(t, ReadMeshCSV(strings.NewReader("code,value\n"), 5339, mesh))
+	assert.Error(t, ReadMeshCSV(strings.NewReader("mesh23d,elevation\n0000,abc\n"), 5339, mesh))
+}
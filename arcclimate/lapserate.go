@@ -0,0 +1,181 @@
+package arcclimate
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//--------------------------------------
+// 気温減率
+//--------------------------------------
+
+// 標高補正に用いる気温減率のモデル
+type LapseRate interface {
+	// 参照時刻 date (日本標準時) の気温減率 [℃/m] (高いほど気温が低い場合を正とします)
+	At(date time.Time) float64
+
+	// 計算条件の記録に使用する名前
+	String() string
+}
+
+// 気温減率の平均値 [℃/m]
+const standardLapseRate = 0.0065
+
+// 期間・時刻によらない一定の気温減率 [℃/m]
+type ConstantLapseRate float64
+
+// 既定の気温減率 (0.0065℃/m)
+var DefaultLapseRate LapseRate = ConstantLapseRate(standardLapseRate)
+
+func (r ConstantLapseRate) At(date time.Time) float64 { return float64(r) }
+
+func (r ConstantLapseRate) String() string {
+	return "constant:" + strconv.FormatFloat(float64(r), 'g', -1, 64)
+}
+
+// 月別の気温減率 [℃/m] (1月から12月の順)
+type MonthlyLapseRate [12]float64
+
+func (r *MonthlyLapseRate) At(date time.Time) float64 { return r[date.Month()-1] }
+
+func (r *MonthlyLapseRate) String() string {
+	values := make([]string, 12)
+	for i, v := range r {
+		values[i] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return "monthly:" + strings.Join(values, ",")
+}
+
+// 月別・時刻別の気温減率 [℃/m] (1月から12月, 0時から23時の順)
+type MonthlyHourlyLapseRate [12][24]float64
+
+func (r *MonthlyHourlyLapseRate) At(date time.Time) float64 { return r[date.Month()-1][date.Hour()] }
+
+// 表の値のハッシュで表の内容を識別します。
+func (r *MonthlyHourlyLapseRate) String() string {
+	h := sha256.New()
+	for _, row := range r {
+		for _, v := range row {
+			fmt.Fprintf(h, "%g,", v)
+		}
+	}
+	return "monthly-hourly:sha256:" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// 標高補正を行わないことを表す気温減率
+// 気温・気圧・重量絶対湿度のいずれも補正しません。
+var NoLapseRate LapseRate = noLapseRate{}
+
+type noLapseRate struct{}
+
+func (noLapseRate) At(date time.Time) float64 { return 0 }
+
+func (noLapseRate) String() string { return "none" }
+
+// 文字列 spec を気温減率に変換します。
+// "constant" (0.0065℃/m), "constant:値", "monthly:1月の値,...,12月の値", "none" (標高補正なし) を指定できます。
+// 月別・時刻別の表は ReadLapseRateTable で読み込みます。
+func ParseLapseRate(spec string) (LapseRate, error) {
+	kind, value := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		kind, value = spec[:i], spec[i+1:]
+	}
+
+	switch kind {
+	case "", "constant":
+		if value == "" {
+			return DefaultLapseRate, nil
+		}
+		v, err := parseLapseRateValue(value)
+		if err != nil {
+			return nil, err
+		}
+		return ConstantLapseRate(v), nil
+
+	case "monthly":
+		values := strings.Split(value, ",")
+		if len(values) != 12 {
+			return nil, fmt.Errorf("monthly lapse rate needs 12 values, got %d", len(values))
+		}
+		var r MonthlyLapseRate
+		for i, s := range values {
+			v, err := parseLapseRateValue(s)
+			if err != nil {
+				return nil, err
+			}
+			r[i] = v
+		}
+		return &r, nil
+
+	case "none":
+		if value != "" {
+			break
+		}
+		return NoLapseRate, nil
+	}
+	return nil, fmt.Errorf("unknown lapse rate %q (want constant[:value], monthly:12 values or none)", spec)
+}
+
+// 気温減率 [℃/m] の値 s を解析します。
+func parseLapseRateValue(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || math.Abs(v) > 0.05 || math.IsNaN(v) {
+		return math.NaN(), fmt.Errorf("invalid lapse rate %q (want a value in ℃/m, e.g. 0.0065)", s)
+	}
+	return v, nil
+}
+
+// CSV形式の気温減率の表 [℃/m] を読み込みます。
+// 12行(1月から12月)で、各行が1列の場合は月別、24列(0時から23時)の場合は月別・時刻別の気温減率とします。
+// 先頭の数値でない行は列名として読み飛ばします。
+func ReadLapseRateTable(r io.Reader) (LapseRate, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) > 0 && len(records[0]) > 0 {
+		if _, err := strconv.ParseFloat(strings.TrimSpace(records[0][0]), 64); err != nil {
+			records = records[1:]
+		}
+	}
+	if len(records) != 12 {
+		return nil, fmt.Errorf("lapse rate table needs 12 rows (January to December), got %d", len(records))
+	}
+
+	switch len(records[0]) {
+	case 1:
+		var monthly MonthlyLapseRate
+		for m, record := range records {
+			v, err := parseLapseRateValue(record[0])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", m+1, err)
+			}
+			monthly[m] = v
+		}
+		return &monthly, nil
+
+	case 24:
+		var hourly MonthlyHourlyLapseRate
+		for m, record := range records {
+			for h, s := range record {
+				v, err := parseLapseRateValue(s)
+				if err != nil {
+					return nil, fmt.Errorf("month %d, hour %d: %w", m+1, h, err)
+				}
+				hourly[m][h] = v
+			}
+		}
+		return &hourly, nil
+	}
+	return nil, fmt.Errorf("lapse rate table needs 1 (monthly) or 24 (monthly and hourly) columns, got %d", len(records[0]))
+}
@@ -0,0 +1,82 @@
+package arcclimate
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseLapseRate(t *testing.T) {
+	lapse, err := ParseLapseRate("constant")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultLapseRate, lapse)
+	assert.Equal(t, "constant:0.0065", lapse.String())
+
+	lapse, err = ParseLapseRate("constant:0.005")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.005, lapse.At(time.Date(2011, 7, 1, 12, 0, 0, 0, time.UTC)))
+
+	lapse, err = ParseLapseRate("monthly:0.001,0.002,0.003,0.004,0.005,0.006,0.007,0.008,0.009,0.01,0.011,0.012")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.003, lapse.At(time.Date(2011, 3, 31, 23, 0, 0, 0, time.UTC)))
+	assert.True(t, strings.HasPrefix(lapse.String(), "monthly:0.001,0.002,"))
+
+	lapse, err = ParseLapseRate("none")
+	assert.NoError(t, err)
+	assert.Equal(t, NoLapseRate, lapse)
+
+	for _, spec := range []string{"linear", "constant:abc", "constant:1", "monthly:0.006", "none:1"} {
+		_, err := ParseLapseRate(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func Test_ReadLapseRateTable(t *testing.T) {
+	// 月別
+	monthly := "lapse_rate\n" + strings.Repeat("0.005\n", 11) + "0.007\n"
+	lapse, err := ReadLapseRateTable(strings.NewReader(monthly))
+	assert.NoError(t, err)
+	assert.Equal(t, 0.007, lapse.At(time.Date(2011, 12, 1, 0, 0, 0, 0, time.UTC)))
+
+	// 月別・時刻別
+	row := strings.TrimSuffix(strings.Repeat("0.004,", 23), ",") + ",0.008\n"
+	lapse, err = ReadLapseRateTable(strings.NewReader(strings.Repeat(row, 12)))
+	assert.NoError(t, err)
+	assert.Equal(t, 0.004, lapse.At(time.Date(2011, 5, 1, 22, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 0.008, lapse.At(time.Date(2011, 5, 1, 23, 0, 0, 0, time.UTC)))
+	assert.True(t, strings.HasPrefix(lapse.String(), "monthly-hourly:sha256:"))
+
+	// 行数・列数の誤り
+	_, err = ReadLapseRateTable(strings.NewReader(strings.Repeat("0.005\n", 11)))
+	assert.Error(t, err)
+	_, err = ReadLapseRateTable(strings.NewReader(strings.Repeat("0.005,0.006\n", 12)))
+	assert.Error(t, err)
+}
+
+func Test_CorrectedMsm_TMP_PRES_MR_LapseRate(t *testing.T) {
+	msm := makeMsmData(t, "238-315", time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 3)
+	for i := range msm.Rows {
+		msm.Rows[i].TMP, msm.Rows[i].PRES, msm.Rows[i].MR = 20.0, 101325.0, 10.0
+	}
+
+	// 既定の気温減率は従来の補正式と一致する
+	def := msm.CorrectedMsm_TMP_PRES_MR(0.0, 100.0, nil)
+	assert.Equal(t, CorrectTMP(20.0, 100.0), def.Rows[0].TMP)
+	assert.Equal(t, CorrectPRES(101325.0, 100.0, def.Rows[0].TMP), def.Rows[0].PRES)
+
+	// 気温減率が大きいほど気温は低い
+	steep := msm.CorrectedMsm_TMP_PRES_MR(0.0, 100.0, ConstantLapseRate(0.01))
+	assert.InDelta(t, 19.0, steep.Rows[0].TMP, 1e-9)
+
+	// 気温減率0でも気圧は標高に応じて低下する
+	iso := msm.CorrectedMsm_TMP_PRES_MR(0.0, 100.0, ConstantLapseRate(0))
+	assert.Equal(t, 20.0, iso.Rows[0].TMP)
+	assert.InDelta(t, def.Rows[0].PRES, iso.Rows[0].PRES, 10.0)
+	assert.Less(t, iso.Rows[0].PRES, 101325.0)
+
+	// 補正しない
+	none := msm.CorrectedMsm_TMP_PRES_MR(0.0, 100.0, NoLapseRate)
+	assert.True(t, equalMsmRows(msm.Rows, none.Rows))
+}
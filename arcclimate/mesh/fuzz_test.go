@@ -0,0 +1,58 @@
+//go:build go1.18
+// +build go1.18
+
+package mesh
+
+import (
+	"testing"
+)
+
+// メッシュコード → 中心の緯度経度 → メッシュコード の往復で同じメッシュコードになることを確認する
+func FuzzParse(f *testing.F) {
+	for _, s := range []string{"5339", "533946", "53394611", "533946113", "5339461132", "53394611323", "00000000", "99997799"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		c, err := Parse(s)
+		if err != nil {
+			return
+		}
+		if c.String() != s {
+			t.Fatalf("Parse(%q).String() = %q", s, c.String())
+		}
+		lat, lon := c.Center()
+		c2, err := FromLatLon(lat, lon, c.Level)
+		if err != nil {
+			t.Fatalf("FromLatLon(center of %q): %v", s, err)
+		}
+		if c2 != c {
+			t.Fatalf("FromLatLon(center of %q) = %q", s, c2.String())
+		}
+	})
+}
+
+// 緯度経度 → メッシュコード → 解析 の往復で同じメッシュとなり、メッシュの範囲に地点が含まれることを確認する
+func FuzzFromLatLon(f *testing.F) {
+	f.Add(35.6812, 139.7671, 3)
+	f.Add(36.0, 138.0, 6)
+	f.Add(20.0, 122.9375, 1)
+	f.Fuzz(func(t *testing.T, lat float64, lon float64, l int) {
+		c, err := FromLatLon(lat, lon, Level(l))
+		if err != nil {
+			return
+		}
+		c2, err := Parse(c.String())
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", c.String(), err)
+		}
+		if c2 != c {
+			t.Fatalf("Parse(%q) = %+v, want %+v", c.String(), c2, c)
+		}
+		// 浮動小数点の誤差を許容する
+		const eps = 1e-9
+		b := c.Bounds()
+		if lat < b.South-eps || lat > b.North+eps || lon < b.West-eps || lon > b.East+eps {
+			t.Fatalf("%v, %v is outside %s %+v", lat, lon, c, b)
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package mesh
+
+import (
+	"encoding/json"
+)
+
+//--------------------------------------
+// GeoJSON (RFC 7946) 出力
+//--------------------------------------
+
+// GeoJSON の Feature
+type Feature struct {
+	Type       string                 `json:"type"` // "Feature"
+	Geometry   Polygon                `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// GeoJSON の Polygon
+// 座標は [経度, 緯度] の順です。
+type Polygon struct {
+	Type        string         `json:"type"` // "Polygon"
+	Coordinates [][][2]float64 `json:"coordinates"`
+}
+
+// GeoJSON の FeatureCollection
+type FeatureCollection struct {
+	Type     string    `json:"type"` // "FeatureCollection"
+	Features []Feature `json:"features"`
+}
+
+// メッシュの範囲を表す Polygon (南西から反時計回り)
+func (c Code) Polygon() Polygon {
+	b := c.Bounds()
+	return Polygon{
+		Type: "Polygon",
+		Coordinates: [][][2]float64{{
+			{b.West, b.South},
+			{b.East, b.South},
+			{b.East, b.North},
+			{b.West, b.North},
+			{b.West, b.South},
+		}},
+	}
+}
+
+// メッシュの範囲を表す Feature
+// properties にはメッシュコード "meshcode" と次数 "level" を設定します。
+func (c Code) Feature() Feature {
+	return Feature{
+		Type:     "Feature",
+		Geometry: c.Polygon(),
+		Properties: map[string]interface{}{
+			"meshcode": c.String(),
+			"level":    c.Level.String(),
+		},
+	}
+}
+
+// メッシュ codes の FeatureCollection
+func NewFeatureCollection(codes []Code) FeatureCollection {
+	fc := FeatureCollection{Type: "FeatureCollection", Features: make([]Feature, len(codes))}
+	for i, c := range codes {
+		fc.Features[i] = c.Feature()
+	}
+	return fc
+}
+
+// メッシュの範囲を GeoJSON の Feature として出力します。
+func (c Code) MarshalGeoJSON() ([]byte, error) {
+	return json.Marshal(c.Feature())
+}
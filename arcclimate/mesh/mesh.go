@@ -0,0 +1,211 @@
+// 標準地域メッシュ (JIS X 0410) のメッシュコード処理
+// ref: 『統計に用いる標準地域メッシュおよび標準地域メッシュコード』
+package mesh
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// メッシュの次数
+type Level int
+
+const (
+	Level1       Level = 1 // 1次メッシュ (約80km, 4桁)
+	Level2       Level = 2 // 2次メッシュ (約10km, 6桁)
+	Level3       Level = 3 // 3次メッシュ (約1km, 8桁)
+	LevelHalf    Level = 4 // 2分の1地域メッシュ (約500m, 9桁)
+	LevelQuarter Level = 5 // 4分の1地域メッシュ (約250m, 10桁)
+	LevelEighth  Level = 6 // 8分の1地域メッシュ (約125m, 11桁)
+)
+
+// 次数ごとのメッシュコードの桁数
+var codeLength = [...]int{Level1: 4, Level2: 6, Level3: 8, LevelHalf: 9, LevelQuarter: 10, LevelEighth: 11}
+
+// 上位のメッシュを分割する数 (緯度・経度方向とも)
+var divisions = [...]int{Level1: 1, Level2: 8, Level3: 10, LevelHalf: 2, LevelQuarter: 2, LevelEighth: 2}
+
+// 次数 level が定義されているか
+func (level Level) valid() bool {
+	return Level1 <= level && level <= LevelEighth
+}
+
+// 1次メッシュ1辺あたりのメッシュ数
+func (level Level) span() int {
+	n := 1
+	for l := Level2; l <= level; l++ {
+		n *= divisions[l]
+	}
+	return n
+}
+
+// 1度あたりのメッシュ数 (緯度方向, 経度方向)
+// 1次メッシュは緯度40分, 経度1度
+func (level Level) perDegree() (float64, float64) {
+	n := float64(level.span())
+	return 1.5 * n, n
+}
+
+func (level Level) String() string {
+	switch level {
+	case Level1, Level2, Level3:
+		return fmt.Sprintf("%d", int(level))
+	case LevelHalf:
+		return "1/2"
+	case LevelQuarter:
+		return "1/4"
+	case LevelEighth:
+		return "1/8"
+	}
+	return fmt.Sprintf("Level(%d)", int(level))
+}
+
+// 標準地域メッシュ
+// Y, X は緯度0度・経度100度を原点とし、南・西から数えた同じ次数のメッシュの番号です。
+type Code struct {
+	Level Level
+	Y     int // 緯度方向の番号
+	X     int // 経度方向の番号
+}
+
+// 緯度 lat, 経度 lon (10進法) の地点を含む次数 level のメッシュを返します。
+// メッシュの南端・西端はそのメッシュに含み、北端・東端は含みません。
+func FromLatLon(lat float64, lon float64, level Level) (Code, error) {
+	if !level.valid() {
+		return Code{}, fmt.Errorf("invalid mesh level %d", int(level))
+	}
+	nlat, nlon := level.perDegree()
+	y := math.Floor(lat * nlat)
+	x := math.Floor((lon - 100) * nlon)
+	limit := float64(100 * level.span())
+	if math.IsNaN(y) || y < 0 || y >= limit || math.IsNaN(x) || x < 0 || x >= limit {
+		return Code{}, fmt.Errorf("latitude %v, longitude %v is outside the mesh area", lat, lon)
+	}
+	return Code{Level: level, Y: int(y), X: int(x)}, nil
+}
+
+// メッシュコード s を解析します。次数は桁数から判定します。
+func Parse(s string) (Code, error) {
+	level := Level(0)
+	for l := Level1; l <= LevelEighth; l++ {
+		if codeLength[l] == len(s) {
+			level = l
+		}
+	}
+	if level == 0 {
+		return Code{}, fmt.Errorf("invalid mesh code %q: want 4, 6, 8, 9, 10 or 11 digits", s)
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return Code{}, fmt.Errorf("invalid mesh code %q: want digits only", s)
+		}
+	}
+	digit := func(i int) int { return int(s[i] - '0') }
+
+	// 1次メッシュ: 緯度×1.5, 経度-100 の各2桁
+	y, _ := strconv.Atoi(s[0:2])
+	x, _ := strconv.Atoi(s[2:4])
+
+	for l := Level2; l <= level; l++ {
+		switch l {
+		case Level2, Level3:
+			// 南西を0として緯度・経度方向の番号を1桁ずつ
+			i := codeLength[l] - 2
+			dy, dx := digit(i), digit(i+1)
+			if dy >= divisions[l] || dx >= divisions[l] {
+				return Code{}, fmt.Errorf("invalid mesh code %q: digits %d-%d must be 0-%d", s, i+1, i+2, divisions[l]-1)
+			}
+			y = y*divisions[l] + dy
+			x = x*divisions[l] + dx
+		default:
+			// 分割地域メッシュ: 南西=1, 南東=2, 北西=3, 北東=4
+			i := codeLength[l] - 1
+			d := digit(i)
+			if d < 1 || d > 4 {
+				return Code{}, fmt.Errorf("invalid mesh code %q: digit %d must be 1-4", s, i+1)
+			}
+			y = y*2 + (d-1)/2
+			x = x*2 + (d-1)%2
+		}
+	}
+
+	return Code{Level: level, Y: y, X: x}, nil
+}
+
+// メッシュコード (例: "53394611")
+func (c Code) String() string {
+	if !c.Level.valid() {
+		return fmt.Sprintf("Code{Level: %d, Y: %d, X: %d}", int(c.Level), c.Y, c.X)
+	}
+
+	// 上位のメッシュから順に桁を並べるため、下位のメッシュの桁を先に求める
+	digits := make([]byte, codeLength[c.Level])
+	y, x := c.Y, c.X
+	for l := c.Level; l >= Level2; l-- {
+		switch l {
+		case Level2, Level3:
+			i := codeLength[l] - 2
+			digits[i] = byte('0' + y%divisions[l])
+			digits[i+1] = byte('0' + x%divisions[l])
+		default:
+			i := codeLength[l] - 1
+			digits[i] = byte('1' + y%2*2 + x%2)
+		}
+		y /= divisions[l]
+		x /= divisions[l]
+	}
+	copy(digits, fmt.Sprintf("%02d%02d", y, x))
+
+	return string(digits)
+}
+
+// 次数 level (c 以上の大きさ) の上位のメッシュを返します。
+func (c Code) Parent(level Level) (Code, error) {
+	if !level.valid() || level > c.Level {
+		return Code{}, fmt.Errorf("invalid parent level %s of %s mesh", level, c.Level)
+	}
+	n := c.Level.span() / level.span()
+	return Code{Level: level, Y: c.Y / n, X: c.X / n}, nil
+}
+
+// メッシュの範囲 (緯度・経度, 10進法)
+type Bounds struct {
+	South float64
+	West  float64
+	North float64
+	East  float64
+}
+
+// メッシュの範囲
+func (c Code) Bounds() Bounds {
+	nlat, nlon := c.Level.perDegree()
+	return Bounds{
+		South: float64(c.Y) / nlat,
+		West:  100 + float64(c.X)/nlon,
+		North: float64(c.Y+1) / nlat,
+		East:  100 + float64(c.X+1)/nlon,
+	}
+}
+
+// メッシュの中心の緯度 lat, 経度 lon (10進法)
+func (c Code) Center() (lat float64, lon float64) {
+	nlat, nlon := c.Level.perDegree()
+	return (float64(c.Y) + 0.5) / nlat, 100 + (float64(c.X)+0.5)/nlon
+}
+
+// 隣接する同じ次数のメッシュ (北から時計回り、最大8件)
+// メッシュの定義範囲外となる隣接メッシュは含みません。
+func (c Code) Neighbors() []Code {
+	offsets := [8][2]int{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+	limit := 100 * c.Level.span()
+	neighbors := make([]Code, 0, len(offsets))
+	for _, o := range offsets {
+		y, x := c.Y+o[0], c.X+o[1]
+		if y < 0 || y >= limit || x < 0 || x >= limit {
+			continue
+		}
+		neighbors = append(neighbors, Code{Level: c.Level, Y: y, X: x})
+	}
+	return neighbors
+}
@@ -0,0 +1,107 @@
+package mesh
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FromLatLon(t *testing.T) {
+	// 東京駅付近
+	lat, lon := 35.6812, 139.7671
+	for level, want := range map[Level]string{
+		Level1:       "5339",
+		Level2:       "533946",
+		Level3:       "53394611",
+		LevelHalf:    "533946113",
+		LevelQuarter: "5339461132",
+		LevelEighth:  "53394611323",
+	} {
+		c, err := FromLatLon(lat, lon, level)
+		assert.NoError(t, err)
+		assert.Equal(t, want, c.String(), level.String())
+
+		// メッシュの範囲に地点が含まれることを確認する
+		b := c.Bounds()
+		assert.True(t, b.South <= lat && lat < b.North, level.String())
+		assert.True(t, b.West <= lon && lon < b.East, level.String())
+	}
+
+	// 範囲外
+	for _, p := range [][2]float64{{-1, 139}, {67, 139}, {35, 99}, {35, 200}} {
+		_, err := FromLatLon(p[0], p[1], Level3)
+		assert.Error(t, err)
+	}
+	_, err := FromLatLon(lat, lon, Level(7))
+	assert.Error(t, err)
+}
+
+func Test_Parse(t *testing.T) {
+	c, err := Parse("54380000")
+	assert.NoError(t, err)
+	assert.Equal(t, Level3, c.Level)
+	b := c.Bounds()
+	assert.InDelta(t, 36, b.South, 1e-9)
+	assert.InDelta(t, 138, b.West, 1e-9)
+	assert.InDelta(t, 36+30.0/3600, b.North, 1e-9)
+	assert.InDelta(t, 138+45.0/3600, b.East, 1e-9)
+	lat, lon := c.Center()
+	assert.InDelta(t, 36+15.0/3600, lat, 1e-9)
+	assert.InDelta(t, 138+22.5/3600, lon, 1e-9)
+
+	// 分割地域メッシュ (北東) の範囲
+	c, err = Parse("543800004")
+	assert.NoError(t, err)
+	assert.Equal(t, LevelHalf, c.Level)
+	b = c.Bounds()
+	assert.InDelta(t, 36+15.0/3600, b.South, 1e-9)
+	assert.InDelta(t, 138+22.5/3600, b.West, 1e-9)
+
+	// 上位のメッシュ
+	p, err := c.Parent(Level1)
+	assert.NoError(t, err)
+	assert.Equal(t, "5438", p.String())
+	_, err = p.Parent(Level2)
+	assert.Error(t, err)
+
+	// 不正なメッシュコード
+	for _, s := range []string{"", "543", "54380", "5438x000", "54388000", "543800000", "543800005"} {
+		_, err := Parse(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func Test_Neighbors(t *testing.T) {
+	c, _ := Parse("53394611")
+	var codes []string
+	for _, n := range c.Neighbors() {
+		codes = append(codes, n.String())
+	}
+	assert.Equal(t, []string{"53394621", "53394622", "53394612", "53394602", "53394601", "53394600", "53394610", "53394620"}, codes)
+
+	// 上位のメッシュをまたぐ隣接メッシュ
+	c, _ = Parse("53394699")
+	assert.Equal(t, "53395700", c.Neighbors()[1].String())
+
+	// 定義範囲の端
+	c, _ = Parse("00000000")
+	assert.Len(t, c.Neighbors(), 3)
+}
+
+func Test_Feature(t *testing.T) {
+	c, _ := Parse("5438")
+	b, err := c.MarshalGeoJSON()
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"type": "Feature",
+		"geometry": {"type": "Polygon", "coordinates": [[[138, 36], [139, 36], [139, 36.666666666666664], [138, 36.666666666666664], [138, 36]]]},
+		"properties": {"meshcode": "5438", "level": "1"}
+	}`, string(b))
+
+	fc := NewFeatureCollection(c.Neighbors())
+	b, err = json.Marshal(fc)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"type":"FeatureCollection"`)
+	assert.Len(t, fc.Features, 8)
+}
@@ -27,3 +27,22 @@ func Test_get_mesh_latlon(t *testing.T) {
 	assert.InDelta(t, 36, lat, 0.01)
 	assert.InDelta(t, 138, lon, 0.01)
 }
+
+func Test_MeshCodeLatLon(t *testing.T) {
+	// 3次メッシュの中心の緯度経度が取得できることを確認する
+	lat, lon, err := MeshCodeLatLon("53394611")
+	assert.NoError(t, err)
+	assert.InDelta(t, 35.679167, lat, 1e-6)
+	assert.InDelta(t, 139.76875, lon, 1e-6)
+
+	// 中心の緯度経度から同じメッシュコードが得られることを確認する
+	meshcode1d, meshcode23d := MeshCodeFromLatLon(lat, lon)
+	assert.Equal(t, 5339, meshcode1d)
+	assert.Equal(t, 4611, meshcode23d)
+
+	// 不正なメッシュコード
+	for _, code := range []string{"", "5339461", "533946110111", "5339x611", "53398611"} {
+		_, _, err := MeshCodeLatLon(code)
+		assert.Error(t, err, code)
+	}
+}
@@ -0,0 +1,68 @@
+package arcclimate
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+//--------------------------------------
+// 計算条件の記録
+//--------------------------------------
+
+// 推定結果の作成に使用した条件
+type RunMetadata struct {
+	Lat float64 `json:"lat"` // 推計対象地点の緯度（10進法）
+	Lon float64 `json:"lon"` // 推計対象地点の経度（10進法）
+
+	Mode             Mode             `json:"mode"`
+	SeparationMethod SeparationMethod `json:"separation_method"`
+	StartYear        int              `json:"start_year"`
+	EndYear          int              `json:"end_year"`
+	UseEst           bool             `json:"use_est"`
+
+	// 読み込んだMSMデータの期間(最初と最後の参照時刻)
+	DataStart time.Time `json:"data_start"`
+	DataEnd   time.Time `json:"data_end"`
+
+	// 推計対象地点の標高 [m] と実際に使用した標高の判定方法
+	Elevation     float64       `json:"elevation"`
+	ElevationMode ElevationMode `json:"elevation_mode"`
+
+	Offline    bool      `json:"offline"`     // ネットワークを使用しなかったか
+	MsmFiles   []string  `json:"msm_files"`   // 使用したMSMファイルのメッシュ地点番号(SW,SE,NW,NEの順、16地点の場合は南の行から西から順)
+	MsmWeights []float64 `json:"msm_weights"` // 各MSM地点の按分の重み(MsmFiles と同じ順)
+
+	// 空間補間の方法と逆距離加重のべき数、周囲のMSM地点数
+	Interpolation InterpolationMethod `json:"interpolation"`
+	IDWPower      float64             `json:"idw_power,omitempty"`
+	Neighborhood  int                 `json:"neighborhood"`
+
+	// 標高補正に使用した気温減率 (constant:値, monthly:値, monthly-hourly:sha256:ハッシュ, none)
+	LapseRate string `json:"lapse_rate"`
+
+	// 風速の高さ・地表面粗度の換算の条件と、MSMの地上10mの風速に対する倍率
+	WindConversion *WindConversion `json:"wind_conversion,omitempty"`
+	WindFactor     float64         `json:"wind_factor,omitempty"`
+
+	// 風向風速の計算条件 (省略時は出力形式ごとの既定値) と静穏とみなす風速の上限 [m/s]
+	WindDirection *WindDirection `json:"wind_direction,omitempty"`
+	WindCalm      float64        `json:"wind_calm,omitempty"`
+
+	// 空間補間から除外した海上のMSM地点のメッシュ地点番号
+	ExcludedSeaPoints []string `json:"excluded_sea_points,omitempty"`
+
+	// 計算結果の信頼性に関する警告 (推計対象地点とMSMの標高差、海上のMSM地点など)
+	Warnings []string `json:"warnings,omitempty"`
+
+	// 結合した期間別アーカイブ名(古い順)と重複時の優先
+	Archives   []string      `json:"msm_archives,omitempty"`
+	Precedence MsmPrecedence `json:"msm_precedence,omitempty"`
+}
+
+// 計算条件を JSON 形式で w に書き込みます。
+func (meta *RunMetadata) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(meta)
+}
@@ -0,0 +1,216 @@
+package arcclimate
+
+import (
+	"fmt"
+	"math"
+)
+
+//--------------------------------------
+// 空間補間に使用する周囲のMSM地点
+//--------------------------------------
+
+// 空間補間に使用する周囲のMSM地点数
+const (
+	Neighborhood4  = 4  // 推計対象地点を囲む4地点 (2×2)
+	Neighborhood16 = 16 // 推計対象地点を囲む4地点とその外周の16地点 (4×4)
+)
+
+// 周囲のMSM地点数 n の妥当性を確認します。
+func validateNeighborhood(n int) error {
+	if n != Neighborhood4 && n != Neighborhood16 {
+		return fmt.Errorf("invalid neighborhood %d (want 4 or 16)", n)
+	}
+	return nil
+}
+
+// 緯度 lat, 経度 lon の周囲 n 地点のMSMの番号 (北始まり, 西始まり) を返します。
+// 南の行から順に、各行は西から順に並べます (4地点の場合は SW,SE,NW,NE の順)。
+func msmNeighborhood(lat float64, lon float64, n int) [][2]int {
+	MSM_S, MSM_N, MSM_W, MSM_E := Meshcode1d(lat, lon)
+
+	rows := []int{MSM_S, MSM_N}
+	cols := []int{MSM_W, MSM_E}
+	if n == Neighborhood16 {
+		rows = []int{MSM_S + 1, MSM_S, MSM_N, MSM_N - 1}
+		cols = []int{MSM_W - 1, MSM_W, MSM_E, MSM_E + 1}
+	}
+
+	points := make([][2]int, 0, len(rows)*len(cols))
+	for _, sn := range rows {
+		for _, we := range cols {
+			points = append(points, [2]int{sn, we})
+		}
+	}
+	return points
+}
+
+// 緯度 lat, 経度 lon の周囲 n 地点 (4 または 16) のメッシュ地点番号を返します。
+// 南の行から順に、各行は西から順に並べます (4地点の場合は RequiredMsmList と同じ)。
+func RequiredMsmListN(lat float64, lon float64, n int) []string {
+	points := msmNeighborhood(lat, lon, n)
+	list := make([]string, len(points))
+	for i, p := range points {
+		list[i] = fmt.Sprintf("%d-%d", p[0], p[1])
+	}
+	return list
+}
+
+// 緯度 lat, 経度 lon の周囲 n 地点 (4 または 16) のMSMの標高を RequiredMsmListN と同じ順で返します。
+// 各MSMファイルの標高は eleMstr に格納されている値を使用します。
+func ElevationsN(lat float64, lon float64, eleMstr *ElevationMaster, n int) []float64 {
+	points := msmNeighborhood(lat, lon, n)
+	elevations := make([]float64, len(points))
+	for i, p := range points {
+		elevations[i] = eleMstr.Elevation2d(p[0], p[1])
+	}
+	return elevations
+}
+
+// 推計対象地点の緯度 lat, 経度 lon の周囲 n 地点のMSMの緯度・経度を RequiredMsmListN と同じ順で返します。
+func msmGridPointsN(lat float64, lon float64, n int) [][2]float64 {
+	if n != Neighborhood16 {
+		p := msmGridPoints(lat, lon)
+		return p[:]
+	}
+
+	lat_S := math.Floor(lat/msm_lat_unit) * msm_lat_unit // 南は切り下げ
+	lon_W := math.Floor(lon/msm_lon_unit) * msm_lon_unit // 西は切り下げ
+
+	points := make([][2]float64, 0, 16)
+	for j := -1; j <= 2; j++ {
+		for i := -1; i <= 2; i++ {
+			points = append(points, [2]float64{lat_S + float64(j)*msm_lat_unit, lon_W + float64(i)*msm_lon_unit})
+		}
+	}
+	return points
+}
+
+// 推計対象地点の緯度 lat, 経度 lon から空間補間の方法 method による周囲 n 地点の重みを RequiredMsmListN と同じ順で返します。
+// 双3次補間(bicubic)は16地点の場合のみ使用でき、重みが負となる地点があります。
+// 双線形補間(bilinear)と最近傍(nearest)は16地点の場合も内側の4地点のみを使用します。
+func InterpolationWeightsN(lat float64, lon float64, method InterpolationMethod, power float64, n int) ([]float64, error) {
+	if err := validateNeighborhood(n); err != nil {
+		return nil, err
+	}
+
+	if method == InterpolationBicubic {
+		if n != Neighborhood16 {
+			return nil, fmt.Errorf("interpolation method %q requires the 16-point neighborhood", method)
+		}
+		return bicubicWeights(lat, lon), nil
+	}
+
+	if n == Neighborhood4 || method == InterpolationBilinear || method == InterpolationNearest {
+		w, err := InterpolationWeights(lat, lon, method, power)
+		if err != nil {
+			return nil, err
+		}
+		if n == Neighborhood4 {
+			return w[:], nil
+		}
+		// 内側の4地点 (4×4 の2行目・3行目の2列目・3列目)
+		weights := make([]float64, 16)
+		weights[5], weights[6], weights[9], weights[10] = w[0], w[1], w[2], w[3]
+		return weights, nil
+	}
+
+	// 逆距離加重
+	if power == 0 {
+		power = 1
+	}
+	if !(power > 0) || math.IsInf(power, 0) {
+		return nil, fmt.Errorf("invalid idw power %v", power)
+	}
+	points := msmGridPointsN(lat, lon, n)
+	distances := make([]float64, len(points))
+	for i, p := range points {
+		d, err := vincentyInverse(lat, lon, p[0], p[1])
+		if err != nil {
+			return nil, fmt.Errorf("distance from (%f, %f) to MSM point (%f, %f): %w", lat, lon, p[0], p[1], err)
+		}
+		distances[i] = d
+	}
+	return idwWeights(distances, power), nil
+}
+
+// 推計対象地点の緯度（10進法）lat, 経度 lon から双3次補間(Catmull-Rom)による周囲16地点の重みを返す。
+func bicubicWeights(lat float64, lon float64) []float64 {
+	points := msmGridPoints(lat, lon)
+
+	// 内側の南西の地点からの位置 (0～1)
+	ty := math.Min(math.Max((lat-points[0][0])/msm_lat_unit, 0), 1)
+	tx := math.Min(math.Max((lon-points[0][1])/msm_lon_unit, 0), 1)
+
+	var wy, wx [4]float64
+	for k := 0; k < 4; k++ {
+		wy[k] = cubicKernel(ty - float64(k-1))
+		wx[k] = cubicKernel(tx - float64(k-1))
+	}
+
+	weights := make([]float64, 16)
+	for j := 0; j < 4; j++ {
+		for i := 0; i < 4; i++ {
+			weights[j*4+i] = wy[j] * wx[i]
+		}
+	}
+	return weights
+}
+
+// 3次畳み込み補間の重み関数 (Keys, a = -0.5)
+func cubicKernel(t float64) float64 {
+	const a = -0.5
+	t = math.Abs(t)
+	switch {
+	case t <= 1:
+		return (a+2)*t*t*t - (a+3)*t*t + 1
+	case t < 2:
+		return a*t*t*t - 5*a*t*t + 8*a*t - 4*a
+	}
+	return 0
+}
+
+// 海上の地点を除外した残りの地点の重みの合計が 1 ± seaWeightTolerance の範囲外の場合は、正の重みの地点のみを使用する
+const seaWeightTolerance = 0.25
+
+// 海上のMSM地点(標高 elevations が0m)の重みを0とし、残りの地点の重みの合計が1となるように重み weights を補正します。
+// 双3次補間の重みは負の値を含むため、残りの地点の重みの合計が1から大きく離れている場合は、
+// 正規化により値が過大・過小とならないよう負の重みも0とします。
+// 補正後の重みと除外した地点の添字を返します。すべて海上の地点の場合など補正できない場合は、weights をそのまま返します。
+func excludeSeaPoints(weights []float64, elevations []float64) ([]float64, []int) {
+	var excluded []int
+	total := 0.0
+	for i := range weights {
+		if elevations[i] == 0 {
+			if weights[i] != 0 {
+				excluded = append(excluded, i)
+			}
+			continue
+		}
+		total += weights[i]
+	}
+	if len(excluded) == 0 {
+		return weights, nil
+	}
+
+	positiveOnly := math.Abs(total-1) > seaWeightTolerance
+	if positiveOnly {
+		total = 0.0
+		for i := range weights {
+			if elevations[i] != 0 && weights[i] > 0 {
+				total += weights[i]
+			}
+		}
+	}
+	if total < 1e-6 {
+		return weights, nil
+	}
+
+	corrected := make([]float64, len(weights))
+	for i := range weights {
+		if elevations[i] == 0 || (positiveOnly && weights[i] < 0) {
+			continue
+		}
+		corrected[i] = weights[i] / total
+	}
+	return corrected, excluded
+}
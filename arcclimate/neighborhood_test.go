@@ -0,0 +1,143 @@
+package arcclimate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RequiredMsmListN(t *testing.T) {
+	lat, lon := 35.658, 139.741
+	assert.Equal(t, RequiredMsmList(lat, lon), RequiredMsmListN(lat, lon, Neighborhood4))
+
+	// 16地点の内側の4地点は RequiredMsmList と同じ
+	list := RequiredMsmListN(lat, lon, Neighborhood16)
+	assert.Len(t, list, 16)
+	inner := RequiredMsmList(lat, lon)
+	assert.Equal(t, inner, []string{list[5], list[6], list[9], list[10]})
+	assert.Equal(t, "240-314", list[0])
+	assert.Equal(t, "237-317", list[15])
+
+	ele, err := NewElevationMaster(lat, lon)
+	assert.NoError(t, err)
+	e4 := Elevations(lat, lon, ele)
+	assert.Equal(t, e4[:], ElevationsN(lat, lon, ele, Neighborhood4))
+	e16 := ElevationsN(lat, lon, ele, Neighborhood16)
+	assert.Equal(t, e4[:], []float64{e16[5], e16[6], e16[9], e16[10]})
+
+	// 16地点の場合は外周の地点も格子の範囲内である必要がある
+	assert.NoError(t, checkDomain(35.658, 139.741, Neighborhood16))
+	assert.ErrorIs(t, checkDomain(47.56, 140, Neighborhood16), ErrOutOfDomain)
+}
+
+func Test_InterpolationWeightsN(t *testing.T) {
+	points := [][2]float64{{35.658, 139.741}, {33.88, 130.87}, {43.06, 141.33}, {35.6749, 139.8124}}
+
+	// 緯度・経度の2次式の場
+	quadratic := func(lat, lon float64) float64 { return 1 + 2*lat - lon + 3*lat*lat + lat*lon - 2*lon*lon }
+
+	for _, p := range points {
+		lat, lon := p[0], p[1]
+		grid := msmGridPointsN(lat, lon, Neighborhood16)
+		field := func(weights []float64, f func(lat, lon float64) float64) float64 {
+			v := 0.0
+			for i, q := range grid {
+				v += weights[i] * f(q[0], q[1])
+			}
+			return v
+		}
+
+		for _, m := range []InterpolationMethod{InterpolationIDW, InterpolationBilinear, InterpolationNearest, InterpolationBicubic} {
+			w, err := InterpolationWeightsN(lat, lon, m, 2, Neighborhood16)
+			assert.NoError(t, err)
+			assert.Len(t, w, 16)
+			total := 0.0
+			for _, x := range w {
+				total += x
+			}
+			assert.InDelta(t, 1, total, 1e-12, m)
+		}
+
+		// 双3次補間は2次式の場を再現する
+		w, _ := InterpolationWeightsN(lat, lon, InterpolationBicubic, 0, Neighborhood16)
+		assert.InDelta(t, quadratic(lat, lon), field(w, quadratic), 1e-6)
+
+		// 双線形補間は内側の4地点のみ
+		w, _ = InterpolationWeightsN(lat, lon, InterpolationBilinear, 0, Neighborhood16)
+		w4, _ := InterpolationWeights(lat, lon, InterpolationBilinear, 0)
+		assert.Equal(t, w4[:], []float64{w[5], w[6], w[9], w[10]})
+
+		// 逆距離加重は近い内側の4地点ほど重みが大きい
+		w, _ = InterpolationWeightsN(lat, lon, InterpolationIDW, 2, Neighborhood16)
+		assert.Greater(t, w[5]+w[6]+w[9]+w[10], 0.5)
+	}
+
+	_, err := InterpolationWeightsN(35.658, 139.741, InterpolationBicubic, 0, Neighborhood4)
+	assert.Error(t, err)
+	_, err = InterpolationWeightsN(35.658, 139.741, InterpolationIDW, 1, 9)
+	assert.Error(t, err)
+
+	opts := NewOptions(35.658, 139.741)
+	opts.Interpolation = InterpolationBicubic
+	assert.Error(t, opts.Validate())
+	opts.Neighborhood = Neighborhood16
+	assert.NoError(t, opts.Validate())
+}
+
+func Test_excludeSeaPoints(t *testing.T) {
+	w, excluded := excludeSeaPoints([]float64{0.1, 0.2, 0.3, 0.4}, []float64{0, 10, 0, 20})
+	assert.Equal(t, []int{0, 2}, excluded)
+	assert.InDeltaSlice(t, []float64{0, 1.0 / 3, 0, 2.0 / 3}, w, 1e-12)
+
+	// 海上の地点が無い場合
+	w, excluded = excludeSeaPoints([]float64{0.1, 0.2, 0.3, 0.4}, []float64{5, 10, 15, 20})
+	assert.Empty(t, excluded)
+	assert.Equal(t, []float64{0.1, 0.2, 0.3, 0.4}, w)
+
+	// すべて海上の場合は除外しない
+	w, excluded = excludeSeaPoints([]float64{0.1, 0.2, 0.3, 0.4}, []float64{0, 0, 0, 0})
+	assert.Empty(t, excluded)
+	assert.Equal(t, []float64{0.1, 0.2, 0.3, 0.4}, w)
+}
+
+// 周囲16地点の双3次補間で、東側の2列が海上の場合
+func Test_excludeSeaPoints_Bicubic(t *testing.T) {
+	// 内側の4地点の中央 (各方向の重み -0.0625, 0.5625, 0.5625, -0.0625)
+	lat := msmGridPoints(35.658, 139.741)[0][0] + msm_lat_unit/2
+	lon := msmGridPoints(35.658, 139.741)[0][1] + msm_lon_unit/2
+	weights := bicubicWeights(lat, lon)
+
+	elevations := make([]float64, 16)
+	for j := 0; j < 4; j++ {
+		for i := 0; i < 4; i++ {
+			if i < 2 {
+				elevations[j*4+i] = 10
+			}
+		}
+	}
+
+	// 残りの地点の重みの合計は0.5のため、正の重みの地点(内側の西側の2地点と外側の南西・北西の地点)のみを使用する
+	w, excluded := excludeSeaPoints(weights, elevations)
+	assert.Len(t, excluded, 8)
+	want := make([]float64, 16)
+	want[0*4+0], want[3*4+0] = 0.00390625/0.640625, 0.00390625/0.640625
+	want[1*4+1], want[2*4+1] = 0.31640625/0.640625, 0.31640625/0.640625
+	assert.InDeltaSlice(t, want, w, 1e-12)
+
+	// 東端の1列のみ海上の場合は、合計が1に近いため負の重みを含めて正規化する
+	for j := 0; j < 4; j++ {
+		elevations[j*4+2] = 10
+	}
+	w, excluded = excludeSeaPoints(weights, elevations)
+	assert.Len(t, excluded, 4)
+	sum := 0.0
+	for i, v := range w {
+		sum += v
+		if i%4 == 3 {
+			assert.Equal(t, 0.0, v)
+		} else {
+			assert.InDelta(t, weights[i]/1.0625, v, 1e-12)
+		}
+	}
+	assert.InDelta(t, 1.0, sum, 1e-12)
+}
@@ -0,0 +1,409 @@
+package arcclimate
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strings"
+)
+
+//--------------------------------------
+// 計算条件
+//--------------------------------------
+
+// 計算モード
+type Mode string
+
+const (
+	ModeNormal Mode = "normal" // 指定期間の時系列データ
+	ModeEA     Mode = "EA"     // 標準年データ
+)
+
+// 標高の判定方法
+type ElevationMode string
+
+const (
+	ElevationMesh ElevationMode = "mesh" // 3次メッシュ（1㎞メッシュ）の平均標高
+	ElevationAPI  ElevationMode = "api"  // 国土地理院のAPI
+
+	// 指定した標高 (Options.Elevation)。計算条件の記録(RunMetadata)にのみ使用します。
+	ElevationFixed ElevationMode = "fixed"
+
+	// 国土地理院の標高タイル (DEMTileElevationProvider)。計算条件の記録(RunMetadata)にのみ使用します。
+	ElevationTile ElevationMode = "tile"
+)
+
+// 直散分離の方法
+type SeparationMethod string
+
+const (
+	SeparationNagata   SeparationMethod = "Nagata"
+	SeparationWatanabe SeparationMethod = "Watanabe"
+	SeparationErbs     SeparationMethod = "Erbs"
+	SeparationUdagawa  SeparationMethod = "Udagawa"
+	SeparationPerez    SeparationMethod = "Perez"
+)
+
+// 文字列 s を計算モードに変換します。
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(s); m {
+	case ModeNormal, ModeEA:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown mode %q (want normal or EA)", s)
+}
+
+// 文字列 s を標高の判定方法に変換します。
+func ParseElevationMode(s string) (ElevationMode, error) {
+	switch m := ElevationMode(s); m {
+	case ElevationMesh, ElevationAPI:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown elevation mode %q (want mesh or api)", s)
+}
+
+// 文字列 s を直散分離の方法に変換します。
+func ParseSeparationMethod(s string) (SeparationMethod, error) {
+	switch m := SeparationMethod(s); m {
+	case SeparationNagata, SeparationWatanabe, SeparationErbs, SeparationUdagawa, SeparationPerez:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown separation method %q (want Nagata, Watanabe, Erbs, Udagawa or Perez)", s)
+}
+
+// 補間計算の条件
+type Options struct {
+	Lat float64 // 推計対象地点の緯度（10進法）
+	Lon float64 // 推計対象地点の経度（10進法）
+
+	// 出力する気象データの開始年と終了年。標準年の計算をする場合は検討期間として解釈します。
+	StartYear int
+	EndYear   int
+
+	Mode             Mode
+	ElevationMode    ElevationMode
+	SeparationMethod SeparationMethod
+
+	// 推計対象地点の標高 [m]。指定した場合は ElevationMode によらずこの標高を使用します。
+	Elevation *float64
+
+	// 推計対象地点の標高の取得元。指定した場合は ElevationMode の代わりに使用します (Elevation を指定した場合を除く)。
+	// 取得できなかった場合は3次メッシュの平均標高を使用します。
+	ElevationProvider ElevationProvider
+
+	// 周囲4地点のMSMデータの空間補間の方法 (空の場合は逆距離加重)
+	Interpolation InterpolationMethod
+
+	// 逆距離加重(idw)の距離のべき数 (0 の場合は 1)
+	IDWPower float64
+
+	// 空間補間に使用する周囲のMSM地点数 Neighborhood4 または Neighborhood16 (0 の場合は4地点)
+	Neighborhood int
+
+	// 海上のMSM地点(標高0m)を空間補間から除外する場合は true とします。
+	ExcludeSea bool
+
+	// 標高補正に使用する気温減率 (nil の場合は DefaultLapseRate、NoLapseRate の場合は標高補正しない)
+	LapseRate LapseRate
+
+	// MSMの地上10mの風速を換算する高さ・地表面粗度 (nil の場合は換算しない)
+	Wind *WindConversion
+
+	// 風向風速の計算条件 (nil の場合は出力形式ごとの既定値 CSVWindDirection, EPWWindDirection など)
+	WindDirection *WindDirection
+
+	// 静穏とみなす風速の上限 [m/s]。HAS形式ではこの値以下の風速の風向を 0 (無風) とします。
+	WindCalm float64
+
+	// 湿球温度、絶対湿度、比エンタルピー、密度、比容積を計算結果に追加する場合は true とします。
+	Psychrometrics bool
+
+	// 周囲のMSM地点の標高補正後の値とばらつきを計算結果(MsmTarget.Spread)に保持する場合は true とします。
+	// 標準年の計算(ModeEA)では使用できません。
+	Spread bool
+
+	// 推計対象地点の標高と周囲のMSMの標高(重み付き平均)の差がこの値 [m] を超える場合に警告します (0以下の場合は確認しない)。
+	ElevationWarning float64
+
+	// 標準年データの検討に日射量の推計値を使用する場合は true とします。（使用しない場合2018年以降のデータのみで作成）
+	UseEst bool
+
+	// MSMファイルの取得元。nil の場合は既定のダウンロード元 DefaultMsmURL を使用します。
+	Source MsmSource
+
+	// 結合する期間別アーカイブ(古い順)。指定した場合は Source の代わりに使用し、
+	// 各アーカイブのMSMファイルを参照時刻順に結合します。重複する参照時刻は Precedence に従います。
+	// キャッシュはアーカイブごとに MsmFileDir のサブディレクトリ(アーカイブ名)に保存します。
+	Archives   []MsmArchive
+	Precedence MsmPrecedence
+
+	UseCache   bool   // MsmFileDir に保存済みのMSMファイルがあれば使用する
+	SaveCache  bool   // 取得したMSMファイルを MsmFileDir に保存する
+	MsmFileDir string // MSMファイルの格納ディレクトリ
+
+	// 解析済みのMSMデータをバイナリキャッシュとして MsmFileDir に保存し、次回以降に使用する
+	BinaryCache bool
+
+	// 読み込んだMSMデータを共有するプロセス内のキャッシュ (nil の場合は使用しない)
+	MemoryCache *MsmMemoryCache
+
+	// 複数地点の計算(InterpolateMany)で同時に読み込むMSMファイル数・同時に計算する地点数(1未満の場合は1)
+	Parallel int
+
+	// ネットワークを一切使用しない場合は true とします。
+	// MSMファイルはローカルディレクトリ(取得元が DirMsmSource の場合はそのディレクトリ、それ以外はキャッシュの格納ディレクトリ)
+	// のみから読み込み、標高は ElevationMode によらず3次メッシュの平均標高を使用します。
+	Offline bool
+}
+
+// 計算条件に応じたアーカイブの一覧を返します。
+func (opts *Options) archives() []MsmArchive {
+	if len(opts.Archives) > 0 {
+		return opts.Archives
+	}
+	src := opts.Source
+	if src == nil {
+		src = NewHTTPMsmSource(DefaultMsmURL)
+	}
+	return []MsmArchive{{Source: src}}
+}
+
+// アーカイブ a のMSMファイルを保存・読み込みするローカルディレクトリを返します。
+func (opts *Options) archiveDir(a MsmArchive) string {
+	if dir, ok := a.Source.(*DirMsmSource); ok {
+		return dir.Dir
+	}
+	if opts.MsmFileDir == "" {
+		return ""
+	}
+	return filepath.Join(opts.MsmFileDir, a.Name)
+}
+
+// 計算条件に応じたMSMファイルの取得元を、結合するアーカイブの順に返します。
+// オフラインの場合は、必要なMSMファイル msm_list がローカルディレクトリに存在するかも確認します。
+func (opts *Options) msmSources(msm_list []string) ([]MsmSource, error) {
+	if err := opts.checkOffline(msm_list); err != nil {
+		return nil, err
+	}
+
+	srcs := opts.localMsmSources()
+	if opts.MemoryCache != nil {
+		// 読み込んだMSMデータをプロセス内で共有する
+		for i, a := range opts.archives() {
+			srcs[i] = &memoryMsmSource{MsmSource: srcs[i], cache: opts.MemoryCache, prefix: a.Name}
+		}
+	}
+	return srcs, nil
+}
+
+// 計算条件に応じたMSMファイルの取得元(キャッシュの格納ディレクトリを含む)を、結合するアーカイブの順に返します。
+func (opts *Options) localMsmSources() []MsmSource {
+	archives := opts.archives()
+	srcs := make([]MsmSource, len(archives))
+
+	if opts.Offline {
+		// ネットワークを使用せず、ローカルディレクトリのMSMファイルのみを使用する
+		for i, a := range archives {
+			if _, isDir := a.Source.(*DirMsmSource); isDir {
+				// 利用者のディレクトリは読み込みのみとし、マニフェストの作成や破損時の削除を行わない
+				srcs[i] = a.Source
+				continue
+			}
+			srcs[i] = &CachedMsmSource{Source: offlineMsmSource{}, Dir: opts.archiveDir(a), UseCache: true, Binary: opts.BinaryCache}
+		}
+		return srcs
+	}
+
+	for i, a := range archives {
+		srcs[i] = a.Source
+		if _, isDir := a.Source.(*DirMsmSource); isDir {
+			// ローカルディレクトリはキャッシュしない
+			continue
+		}
+		if opts.UseCache || opts.SaveCache {
+			srcs[i] = &CachedMsmSource{
+				Source:    a.Source,
+				Dir:       opts.archiveDir(a),
+				UseCache:  opts.UseCache,
+				SaveCache: opts.SaveCache,
+				Binary:    opts.BinaryCache,
+			}
+		}
+	}
+	return srcs
+}
+
+// オフラインの場合に、MSMファイル msm_list がいずれかのアーカイブのローカルディレクトリに存在するかを確認します。
+func (opts *Options) checkOffline(msm_list []string) error {
+	if !opts.Offline {
+		return nil
+	}
+	archives := opts.archives()
+	dirs := make([]string, len(archives))
+	for i, a := range archives {
+		dirs[i] = opts.archiveDir(a)
+	}
+	return checkMsmFiles(dirs, msm_list)
+}
+
+// 計算に必要なMSMデータの期間
+func (opts *Options) window() MsmWindow {
+	return YearWindow(opts.StartYear, opts.EndYear)
+}
+
+// 緯度 lat, 経度 lon の地点について、コマンドラインの既定値と同じ計算条件を返します。
+func NewOptions(lat float64, lon float64) Options {
+	return Options{
+		Lat:              lat,
+		Lon:              lon,
+		StartYear:        2011,
+		EndYear:          2020,
+		Mode:             ModeNormal,
+		ElevationMode:    ElevationAPI,
+		SeparationMethod: SeparationPerez,
+		UseEst:           true,
+		UseCache:         true,
+		SaveCache:        true,
+		MsmFileDir:       ".msm_cache",
+		Precedence:       PrecedenceLast,
+		Parallel:         4,
+		ElevationWarning: 300,
+		Interpolation:    InterpolationIDW,
+		IDWPower:         1,
+	}
+}
+
+// 空間補間の方法と逆距離加重のべき数 (未指定の場合は既定値)
+func (opts *Options) interpolation() (InterpolationMethod, float64) {
+	method, power := opts.Interpolation, opts.IDWPower
+	if method == "" {
+		method = InterpolationIDW
+	}
+	if power == 0 {
+		power = 1
+	}
+	return method, power
+}
+
+// 空間補間に使用する周囲のMSM地点数
+func (opts *Options) neighborhood() int {
+	if opts.Neighborhood == 0 {
+		return Neighborhood4
+	}
+	return opts.Neighborhood
+}
+
+// 標高補正に使用する気温減率を返します。
+func (opts *Options) lapseRate() LapseRate {
+	if opts.LapseRate == nil {
+		return DefaultLapseRate
+	}
+	return opts.LapseRate
+}
+
+// 計算条件の妥当性を確認します。
+func (opts *Options) Validate() error {
+	if err := checkDomain(opts.Lat, opts.Lon, opts.neighborhood()); err != nil {
+		return err
+	}
+	return opts.validateCommon()
+}
+
+// 緯度 lat, 経度 lon の妥当性を確認します。
+func validateLatLon(lat float64, lon float64) error {
+	if math.IsNaN(lat) || lat < -90 || lat > 90 {
+		return fmt.Errorf("invalid latitude %v", lat)
+	}
+	if math.IsNaN(lon) || lon < -180 || lon > 180 {
+		return fmt.Errorf("invalid longitude %v", lon)
+	}
+	return nil
+}
+
+// 地点によらない計算条件の妥当性を確認します。
+func (opts *Options) validateCommon() error {
+	if opts.StartYear > opts.EndYear {
+		return fmt.Errorf("start year %d is after end year %d", opts.StartYear, opts.EndYear)
+	}
+	if _, err := ParseMode(string(opts.Mode)); err != nil {
+		return err
+	}
+	if _, err := ParseElevationMode(string(opts.ElevationMode)); err != nil {
+		return err
+	}
+	if _, err := ParseSeparationMethod(string(opts.SeparationMethod)); err != nil {
+		return err
+	}
+	if opts.Interpolation != "" {
+		if _, err := ParseInterpolationMethod(string(opts.Interpolation)); err != nil {
+			return err
+		}
+	}
+	if err := validateNeighborhood(opts.neighborhood()); err != nil {
+		return err
+	}
+	if opts.Interpolation == InterpolationBicubic && opts.neighborhood() != Neighborhood16 {
+		return fmt.Errorf("interpolation method %q requires the 16-point neighborhood", opts.Interpolation)
+	}
+	if opts.IDWPower < 0 || math.IsNaN(opts.IDWPower) || math.IsInf(opts.IDWPower, 0) {
+		return fmt.Errorf("invalid idw power %v", opts.IDWPower)
+	}
+	if opts.Wind != nil {
+		if err := opts.Wind.Validate(); err != nil {
+			return err
+		}
+	}
+	if opts.WindDirection != nil {
+		if err := opts.WindDirection.Validate(); err != nil {
+			return err
+		}
+	}
+	if opts.WindCalm < 0 || math.IsNaN(opts.WindCalm) || math.IsInf(opts.WindCalm, 0) {
+		return fmt.Errorf("invalid calm wind speed %v", opts.WindCalm)
+	}
+	if opts.Spread && opts.Mode == ModeEA {
+		return fmt.Errorf("spread is not available in %s mode", ModeEA)
+	}
+	if math.IsNaN(opts.ElevationWarning) {
+		return fmt.Errorf("invalid elevation warning threshold %v", opts.ElevationWarning)
+	}
+	if opts.Elevation != nil && (math.IsNaN(*opts.Elevation) || math.IsInf(*opts.Elevation, 0)) {
+		return fmt.Errorf("invalid elevation %v", *opts.Elevation)
+	}
+	if (opts.UseCache || opts.SaveCache) && opts.MsmFileDir == "" {
+		return fmt.Errorf("msm file directory is required when the cache is enabled")
+	}
+	if len(opts.Archives) > 0 {
+		if opts.Source != nil {
+			return fmt.Errorf("source and archives cannot be used together")
+		}
+		if _, err := ParseMsmPrecedence(string(opts.Precedence)); err != nil {
+			return err
+		}
+		names := make(map[string]bool)
+		for _, a := range opts.Archives {
+			if a.Source == nil {
+				return fmt.Errorf("msm archive %q has no source", a.Name)
+			}
+			if a.Name == "" || a.Name == "." || a.Name == ".." || strings.ContainsAny(a.Name, `/\`) {
+				return fmt.Errorf("invalid msm archive name %q", a.Name)
+			}
+			if names[a.Name] {
+				return fmt.Errorf("duplicate msm archive name %q", a.Name)
+			}
+			names[a.Name] = true
+		}
+	}
+	if opts.Offline {
+		for _, a := range opts.archives() {
+			if opts.archiveDir(a) == "" {
+				return fmt.Errorf("msm file directory is required in offline mode")
+			}
+		}
+	}
+	// EA方式かつ日射量の推計値を使用しない場合は2018年以降のデータが必要
+	if opts.Mode == ModeEA && !opts.UseEst && opts.EndYear < 2018 {
+		return fmt.Errorf("end year %d must be 2018 or later when estimated solar radiation is not used", opts.EndYear)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package arcclimate
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseSeparationMethod(t *testing.T) {
+	m, err := ParseSeparationMethod("Erbs")
+	assert.NoError(t, err)
+	assert.Equal(t, SeparationErbs, m)
+
+	// 大文字・小文字は区別する
+	_, err = ParseSeparationMethod("erbs")
+	assert.Error(t, err)
+}
+
+func Test_Options_Validate(t *testing.T) {
+	opts := NewOptions(33.8834976, 130.8751773)
+	assert.NoError(t, opts.Validate())
+
+	// 開始年が終了年より後
+	opts.StartYear = 2020
+	opts.EndYear = 2019
+	assert.Error(t, opts.Validate())
+
+	// 計算モードの誤り
+	opts = NewOptions(33.8834976, 130.8751773)
+	opts.Mode = "ea"
+	assert.Error(t, opts.Validate())
+
+	// 日射量の推計値を使用しない標準年の計算には2018年以降のデータが必要
+	opts = NewOptions(33.8834976, 130.8751773)
+	opts.Mode = ModeEA
+	opts.UseEst = false
+	opts.EndYear = 2017
+	assert.Error(t, opts.Validate())
+}
+
+func Test_InterpolateWithOptions_InvalidOptions(t *testing.T) {
+	opts := NewOptions(91.0, 130.8751773)
+	msm, err := InterpolateWithOptions(context.Background(), opts)
+	assert.Nil(t, msm)
+	assert.Error(t, err)
+}
+
+func Test_InterpolateWithOptions_Offline(t *testing.T) {
+	lat, lon := 35.658, 139.741
+	dir := t.TempDir()
+
+	opts := NewOptions(lat, lon)
+	opts.StartYear, opts.EndYear = 2011, 2011
+	opts.MsmFileDir = dir
+	opts.Offline = true
+
+	// 必要なMSMファイルがない場合はすべて列挙する
+	_, err := InterpolateWithOptions(context.Background(), opts)
+	var missing *MissingMsmFilesError
+	assert.True(t, errors.As(err, &missing))
+	assert.Equal(t, dir, missing.Dir)
+	assert.Equal(t, 4, len(missing.Names))
+	assert.ErrorIs(t, err, ErrMsmNotFound)
+
+	data := makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 365*24, 0.0)
+	for _, name := range RequiredMsmList(lat, lon) {
+		path := filepath.Join(dir, msmFileName(name))
+		assert.NoError(t, os.WriteFile(path, data, 0644))
+	}
+
+	// 標高はAPIを使用せず3次メッシュの平均標高とする
+	res, err := InterpolateWithOptions(context.Background(), opts)
+	assert.NoError(t, err)
+	assert.True(t, res.Metadata.Offline)
+	assert.Equal(t, ElevationMesh, res.Metadata.ElevationMode)
+	assert.Equal(t, RequiredMsmList(lat, lon), res.Metadata.MsmFiles)
+}
+
+// オフラインでは照合に失敗したキャッシュを削除せず、照合のエラーを返す
+func Test_InterpolateWithOptions_OfflineCorruptCache(t *testing.T) {
+	lat, lon := 35.658, 139.741
+	dir := t.TempDir()
+	data := makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 365*24, 0.0)
+	names := RequiredMsmList(lat, lon)
+	for _, name := range names {
+		path := filepath.Join(dir, msmFileName(name))
+		manifest, err := inspectMsmGz(data)
+		assert.NoError(t, err)
+		assert.NoError(t, writeCachedMsm(path, data, manifest))
+	}
+
+	// マニフェストと一致しない内容
+	broken := filepath.Join(dir, msmFileName(names[0]))
+	assert.NoError(t, os.WriteFile(broken, data[:len(data)-1], 0644))
+
+	opts := NewOptions(lat, lon)
+	opts.StartYear, opts.EndYear = 2011, 2011
+	opts.MsmFileDir = dir
+	opts.Offline = true
+
+	before := snapshotDir(t, dir)
+	for i := 0; i < 2; i++ {
+		_, err := InterpolateWithOptions(context.Background(), opts)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrMsmNotFound)
+		assert.Contains(t, err.Error(), "verify "+broken)
+		assert.Equal(t, before, snapshotDir(t, dir))
+	}
+}
+
+// ディレクトリ dir のファイル名と内容
+func snapshotDir(t *testing.T, dir string) map[string]string {
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	files := make(map[string]string, len(entries))
+	for _, e := range entries {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		assert.NoError(t, err)
+		files[e.Name()] = string(b)
+	}
+	return files
+}
+
+// オフラインでも取得元のディレクトリ(--msm_source)には書き込み・削除しない
+func Test_InterpolateWithOptions_OfflineDirSourceReadOnly(t *testing.T) {
+	lat, lon := 35.658, 139.741
+	dir := t.TempDir()
+	data := makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 365*24, 0.0)
+	names := RequiredMsmList(lat, lon)
+	for _, name := range names {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, msmFileName(name)), data, 0644))
+	}
+
+	opts := NewOptions(lat, lon)
+	opts.StartYear, opts.EndYear = 2011, 2011
+	opts.Source = NewDirMsmSource(dir)
+	opts.MsmFileDir = t.TempDir()
+	opts.Offline = true
+	opts.BinaryCache = true
+
+	before := snapshotDir(t, dir)
+	_, err := InterpolateWithOptions(context.Background(), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, before, snapshotDir(t, dir))
+
+	// 壊れたファイルも削除しない
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, msmFileName(names[0])), data[:len(data)/2], 0644))
+	before = snapshotDir(t, dir)
+	_, err = InterpolateWithOptions(context.Background(), opts)
+	assert.Error(t, err)
+	assert.Equal(t, before, snapshotDir(t, dir))
+}
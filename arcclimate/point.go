@@ -0,0 +1,69 @@
+package arcclimate
+
+import (
+	"context"
+)
+
+// 推計対象地点の補間に使用する周囲のMSM地点と標高
+type PointInfo struct {
+	Lat float64 `json:"lat"` // 推計対象地点の緯度（10進法）
+	Lon float64 `json:"lon"` // 推計対象地点の経度（10進法）
+
+	// 空間補間の方法と逆距離加重のべき数 (idw の場合のみ)、周囲のMSM地点数
+	Interpolation InterpolationMethod `json:"interpolation"`
+	IDWPower      float64             `json:"idw_power,omitempty"`
+	Neighborhood  int                 `json:"neighborhood"`
+
+	MsmFiles          []string  `json:"msm_files"`                     // 周囲のMSM地点のメッシュ地点番号 (RequiredMsmListN と同じ順)
+	MsmWeights        []float64 `json:"msm_weights"`                   // 各MSM地点の按分の重み(MsmFiles と同じ順)
+	MsmElevations     []float64 `json:"msm_elevations"`                // 各MSM地点の平均標高 [m](MsmFiles と同じ順)
+	ExcludedSeaPoints []string  `json:"excluded_sea_points,omitempty"` // 重みを0とした海上のMSM地点
+
+	// 推計対象地点の標高 [m] と実際に使用した標高の判定方法
+	Elevation     float64       `json:"elevation"`
+	ElevationMode ElevationMode `json:"elevation_mode"`
+}
+
+// 緯度 lat, 経度 lon の地点の補間に使用する周囲4地点と、標高の判定方法 modeEle による標高を返します。
+// 計算条件は NewOptions の既定値(逆距離加重、べき数1)とします。MSMファイルは読み込みません。
+func NewPointInfo(ctx context.Context, lat float64, lon float64, modeEle ElevationMode) (*PointInfo, error) {
+	opts := NewOptions(lat, lon)
+	opts.ElevationMode = modeEle
+	return NewPointInfoWithOptions(ctx, opts)
+}
+
+// 計算条件 opts の推計対象地点の補間に使用する周囲のMSM地点と重み、標高を返します。
+// 空間補間の方法・周囲の地点数・海上の地点の除外・標高の取得元は InterpolateWithOptions と同じです。
+// MSMファイルは読み込みません。
+func NewPointInfoWithOptions(ctx context.Context, opts Options) (*PointInfo, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	lat, lon := opts.Lat, opts.Lon
+
+	ele, err := NewElevationMaster(lat, lon)
+	if err != nil {
+		return nil, err
+	}
+	p, err := opts.neighborPoints(ctx, lat, lon, ele)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &PointInfo{
+		Lat:               lat,
+		Lon:               lon,
+		Interpolation:     p.method,
+		Neighborhood:      p.n,
+		MsmFiles:          p.names,
+		MsmWeights:        p.weights,
+		MsmElevations:     p.elevations,
+		ExcludedSeaPoints: p.excluded,
+		Elevation:         p.elevation,
+		ElevationMode:     p.modeEle,
+	}
+	if p.method == InterpolationIDW {
+		info.IDWPower = p.power
+	}
+	return info, nil
+}
@@ -0,0 +1,168 @@
+// 湿り空気の状態値 (空気線図) の計算
+//
+// 気温 TMP [℃]、気圧 PRES [Pa]、重量絶対湿度 MR [g/kg(DA)] (MSMの単位) から、相対湿度、水蒸気分圧、露点温度、
+// 湿球温度、比エンタルピー、湿り空気の密度、比容積を求めます。
+// 飽和水蒸気圧は Wexler-Hyland の式 (ASHRAE Handbook Fundamentals) を使用し、湿球温度の計算では
+// 0℃未満で氷面に対する飽和水蒸気圧を使用します。
+package psychro
+
+import "math"
+
+// 0℃の絶対温度 [K]
+const T0 = 273.15
+
+// 水蒸気と乾き空気の分子量の比
+const epsilon = 0.621945
+
+// 絶対温度 T [K] から 水面に対する飽和水蒸気圧 [hPa] を求める。
+func SaturationPressure(T float64) float64 {
+	return math.Exp(-5800.2206/T+
+		1.3914993-0.048640239*T+
+		0.41764768*0.0001*T*T-
+		0.14452093*0.0000001*T*T*T+
+		6.5459673*math.Log(T)) / 100
+}
+
+// 絶対温度 T [K] から 氷面に対する飽和水蒸気圧 [hPa] を求める。(-100～0℃)
+func SaturationPressureIce(T float64) float64 {
+	return math.Exp(-5674.5359/T+
+		6.3925247-0.009677843*T+
+		0.00000062215701*T*T+
+		0.0000000020747825*T*T*T-
+		0.0000000000009484024*T*T*T*T+
+		4.1635019*math.Log(T)) / 100
+}
+
+// 気温 TMP [℃] の飽和水蒸気圧 [hPa] を求める。0℃未満では氷面に対する値とする。
+func SaturationPressureOver(TMP float64) float64 {
+	if TMP < 0 {
+		return SaturationPressureIce(TMP + T0)
+	}
+	return SaturationPressure(TMP + T0)
+}
+
+// 気圧 PRES [Pa] と 気温 TMP [℃] から 飽和時の重量絶対湿度 [g/kg(DA)] を求める。
+// 飽和水蒸気量 [g/m3] と乾き空気の密度の比による近似式です。
+func SaturationMixingRatio(PRES float64, TMP float64) float64 {
+	// 絶対温度 [K]
+	T := TMP + T0
+
+	// 飽和水蒸気圧 [hPa]
+	eSAT := SaturationPressure(T)
+
+	// 飽和水蒸気量 [g/m3]
+	aT := (217 * eSAT) / T
+
+	// 重量絶対湿度 [g/kg(DA)]
+	return aT / ((PRES / 100) / (2.87 * T))
+}
+
+// 重量絶対湿度 MR [g/kg(DA)], 気温 TMP [℃], 気圧 PRES [Pa] から相対湿度 RH [%] と水蒸気分圧 Pw [hPa] を求める
+func RelativeHumidity(MR float64, TMP float64, PRES float64) (RH float64, Pw float64) {
+	P := PRES / 100 // hpa
+	T := TMP + T0   // 絶対温度
+	VH := MR * (P / (T * 2.87))
+
+	eSAT := SaturationPressure(T) // hPa
+	aT := (217 * eSAT) / T
+	RH = VH / aT * 100
+	Pw = RH / 100 * eSAT // hPa
+
+	return RH, Pw
+}
+
+// 水蒸気分圧 Pw [hPa] から露点温度 [℃] を求める。
+// 0.039 <= Pw(hpa) <= 123.50（-50～50℃）の範囲外の場合は NaN を返します。
+// パソコンによる空気調和計算法 著:宇田川光弘,オーム社, 1986.12 より
+func DewPoint(Pw float64) float64 {
+	if 6.112 <= Pw && Pw <= 123.50 {
+		return DewPoint50(Pw)
+	} else if 0.039 <= Pw && Pw <= 6.112 {
+		return DewPoint0(Pw)
+	}
+	return math.NaN()
+}
+
+// 水蒸気分圧 Pw [hPa] から気温（露点温度）DT [℃]を求める。ただし、0.039 <= Pw(hpa) < 6.112（-50～0℃の時）
+// 0℃未満は氷面に対する露点温度(霜点温度)です。
+func DewPoint0(Pw float64) float64 {
+	Y := math.Log(Pw * 100) // Pa
+	Y2 := Y * Y
+	Y3 := Y2 * Y
+	return -60.662 + 7.4624*Y + 0.20594*Y2 + 0.016321*Y3
+}
+
+// 水蒸気分圧 PW [hPa] から気温（露点温度） DT [℃]を求める近似式。ただし、6.112 <= Pw(hpa) <= 123.50（0～50℃の時）
+func DewPoint50(Pw float64) float64 {
+	Y := math.Log(Pw * 100) // Pa
+	Y2 := Y * Y
+	Y3 := Y2 * Y
+	return -77.199 + 13.198*Y - 0.63772*Y2 + 0.071098*Y3
+}
+
+// 重量絶対湿度 MR [g/kg(DA)] から絶対湿度(湿度比) [kg/kg(DA)] を求める。
+func HumidityRatio(MR float64) float64 {
+	return MR / 1000
+}
+
+// 気温 TMP [℃] と気圧 PRES [Pa] の飽和時の絶対湿度 [kg/kg(DA)] を求める。
+// 0℃未満では氷面に対する飽和水蒸気圧を使用します。
+func SaturationHumidityRatio(TMP float64, PRES float64) float64 {
+	pws := SaturationPressureOver(TMP) * 100 // Pa
+	return epsilon * pws / (PRES - pws)
+}
+
+// 気温 TMP [℃] と絶対湿度 W [kg/kg(DA)] から比エンタルピー [kJ/kg(DA)] を求める。
+func Enthalpy(TMP float64, W float64) float64 {
+	return 1.006*TMP + W*(2501+1.86*TMP)
+}
+
+// 気温 TMP [℃]、絶対湿度 W [kg/kg(DA)]、気圧 PRES [Pa] から比容積 [m3/kg(DA)] を求める。
+func SpecificVolume(TMP float64, W float64, PRES float64) float64 {
+	return 0.287042 * (TMP + T0) * (1 + 1.607858*W) / (PRES / 1000)
+}
+
+// 気温 TMP [℃]、絶対湿度 W [kg/kg(DA)]、気圧 PRES [Pa] から湿り空気の密度 [kg/m3] を求める。
+func Density(TMP float64, W float64, PRES float64) float64 {
+	return (1 + W) / SpecificVolume(TMP, W, PRES)
+}
+
+// 湿球温度 TWB [℃] の湿り空気の絶対湿度 [kg/kg(DA)]
+// TWB が0℃未満の場合は湿球が氷結しているものとします。
+func humidityRatioFromWetBulb(TMP float64, TWB float64, PRES float64) float64 {
+	Ws := SaturationHumidityRatio(TWB, PRES)
+	if TWB < 0 {
+		return ((2830-0.24*TWB)*Ws - 1.006*(TMP-TWB)) / (2830 + 1.86*TMP - 2.1*TWB)
+	}
+	return ((2501-2.326*TWB)*Ws - 1.006*(TMP-TWB)) / (2501 + 1.86*TMP - 4.186*TWB)
+}
+
+// 湿球温度の計算の収束判定 [℃]
+const wetBulbTolerance = 1e-6
+
+// 気温 TMP [℃]、絶対湿度 W [kg/kg(DA)]、気圧 PRES [Pa] から熱力学的湿球温度 [℃] を求める。
+// 湿球温度の絶対湿度が W となる温度を二分法で求めます。計算できない場合は NaN を返します。
+func WetBulb(TMP float64, W float64, PRES float64) float64 {
+	if math.IsNaN(TMP) || math.IsNaN(W) || math.IsNaN(PRES) || W < 0 {
+		return math.NaN()
+	}
+
+	// 飽和している場合は気温
+	if W >= SaturationHumidityRatio(TMP, PRES) {
+		return TMP
+	}
+
+	lo, hi := -100.0, TMP
+	if humidityRatioFromWetBulb(TMP, lo, PRES) > W {
+		return math.NaN()
+	}
+	for i := 0; i < 100 && hi-lo > wetBulbTolerance; i++ {
+		mid := (lo + hi) / 2
+		if humidityRatioFromWetBulb(TMP, mid, PRES) > W {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return (lo + hi) / 2
+}
@@ -0,0 +1,96 @@
+package psychro
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// ASHRAE Handbook Fundamentals (SI) Chapter 1 Table 3 の飽和水蒸気圧 [kPa]
+func Test_SaturationPressure(t *testing.T) {
+	for _, c := range []struct {
+		TMP  float64
+		want float64 // kPa
+	}{{0.01, 0.6117}, {20, 2.3392}, {40, 7.3851}} {
+		assert.InEpsilon(t, c.want, SaturationPressure(c.TMP+T0)/10, 1e-3, c.TMP)
+	}
+	for _, c := range []struct {
+		TMP  float64
+		want float64 // kPa
+	}{{-20, 0.10326}, {-10, 0.25990}, {0, 0.61115}} {
+		assert.InEpsilon(t, c.want, SaturationPressureIce(c.TMP+T0)/10, 1e-3, c.TMP)
+	}
+
+	// 0℃未満は氷面、0℃以上は水面
+	assert.Equal(t, SaturationPressureIce(T0-5), SaturationPressureOver(-5))
+	assert.Equal(t, SaturationPressure(T0+5), SaturationPressureOver(5))
+}
+
+// ASHRAE Handbook Fundamentals (SI) Chapter 1 Table 2 の20℃、101.325kPaの値
+func Test_MoistAirProperties_20C(t *testing.T) {
+	Ws := SaturationHumidityRatio(20, 101325)
+	assert.InDelta(t, 0.014758, Ws, 1e-4)
+
+	// 乾き空気
+	assert.InDelta(t, 20.121, Enthalpy(20, 0), 0.01)
+	assert.InDelta(t, 0.8301, SpecificVolume(20, 0, 101325), 1e-3)
+
+	// 飽和空気
+	assert.InDelta(t, 57.555, Enthalpy(20, Ws), 0.2)
+	assert.InDelta(t, 0.8497, SpecificVolume(20, Ws, 101325), 1e-3)
+	assert.InDelta(t, (1+Ws)/SpecificVolume(20, Ws, 101325), Density(20, Ws, 101325), 1e-12)
+}
+
+// ASHRAE Handbook Fundamentals (SI) Chapter 1 Example: 乾球温度40℃、湿球温度20℃、101.325kPa
+func Test_WetBulb_Example(t *testing.T) {
+	W := humidityRatioFromWetBulb(40, 20, 101325)
+	assert.InDelta(t, 0.00646, W, 1e-4)
+	assert.InDelta(t, 20.0, WetBulb(40, W, 101325), 1e-5)
+	assert.InDelta(t, 56.88, Enthalpy(40, W), 0.2)
+	assert.InDelta(t, 0.8966, SpecificVolume(40, W, 101325), 1e-3)
+}
+
+// 湿球温度の性質 (氷点下を含む)
+func Test_WetBulb_Properties(t *testing.T) {
+	const PRES = 101325.0
+	for TMP := -30.0; TMP <= 45; TMP += 5 {
+		Ws := SaturationHumidityRatio(TMP, PRES)
+
+		// 飽和空気の湿球温度は気温
+		assert.Equal(t, TMP, WetBulb(TMP, Ws, PRES), TMP)
+
+		prev := math.Inf(-1)
+		for RH := 10.0; RH <= 100; RH += 10 {
+			W := Ws * RH / 100
+			TWB := WetBulb(TMP, W, PRES)
+			if math.IsNaN(TWB) {
+				t.Fatalf("wet-bulb temperature at %v℃, W=%v is NaN", TMP, W)
+			}
+
+			// 湿球温度の絶対湿度は W
+			assert.InDelta(t, W, humidityRatioFromWetBulb(TMP, TWB, PRES), 1e-8, "%v℃ %v%%", TMP, RH)
+
+			// 露点温度 <= 湿球温度 <= 乾球温度
+			assert.LessOrEqual(t, TWB, TMP+wetBulbTolerance)
+			Pw := W * PRES / (epsilon + W) / 100 // hPa
+			if DT := DewPoint(Pw); !math.IsNaN(DT) {
+				assert.LessOrEqual(t, DT, TWB+0.2, "%v℃ %v%%", TMP, RH)
+			}
+
+			// 絶対湿度に対して単調増加
+			assert.Greater(t, TWB, prev)
+			prev = TWB
+		}
+	}
+
+	assert.True(t, math.IsNaN(WetBulb(20, -0.001, PRES)))
+	assert.True(t, math.IsNaN(WetBulb(math.NaN(), 0.01, PRES)))
+}
+
+func Test_DewPoint(t *testing.T) {
+	assert.InDelta(t, 20.0, DewPoint(SaturationPressure(20+T0)), 0.05)
+	assert.InDelta(t, -10.0, DewPoint(SaturationPressureIce(-10+T0)), 0.1) // 霜点温度
+	assert.True(t, math.IsNaN(DewPoint(0.01)))
+	assert.True(t, math.IsNaN(DewPoint(200)))
+}
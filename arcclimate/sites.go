@@ -0,0 +1,322 @@
+package arcclimate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//--------------------------------------
+// 複数地点の計算
+//--------------------------------------
+
+// 推計対象地点
+type Site struct {
+	Name string  // 地点名
+	Lat  float64 // 緯度
+	Lon  float64 // 経度
+
+	// 地点ごとの計算条件。ゼロ値の場合は共通の計算条件 Options の値を使用します。
+	Mode             Mode
+	SeparationMethod SeparationMethod
+	Elevation        *float64 // 推計対象地点の標高 [m]
+}
+
+// 地点 site の計算条件 (共通の計算条件 opts に地点ごとの条件を反映したもの)
+func (site *Site) options(opts Options) Options {
+	opts.Lat, opts.Lon = site.Lat, site.Lon
+	if site.Mode != "" {
+		opts.Mode = site.Mode
+	}
+	if site.SeparationMethod != "" {
+		opts.SeparationMethod = site.SeparationMethod
+	}
+	if site.Elevation != nil {
+		opts.Elevation = site.Elevation
+	}
+	return opts
+}
+
+// 推計対象地点ごとの計算結果
+type SiteResult struct {
+	Site   Site
+	Target *MsmTarget // 計算結果 (Err が nil の場合)
+	Err    error      // この地点の計算に失敗した場合のエラー
+}
+
+// """複数の推計対象地点 sites について、計算条件 opts で空間補間計算を行います。
+// Args:
+//
+//	sites([]Site): 推計対象地点の一覧。opts の緯度 Lat, 経度 Lon は使用しません。
+//	opts(Options): 地点によらない計算条件
+//
+// Returns:
+//
+//	[]SiteResult: sites と同じ順の地点ごとの計算結果
+//	error: 計算条件が不正な場合、または ctx が中断された場合のエラー
+//
+// 各MSMファイルは最初に必要とした地点の計算時に1回だけ読み込み、周囲の地点を共有する推計対象地点の間で
+// 読み取り専用として再利用します。地点は北の行から順に(各行は西から)計算し、読み込んだファイルはそのファイルを
+// 使用する最後の地点の計算が終わった時点で解放します。同時に保持するファイル数は地点の配置によるため、
+// 広い範囲に散らばった多数の地点では、計算の途中で多くのファイルを保持することがあります。
+// 地点ごとのエラー(緯度経度の不正、MSMファイルの読み込み失敗など)は SiteResult.Err に返します。
+// """
+func InterpolateMany(ctx context.Context, sites []Site, opts Options) ([]SiteResult, error) {
+	results := make([]SiteResult, len(sites))
+	err := InterpolateEach(ctx, sites, opts, func(i int, r SiteResult) {
+		results[i] = r
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// InterpolateMany と同様に複数の推計対象地点の計算を行い、地点ごとの計算結果を完了した順に fn に渡します。
+// fn は sites における添字 i とともに、複数のゴルーチンから同時に呼び出されることがあります。
+// 計算結果をすべて保持せずに順次保存する場合に使用します。
+func InterpolateEach(ctx context.Context, sites []Site, opts Options, fn func(i int, r SiteResult)) error {
+	if err := opts.validateCommon(); err != nil {
+		return fmt.Errorf("invalid options: %w", err)
+	}
+
+	parallel := opts.Parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	// 地点ごとのエラー
+	errs := make([]error, len(sites))
+	lists := make([][]string, len(sites))
+	for i, site := range sites {
+		siteOpts := site.options(opts)
+		if err := siteOpts.Validate(); err != nil {
+			errs[i] = fmt.Errorf("invalid options: %w", err)
+			continue
+		}
+		lists[i] = RequiredMsmListN(site.Lat, site.Lon, siteOpts.neighborhood())
+		if err := opts.checkOffline(lists[i]); err != nil {
+			errs[i] = err
+			lists[i] = nil
+		}
+	}
+
+	srcs, err := opts.msmSources(nil)
+	if err != nil {
+		return err
+	}
+
+	// MSMファイルの読込 (各ファイル1回のみ、使用する地点の計算が終わったら解放)
+	window := opts.window()
+	pool := newMsmPool(lists, parallel, func(name string) (MsmData, error) {
+		if err := ctx.Err(); err != nil {
+			return MsmData{}, err
+		}
+		msm, err := load_msm_archives(ctx, srcs, opts.Precedence, window, name)
+		if err == nil {
+			log.Printf("MSM読み込み完了 %s", name)
+		}
+		return msm, err
+	})
+	log.Printf("データ読み込み: %d 地点, MSMファイル %d 件", len(sites), pool.len())
+
+	// MSM地点の標高データ (1次メッシュごとに共有)
+	masters := make(map[int]*ElevationMaster)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+	for _, i := range sweepOrder(lists) {
+		site := sites[i]
+
+		var ele *ElevationMaster
+		if errs[i] == nil {
+			mesh1d, _ := MeshCodeFromLatLon(site.Lat, site.Lon)
+			ele = masters[mesh1d]
+			if ele == nil {
+				ele, errs[i] = NewElevationMaster(site.Lat, site.Lon)
+				masters[mesh1d] = ele
+			}
+		}
+
+		if errs[i] != nil {
+			pool.release(lists[i])
+			fn(i, SiteResult{Site: site, Err: errs[i]})
+			continue
+		}
+
+		// 計算順を保つため、起動前に同時実行数の枠を確保する
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, site Site, ele *ElevationMaster) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			defer pool.release(lists[i])
+
+			r := SiteResult{Site: site}
+			if r.Err = ctx.Err(); r.Err == nil {
+				// 周囲のMSMデータ
+				var msms MsmDataSet
+				if msms, r.Err = pool.acquire(lists[i]); r.Err == nil {
+					siteOpts := site.options(opts)
+					r.Target, r.Err = siteOpts.interpolate(ctx, site.Lat, site.Lon, msms, ele)
+				}
+			}
+			fn(i, r)
+		}(i, site, ele)
+	}
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+// 地点の計算順 (必要なMSMファイル lists の先頭(南西)の地点のメッシュ地点番号 SN, WE の昇順)
+// SN は南ほど大きいため、北の行から、各行は西から順となります。
+// 周囲の地点を共有する推計対象地点を続けて計算し、読み込んだMSMファイルを早く解放できるようにします。
+func sweepOrder(lists [][]string) []int {
+	order := make([]int, len(lists))
+	keys := make([][2]int, len(lists))
+	for i := range order {
+		order[i] = i
+		keys[i] = [2]int{-1, -1} // 不正な地点は先頭
+		if len(lists[i]) > 0 {
+			if sn, we, err := parseMsmName(lists[i][0]); err == nil {
+				keys[i] = [2]int{sn, we}
+			}
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ka, kb := keys[order[a]], keys[order[b]]
+		if ka[0] != kb[0] {
+			return ka[0] < kb[0]
+		}
+		return ka[1] < kb[1]
+	})
+	return order
+}
+
+// メッシュ地点番号 name ({SN}-{WE}) の SN, WE
+func parseMsmName(name string) (int, int, error) {
+	s := strings.SplitN(name, "-", 2)
+	if len(s) != 2 {
+		return 0, 0, fmt.Errorf("invalid msm name %q", name)
+	}
+	sn, err := strconv.Atoi(s[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid msm name %q", name)
+	}
+	we, err := strconv.Atoi(s[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid msm name %q", name)
+	}
+	return sn, we, nil
+}
+
+// 地点間で共有するMSMファイル
+// 各ファイルを使用する地点数を数え、最後の地点が解放した時点で読み込んだデータを破棄します。
+type msmPool struct {
+	mu      sync.Mutex
+	entries map[string]*pooledMsm
+	load    func(name string) (MsmData, error)
+	sem     chan struct{} // 同時に読み込むファイル数の上限
+
+	live int // 読み込み済みで解放されていないファイル数
+	peak int // live の最大値
+}
+
+type pooledMsm struct {
+	refs   int // 解放していない地点数
+	once   sync.Once
+	loaded bool
+	msm    MsmData
+	err    error
+}
+
+// 地点ごとに必要なMSMファイル lists を最大 parallel 件ずつ並列に load で読み込むプールを作成します。
+func newMsmPool(lists [][]string, parallel int, load func(name string) (MsmData, error)) *msmPool {
+	p := &msmPool{entries: make(map[string]*pooledMsm), load: load, sem: make(chan struct{}, parallel)}
+	for _, list := range lists {
+		for _, name := range list {
+			e := p.entries[name]
+			if e == nil {
+				e = &pooledMsm{}
+				p.entries[name] = e
+			}
+			e.refs++
+		}
+	}
+	return p
+}
+
+// 解放されていないMSMファイルの数
+func (p *msmPool) len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.entries)
+}
+
+// MSMファイル list を読み込みます (読み込み済みの場合は共有)。
+// 読み込みに失敗した場合は、最初に失敗したファイルのエラーを返します。
+func (p *msmPool) acquire(list []string) (MsmDataSet, error) {
+	entries := make([]*pooledMsm, len(list))
+	p.mu.Lock()
+	for j, name := range list {
+		entries[j] = p.entries[name]
+	}
+	p.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for j := range list {
+		wg.Add(1)
+		go func(name string, e *pooledMsm) {
+			defer wg.Done()
+			e.once.Do(func() {
+				p.sem <- struct{}{}
+				e.msm, e.err = p.load(name)
+				<-p.sem
+
+				p.mu.Lock()
+				e.loaded = true
+				p.live++
+				if p.live > p.peak {
+					p.peak = p.live
+				}
+				p.mu.Unlock()
+			})
+		}(list[j], entries[j])
+	}
+	wg.Wait()
+
+	msms := MsmDataSet{Data: make([]MsmData, len(list))}
+	for j, e := range entries {
+		if e.err != nil {
+			return MsmDataSet{}, e.err
+		}
+		msms.Data[j] = e.msm
+	}
+	return msms, nil
+}
+
+// 地点の計算が終わったMSMファイル list を解放します。
+func (p *msmPool) release(list []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, name := range list {
+		e := p.entries[name]
+		if e == nil {
+			continue
+		}
+		e.refs--
+		if e.refs > 0 {
+			continue
+		}
+		delete(p.entries, name)
+		if e.loaded {
+			e.msm = MsmData{}
+			p.live--
+		}
+	}
+}
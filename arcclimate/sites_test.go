@@ -0,0 +1,167 @@
+package arcclimate
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// 読み込み回数をMSMファイルごとに数える取得元(並列読み込み用)
+type syncCountingMsmSource struct {
+	MsmSource
+	mu     sync.Mutex
+	opened map[string]int
+}
+
+func (s *syncCountingMsmSource) Open(ctx context.Context, name string) (io.ReadCloser, error) {
+	s.mu.Lock()
+	s.opened[name]++
+	s.mu.Unlock()
+	return s.MsmSource.Open(ctx, name)
+}
+
+func Test_InterpolateMany(t *testing.T) {
+	sites := []Site{
+		{Name: "a", Lat: 35.658, Lon: 139.741},
+		{Name: "b", Lat: 35.662, Lon: 139.745},
+		{Name: "c", Lat: 35.705, Lon: 139.741},
+		{Name: "invalid", Lat: 95.0, Lon: 139.741},
+	}
+
+	mem := NewMemoryMsmSource()
+	start := time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, site := range sites[:3] {
+		for i, name := range RequiredMsmList(site.Lat, site.Lon) {
+			var csv bytes.Buffer
+			csv.WriteString("date,TMP,MR,DSWRF_est,DSWRF_msm,Ld,VGRD,UGRD,PRES,APCP01\n")
+			for h := 0; h < 365*24; h++ {
+				fmt.Fprintf(&csv, "%s,%d,5.0,0.0,0.0,300.0,1.0,-1.0,101325.0,0.0\n",
+					start.Add(time.Duration(h)*time.Hour).Format("2006-01-02 15:04:05"), i+h%24)
+			}
+			mem.Put(name, makeMsmGzFromCSV(csv.String()))
+		}
+	}
+	src := &syncCountingMsmSource{MsmSource: mem, opened: map[string]int{}}
+
+	opts := NewOptions(0, 0)
+	opts.StartYear, opts.EndYear = 2011, 2011
+	opts.ElevationMode = ElevationMesh
+	opts.Source = src
+	opts.UseCache, opts.SaveCache = false, false
+
+	results, err := InterpolateMany(context.Background(), sites, opts)
+	assert.NoError(t, err)
+	assert.Len(t, results, len(sites))
+
+	// 周囲の地点を共有していても、各MSMファイルは1回のみ読み込む
+	assert.Len(t, src.opened, 6)
+	for name, n := range src.opened {
+		assert.Equal(t, 1, n, name)
+	}
+
+	// 1地点ずつ計算した結果と一致する
+	for _, r := range results[:3] {
+		assert.NoError(t, r.Err)
+		single := opts
+		single.Lat, single.Lon = r.Site.Lat, r.Site.Lon
+		want, err := InterpolateWithOptions(context.Background(), single)
+		assert.NoError(t, err)
+		assert.Equal(t, want.TMP, r.Target.TMP, r.Site.Name)
+		assert.Equal(t, want.RH, r.Target.RH, r.Site.Name)
+	}
+
+	// 不正な地点はその地点のみエラー
+	assert.Error(t, results[3].Err)
+	assert.Nil(t, results[3].Target)
+
+	// 地点ごとの計算条件
+	elevation := 120.0
+	sites = []Site{
+		{Name: "fixed", Lat: 35.658, Lon: 139.741, Elevation: &elevation, SeparationMethod: SeparationErbs},
+		{Name: "unknown", Lat: 35.658, Lon: 139.741, SeparationMethod: "unknown"},
+	}
+	results, err = InterpolateMany(context.Background(), sites, opts)
+	assert.NoError(t, err)
+	meta := results[0].Target.Metadata
+	assert.Equal(t, 120.0, meta.Elevation)
+	assert.Equal(t, ElevationFixed, meta.ElevationMode)
+	assert.Equal(t, SeparationErbs, meta.SeparationMethod)
+	assert.Len(t, meta.MsmWeights, 4)
+	assert.Error(t, results[1].Err)
+}
+
+func Test_CorrectedMsm_TMP_PRES_MR_DoesNotModify(t *testing.T) {
+	msm := makeMsmData(t, "238-315", time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 3)
+	for i := range msm.Rows {
+		msm.Rows[i].TMP, msm.Rows[i].PRES, msm.Rows[i].MR = 20.0, 101325.0, 10.0
+	}
+	orig := append([]MsmDataRow(nil), msm.Rows...)
+
+	corrected := msm.CorrectedMsm_TMP_PRES_MR(0.0, 100.0, nil)
+	assert.True(t, equalMsmRows(orig, msm.Rows))
+	assert.NotEqual(t, msm.Rows[0].TMP, corrected.Rows[0].TMP)
+}
+
+// 読み込んだMSMファイルは、使用する最後の地点が解放した時点で破棄する
+// メッシュ地点番号を数値として比較し、北の行から、各行は西から順とする
+func Test_sweepOrder(t *testing.T) {
+	lists := [][]string{
+		{"1000-99", "1000-100"},
+		{"999-100", "999-101"},
+		{"1000-100", "1000-101"},
+		{"broken"},
+		{"999-99", "999-100"},
+	}
+	assert.Equal(t, []int{3, 4, 1, 0, 2}, sweepOrder(lists))
+}
+
+func Test_msmPool(t *testing.T) {
+	lists := [][]string{
+		{"1000-1000", "1000-1001", "1001-1000", "1001-1001"},
+		{"2000-2000", "2000-2001", "2001-2000", "2001-2001"},
+		{"1000-1001", "1000-1002", "1001-1001", "1001-1002"},
+		nil, // 不正な地点
+	}
+	var mu sync.Mutex
+	loads := map[string]int{}
+	pool := newMsmPool(lists, 2, func(name string) (MsmData, error) {
+		mu.Lock()
+		loads[name]++
+		mu.Unlock()
+		if name == "2001-2001" {
+			return MsmData{}, fmt.Errorf("broken %s", name)
+		}
+		return MsmData{}, nil
+	})
+	assert.Equal(t, 10, pool.len())
+
+	// 周囲の地点を共有する地点を続けて計算する
+	order := sweepOrder(lists)
+	assert.Equal(t, []int{3, 0, 2, 1}, order)
+
+	for _, i := range order {
+		msms, err := pool.acquire(lists[i])
+		if i == 1 {
+			assert.Error(t, err)
+		} else {
+			assert.NoError(t, err)
+			assert.Len(t, msms.Data, len(lists[i]))
+		}
+		pool.release(lists[i])
+	}
+
+	// 各ファイルは1回のみ読み込み、同時に保持するのは計算中の地点が使用するファイルのみ
+	assert.Len(t, loads, 10)
+	for name, n := range loads {
+		assert.Equal(t, 1, n, name)
+	}
+	assert.Equal(t, 4, pool.peak)
+	assert.Equal(t, 0, pool.live)
+	assert.Equal(t, 0, pool.len())
+}
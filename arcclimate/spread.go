@@ -0,0 +1,198 @@
+package arcclimate
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+	"time"
+)
+
+//--------------------------------------
+// 空間補間の不確かさ
+//--------------------------------------
+
+// ばらつきを記録する変数 (MsmDataRow の項目名)
+var spreadVariables = []string{"TMP", "MR", "DSWRF_est", "DSWRF_msm", "Ld", "PRES", "APCP01"}
+
+// 周囲のMSM地点の標高補正後の値とそのばらつき
+type SpreadVariable struct {
+	Name      string      // 変数名 (TMP, MR, DSWRF_est, DSWRF_msm, Ld, PRES, APCP01)
+	Neighbors [][]float64 // 周囲の各地点の標高補正後の値 [地点][時刻]
+	Min       []float64   // 周囲の地点の最小値
+	Max       []float64   // 周囲の地点の最大値
+	Std       []float64   // 周囲の地点の重み付き標準偏差
+}
+
+// 空間補間に使用した周囲のMSM地点の値のばらつき
+// 値の単位は MsmTarget の同じ名前の項目と同じです (Ld は MJ/m2 に換算済み)。
+type MsmSpread struct {
+	date      []time.Time
+	Names     []string  // 周囲のMSM地点のメッシュ地点番号
+	Weights   []float64 // 各地点の按分の重み (Names と同じ順)
+	Variables []SpreadVariable
+}
+
+// 標高補正後の周囲のMSMデータ corrected (名前 names, 重み weights) からばらつきを作成します。
+// 重み付き標準偏差は重みの絶対値で計算します (双3次補間の負の重みを含む場合のため)。
+func newMsmSpread(corrected []*MsmData, names []string, weights []float64) *MsmSpread {
+	l := corrected[0].Length()
+
+	spread := &MsmSpread{
+		date:    make([]time.Time, l),
+		Names:   names,
+		Weights: weights,
+	}
+	for i := 0; i < l; i++ {
+		spread.date[i] = corrected[0].Rows[i].date
+	}
+
+	total := 0.0
+	for _, w := range weights {
+		total += math.Abs(w)
+	}
+
+	for _, name := range spreadVariables {
+		v := SpreadVariable{
+			Name:      name,
+			Neighbors: make([][]float64, len(corrected)),
+			Min:       make([]float64, l),
+			Max:       make([]float64, l),
+			Std:       make([]float64, l),
+		}
+		for j := range corrected {
+			v.Neighbors[j] = make([]float64, l)
+			for i := 0; i < l; i++ {
+				v.Neighbors[j][i] = spreadValue(&corrected[j].Rows[i], name)
+			}
+		}
+
+		for i := 0; i < l; i++ {
+			lo, hi, mean := math.Inf(1), math.Inf(-1), 0.0
+			for j := range corrected {
+				x := v.Neighbors[j][i]
+				if math.IsNaN(x) {
+					lo, hi = x, x
+				} else if !math.IsNaN(lo) {
+					lo, hi = math.Min(lo, x), math.Max(hi, x)
+				}
+				mean += math.Abs(weights[j]) * x
+			}
+			mean /= total
+
+			variance := 0.0
+			for j := range corrected {
+				d := v.Neighbors[j][i] - mean
+				variance += math.Abs(weights[j]) * d * d
+			}
+			v.Min[i], v.Max[i], v.Std[i] = lo, hi, math.Sqrt(variance/total)
+		}
+
+		spread.Variables = append(spread.Variables, v)
+	}
+
+	return spread
+}
+
+// 行 row の変数 name の値
+func spreadValue(row *MsmDataRow, name string) float64 {
+	switch name {
+	case "TMP":
+		return row.TMP
+	case "MR":
+		return row.MR
+	case "DSWRF_est":
+		return row.DSWRF_est
+	case "DSWRF_msm":
+		return row.DSWRF_msm
+	case "Ld":
+		return row.Ld
+	case "PRES":
+		return row.PRES
+	case "APCP01":
+		return row.APCP01
+	}
+	return math.NaN()
+}
+
+// 変数 name のばらつきを返します。記録していない変数の場合は nil を返します。
+func (spread *MsmSpread) Variable(name string) *SpreadVariable {
+	for i := range spread.Variables {
+		if spread.Variables[i].Name == name {
+			return &spread.Variables[i]
+		}
+	}
+	return nil
+}
+
+// 添字 start から end (含まない) までの時刻を抜き出して新しい構造体を作成します。
+func (spread *MsmSpread) slice(start int, end int) *MsmSpread {
+	cut := func(v []float64) []float64 { return append([]float64{}, v[start:end]...) }
+
+	s := &MsmSpread{
+		date:      append([]time.Time{}, spread.date[start:end]...),
+		Names:     spread.Names,
+		Weights:   spread.Weights,
+		Variables: make([]SpreadVariable, len(spread.Variables)),
+	}
+	for k, v := range spread.Variables {
+		neighbors := make([][]float64, len(v.Neighbors))
+		for j := range v.Neighbors {
+			neighbors[j] = cut(v.Neighbors[j])
+		}
+		s.Variables[k] = SpreadVariable{Name: v.Name, Neighbors: neighbors, Min: cut(v.Min), Max: cut(v.Max), Std: cut(v.Std)}
+	}
+	return s
+}
+
+// 大気放射量 Ld の単位をW/m2からMJ/m2に換算
+func (spread *MsmSpread) convertLdUnit() {
+	v := spread.Variable("Ld")
+	for _, series := range append([][]float64{v.Min, v.Max, v.Std}, v.Neighbors...) {
+		for i := range series {
+			series[i] = series[i] * (3.6 / 1000)
+		}
+	}
+}
+
+// CSV形式
+//
+// Note:
+//
+//	変数ごとに 変数名_min, 変数名_max, 変数名_std と、周囲の各地点の値 変数名_メッシュ地点番号 の列を出力します。
+//	値が無い場合(NaN)は空欄とします。
+func (spread *MsmSpread) ToCSV(buf *bytes.Buffer) {
+	buf.WriteString("date")
+	for _, v := range spread.Variables {
+		buf.WriteString("," + v.Name + "_min")
+		buf.WriteString("," + v.Name + "_max")
+		buf.WriteString("," + v.Name + "_std")
+		for _, name := range spread.Names {
+			buf.WriteString("," + v.Name + "_" + name)
+		}
+	}
+	buf.WriteString("\n")
+
+	writeFloat := func(v float64) {
+		buf.WriteString(",")
+		if math.IsNaN(v) {
+			return
+		}
+		if v != 0.0 {
+			buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+		} else {
+			buf.WriteString("0.0")
+		}
+	}
+	for i := 0; i < len(spread.date); i++ {
+		buf.WriteString(spread.date[i].Format("2006-01-02 15:04:05"))
+		for _, v := range spread.Variables {
+			writeFloat(v.Min[i])
+			writeFloat(v.Max[i])
+			writeFloat(v.Std[i])
+			for j := range v.Neighbors {
+				writeFloat(v.Neighbors[j][i])
+			}
+		}
+		buf.WriteString("\n")
+	}
+}
@@ -0,0 +1,80 @@
+package arcclimate
+
+import (
+	"bytes"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_MsmSpread(t *testing.T) {
+	start := time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+	msms := MsmDataSet{Data: []MsmData{
+		makeMsmData(t, "238-315", start, 3),
+		makeMsmData(t, "238-316", start, 3),
+	}}
+	for j, tmp := range []float64{10.0, 14.0} {
+		for i := range msms.Data[j].Rows {
+			msms.Data[j].Rows[i].TMP = tmp
+			msms.Data[j].Rows[i].DSWRF_msm = math.NaN()
+		}
+	}
+	weights := []float64{0.75, 0.25}
+
+	res := msms.prportionalDivided(weights, []float64{0, 0}, 0, NoLapseRate, true)
+	assert.InDelta(t, 11.0, res.TMP[0], 1e-9)
+
+	tmp := res.Spread.Variable("TMP")
+	assert.Equal(t, []float64{10.0, 10.0, 10.0}, tmp.Neighbors[0])
+	assert.Equal(t, 10.0, tmp.Min[0])
+	assert.Equal(t, 14.0, tmp.Max[0])
+	assert.InDelta(t, math.Sqrt(0.75*1+0.25*9), tmp.Std[0], 1e-9)
+	assert.True(t, math.IsNaN(res.Spread.Variable("DSWRF_msm").Max[0]))
+	assert.Nil(t, res.Spread.Variable("UGRD"))
+
+	// 期間の抜き出し
+	ext := res.Spread.slice(1, 3)
+	assert.Len(t, ext.Variable("TMP").Std, 2)
+
+	var buf bytes.Buffer
+	ext.ToCSV(&buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 3)
+	assert.True(t, strings.HasPrefix(lines[0], "date,TMP_min,TMP_max,TMP_std,TMP_238-315,TMP_238-316,MR_min"))
+	assert.True(t, strings.HasPrefix(lines[1], "2011-01-01 01:00:00,10,14,"))
+
+	// 指定しない場合は保持しない
+	assert.Nil(t, msms.PrportionalDivided(weights, []float64{0, 0}, 0, nil).Spread)
+}
+
+// 負の重みを含む按分でも、降水量・日射量・重量絶対湿度は0以上とする
+func Test_PrportionalDivided_NonNegative(t *testing.T) {
+	start := time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+	msms := MsmDataSet{Data: []MsmData{
+		makeMsmData(t, "238-315", start, 3),
+		makeMsmData(t, "238-316", start, 3),
+	}}
+	for j, v := range []float64{0.0, 2.0} {
+		for i := range msms.Data[j].Rows {
+			row := &msms.Data[j].Rows[i]
+			row.APCP01, row.DSWRF_est, row.DSWRF_msm, row.MR = v, v, v, v
+			row.TMP, row.UGRD = v, v
+		}
+	}
+	weights := []float64{1.125, -0.125}
+
+	res := msms.PrportionalDivided(weights, []float64{0, 0}, 0, NoLapseRate)
+	for i := range res.APCP01 {
+		assert.Equal(t, 0.0, res.APCP01[i])
+		assert.Equal(t, 0.0, res.DSWRF_est[i])
+		assert.Equal(t, 0.0, res.DSWRF_msm[i])
+		assert.Equal(t, 0.0, res.MR[i])
+
+		// 負の値となりうる量はそのまま
+		assert.InDelta(t, -0.25, res.TMP[i], 1e-12)
+		assert.InDelta(t, -0.25, res.UGRD[i], 1e-12)
+	}
+}
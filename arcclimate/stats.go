@@ -0,0 +1,39 @@
+package arcclimate
+
+import (
+	"sync/atomic"
+)
+
+//--------------------------------------
+// 統計
+//--------------------------------------
+
+// プロセス全体での処理の累計 (サーバーの監視用)
+type Stats struct {
+	MsmCacheHits         uint64 // 格納ディレクトリのMSMファイル(バイナリキャッシュを含む)を使用した回数
+	MsmCacheMisses       uint64 // 格納ディレクトリに無いMSMファイルを取得元から取得した回数
+	MsmMemoryHits        uint64 // プロセス内のキャッシュ(MsmMemoryCache)のMSMデータを使用した回数
+	MsmMemoryMisses      uint64 // プロセス内のキャッシュに無いMSMデータを読み込んだ回数
+	DownloadBytes        uint64 // HTTPでダウンロードしたMSMファイルのバイト数
+	ElevationAPIRequests uint64 // 国土地理院のAPIで標高を取得した回数
+	ElevationAPIFailures uint64 // 国土地理院のAPIの通信や応答の異常で標高を取得できなかった回数 (標高データが無い地点を除く)
+}
+
+var stats Stats
+
+// 処理の累計を返します。
+func ReadStats() Stats {
+	return Stats{
+		MsmCacheHits:         atomic.LoadUint64(&stats.MsmCacheHits),
+		MsmCacheMisses:       atomic.LoadUint64(&stats.MsmCacheMisses),
+		MsmMemoryHits:        atomic.LoadUint64(&stats.MsmMemoryHits),
+		MsmMemoryMisses:      atomic.LoadUint64(&stats.MsmMemoryMisses),
+		DownloadBytes:        atomic.LoadUint64(&stats.DownloadBytes),
+		ElevationAPIRequests: atomic.LoadUint64(&stats.ElevationAPIRequests),
+		ElevationAPIFailures: atomic.LoadUint64(&stats.ElevationAPIFailures),
+	}
+}
+
+func countStat(counter *uint64, n uint64) {
+	atomic.AddUint64(counter, n)
+}
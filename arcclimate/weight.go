@@ -1,6 +1,8 @@
 package arcclimate
 
 import (
+	"errors"
+	"fmt"
 	"math"
 )
 
@@ -8,62 +10,135 @@ import (
 // 距離の重みづけ平均の係数を算出
 //--------------------------------------
 
+// 空間補間の方法
+type InterpolationMethod string
+
+const (
+	InterpolationIDW      InterpolationMethod = "idw"      // 逆距離加重 (距離のべき乗の逆数で重みづけ)
+	InterpolationBilinear InterpolationMethod = "bilinear" // 双線形補間 (緯度・経度方向の線形補間)
+	InterpolationNearest  InterpolationMethod = "nearest"  // 最も近いMSM地点の値を使用
+	InterpolationBicubic  InterpolationMethod = "bicubic"  // 双3次補間 (周囲16地点の場合のみ)
+)
+
+// 文字列 s を空間補間の方法に変換します。
+func ParseInterpolationMethod(s string) (InterpolationMethod, error) {
+	switch m := InterpolationMethod(s); m {
+	case InterpolationIDW, InterpolationBilinear, InterpolationNearest, InterpolationBicubic:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown interpolation method %q (want idw, bilinear, nearest or bicubic)", s)
+}
+
+// 推計対象地点の緯度（10進法）lat, 経度 lon から空間補間の方法 method による MSM4地点(SW,SE,NW,NE)の重みを返す。
+// power は逆距離加重(idw)の距離のべき数です(0 の場合は 1)。method が空の場合は逆距離加重とします。
+func InterpolationWeights(lat float64, lon float64, method InterpolationMethod, power float64) ([4]float64, error) {
+	switch method {
+	case "", InterpolationIDW:
+		if power == 0 {
+			power = 1
+		}
+		if !(power > 0) || math.IsInf(power, 0) {
+			return [4]float64{}, fmt.Errorf("invalid idw power %v", power)
+		}
+		distances, err := latLonMsmDistances(lat, lon)
+		if err != nil {
+			return [4]float64{}, err
+		}
+		return weightsFromDistancesPower(distances, power), nil
+
+	case InterpolationBilinear:
+		return bilinearWeights(lat, lon), nil
+
+	case InterpolationNearest:
+		distances, err := latLonMsmDistances(lat, lon)
+		if err != nil {
+			return [4]float64{}, err
+		}
+		nearest := 0
+		for i := 1; i < 4; i++ {
+			if distances[i] < distances[nearest] {
+				nearest = i
+			}
+		}
+		var weights [4]float64
+		weights[nearest] = 1.0
+		return weights, nil
+
+	case InterpolationBicubic:
+		return [4]float64{}, fmt.Errorf("interpolation method %q requires the 16-point neighborhood", method)
+	}
+	return [4]float64{}, fmt.Errorf("unknown interpolation method %q (want idw, bilinear, nearest or bicubic)", method)
+}
+
 // 推計対象地点の緯度（10進法）lat, 経度 lon から MSM4地点(SW,SE,NW,NE)の重みを返す。
-func MsmWeights(lat float64, lon float64) [4]float64 {
+// 重みは距離の逆数(逆距離加重, べき数1)とします。
+func MsmWeights(lat float64, lon float64) ([4]float64, error) {
 
 	// 補間計算 リストはいずれもSW南西,SE南東,NW北西,NE北東の順
 	// 入力した緯度経度から周囲のMSMまでの距離を算出して、距離の重みづけ係数をリストで返す
-	distances := latLonMsmDistances(lat, lon)
+	distances, err := latLonMsmDistances(lat, lon)
+	if err != nil {
+		return [4]float64{}, err
+	}
 
 	// MSM4地点のと目標座標の距離から4地点のウェイトを計算
 	weights := weightsFromDistances(distances)
 
-	return weights
+	return weights, nil
 }
 
-// 推計対象地点の緯度（10進法）lat, 経度 lon からMSM4地点(SW,SE,NW,NE)と推計対象地点の距離を返す。
-func latLonMsmDistances(lat float64, lon float64) [4]float64 {
-	const lat_unit = 0.05   // MSMの緯度間隔
-	const lon_unit = 0.0625 // MSMの経度間隔
+const msm_lat_unit = 0.05   // MSMの緯度間隔
+const msm_lon_unit = 0.0625 // MSMの経度間隔
 
+// 推計対象地点の緯度（10進法）lat, 経度 lon の周囲のMSM4地点(SW,SE,NW,NE)の緯度・経度を返す。
+func msmGridPoints(lat float64, lon float64) [4][2]float64 {
+	// メッシュ周囲のMSM位置（緯度経度）の取得
+	lat_S := math.Floor(lat/msm_lat_unit) * msm_lat_unit // 南は切り下げ
+	lon_W := math.Floor(lon/msm_lon_unit) * msm_lon_unit // 西は切り下げ
+
+	// 南西（左下）、南東（右下）、北西（左上）、北東（右上）の順
+	return [4][2]float64{
+		{lat_S, lon_W},
+		{lat_S, lon_W + msm_lon_unit},
+		{lat_S + msm_lat_unit, lon_W},
+		{lat_S + msm_lat_unit, lon_W + msm_lon_unit},
+	}
+}
+
+// 推計対象地点の緯度（10進法）lat, 経度 lon からMSM4地点(SW,SE,NW,NE)と推計対象地点の距離を返す。
+func latLonMsmDistances(lat float64, lon float64) ([4]float64, error) {
 	lat0 := lat
 	lon0 := lon
 
-	// メッシュ周囲のMSM位置（緯度経度）の取得
-	lat_S := math.Floor(lat/lat_unit) * lat_unit // 南は切り下げ
-	lon_W := math.Floor(lon/lon_unit) * lon_unit // 西は切り下げ
-
 	// 緯度経度差から距離の重みづけ平均の係数を算出
+	points := msmGridPoints(lat, lon)
 
-	// 南西（左下）との距離
-	MSM_SW := vincentyInverse(lat0, lon0, lat_S, lon_W)
-
-	// 南東（右下）との距離
-	MSM_SE := vincentyInverse(lat0, lon0, lat_S, lon_W+lon_unit)
-
-	// 北西（左上）との距離
-	MSM_NW := vincentyInverse(lat0, lon0, lat_S+lat_unit, lon_W)
-
-	// 北東（右上）との距離
-	MSM_NE := vincentyInverse(lat0, lon0, lat_S+lat_unit, lon_W+lon_unit)
+	var distances [4]float64
+	for i, p := range points {
+		d, err := vincentyInverse(lat0, lon0, p[0], p[1])
+		if err != nil {
+			return distances, fmt.Errorf("distance from (%f, %f) to MSM point (%f, %f): %w", lat0, lon0, p[0], p[1], err)
+		}
+		distances[i] = d
+	}
 
-	return [4]float64{MSM_SW, MSM_SE, MSM_NW, MSM_NE}
+	return distances, nil
 }
 
 // vincenty法(逆解法)を用いて、地点1(緯度lat1,経度lon1)と地点2(緯度lat2,経度lon2)の楕円体上の距離[m]を求めます。
-// ただし、計算に失敗した場合は、パニックが発生します。
+// ただし、計算が収束しなかった場合は、ErrVincentyNotConverged を返します。
 //
 // 参照)
 //
 //	https://ja.wikipedia.org/wiki/Vincenty法
 //	https://vldb.gsi.go.jp/sokuchi/surveycalc/surveycalc/bl2stf.html
-func vincentyInverse(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+func vincentyInverse(lat1 float64, lon1 float64, lat2 float64, lon2 float64) (float64, error) {
 	// 反復計算の上限回数
 	const ITERATION_LIMIT = 10000
 
 	// 差異が無ければ0.0を返す
 	if math.Abs(lat1-lat2) < 1e-9 && math.Abs(lon1-lon2) < 1e-9 {
-		return 0.0
+		return 0.0, nil
 	}
 
 	// 長軸半径と扁平率から短軸半径を算出する
@@ -117,8 +192,8 @@ func vincentyInverse(lat1 float64, lon1 float64, lat2 float64, lon2 float64) flo
 
 	// 偏差が.000000000001以下ならbreak
 	if math.Abs(ramda-ramada_p) > 1e-12 {
-		// 計算が収束しなかった場合はNoneを返す
-		panic("計算が収束しなかった")
+		// 計算が収束しなかった場合はエラーを返す
+		return math.NaN(), ErrVincentyNotConverged
 	}
 
 	// λが所望の精度まで収束したら以下の計算を行う
@@ -130,17 +205,49 @@ func vincentyInverse(lat1 float64, lon1 float64, lat2 float64, lon2 float64) flo
 	// 2点間の楕円体上の距離
 	s := b * A * (sigma - dS)
 
-	return s
+	return s, nil
+}
+
+// vincenty法の反復計算が収束しなかったことを表すエラー
+var ErrVincentyNotConverged = errors.New("vincenty inverse did not converge")
+
+// 推計対象地点の緯度（10進法）lat, 経度 lon から双線形補間による MSM4地点(SW,SE,NW,NE)の重みを返す。
+func bilinearWeights(lat float64, lon float64) [4]float64 {
+	points := msmGridPoints(lat, lon)
+
+	// 南西の地点からの位置 (0～1)
+	// 浮動小数点の誤差で範囲外とならないようにする
+	ty := math.Min(math.Max((lat-points[0][0])/msm_lat_unit, 0), 1)
+	tx := math.Min(math.Max((lon-points[0][1])/msm_lon_unit, 0), 1)
+
+	return [4]float64{
+		(1 - tx) * (1 - ty), // SW
+		tx * (1 - ty),       // SE
+		(1 - tx) * ty,       // NW
+		tx * ty,             // NE
+	}
 }
 
 // 基準地点からの距離 distances [m]に応じて、それぞれの距離離れた地点の重みづけを計算します。
 // 全ても重みを合算すると常に1になるように計算します。
 func weightsFromDistances(distances [4]float64) [4]float64 {
+	return weightsFromDistancesPower(distances, 1)
+}
+
+// weightsFromDistances と同様に、距離の power 乗の逆数で重みづけを計算します。
+func weightsFromDistancesPower(distances [4]float64, power float64) [4]float64 {
+	var weights [4]float64
+	copy(weights[:], idwWeights(distances[:], power))
+	return weights
+}
+
+// 任意の地点数の距離 distances [m] から、距離の power 乗の逆数で重みづけを計算します。
+func idwWeights(distances []float64, power float64) []float64 {
 
-	weights := [4]float64{0.0, 0.0, 0.0, 0.0}
+	weights := make([]float64, len(distances))
 
 	//ピンポイント地点がある場合
-	for i := 0; i < 4; i++ {
+	for i := range distances {
 		if distances[i] == 0.0 {
 			weights[i] = 1.0
 			return weights
@@ -148,11 +255,11 @@ func weightsFromDistances(distances [4]float64) [4]float64 {
 	}
 
 	var total_distance_inv float64 = 0.0
-	for i := 0; i < 4; i++ {
-		total_distance_inv += 1.0 / distances[i]
+	for i := range distances {
+		total_distance_inv += 1.0 / math.Pow(distances[i], power)
 	}
-	for i := 0; i < 4; i++ {
-		weights[i] = 1.0 / distances[i] / total_distance_inv
+	for i := range distances {
+		weights[i] = 1.0 / math.Pow(distances[i], power) / total_distance_inv
 	}
 
 	return weights
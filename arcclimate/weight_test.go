@@ -1,7 +1,12 @@
 package arcclimate
 
 import (
+	"context"
+	"math"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -17,7 +22,8 @@ func Test_vincentyInverse(t *testing.T) {
 	lat2 := 35.65502847222223
 	lon2 := 139.74475044444443
 
-	L := vincentyInverse(lat1, lon1, lat2, lon2)
+	L, err := vincentyInverse(lat1, lon1, lat2, lon2)
+	assert.NoError(t, err)
 	assert.InDelta(t, L, 58643.804, 0.01)
 }
 
@@ -28,6 +34,135 @@ func Test_vincentyInverse_SamePosition(t *testing.T) {
 	lat2 := 36.10377477777778
 	lon2 := 140.08785502777778
 
-	L := vincentyInverse(lat1, lon1, lat2, lon2)
+	L, err := vincentyInverse(lat1, lon1, lat2, lon2)
+	assert.NoError(t, err)
 	assert.Equal(t, L, 0.0)
 }
+
+func Test_NewPointInfo(t *testing.T) {
+	p, err := NewPointInfo(context.Background(), 35.658, 139.741, ElevationMesh)
+	assert.NoError(t, err)
+	assert.Equal(t, RequiredMsmList(35.658, 139.741), p.MsmFiles)
+	assert.Len(t, p.MsmWeights, 4)
+	assert.Len(t, p.MsmElevations, 4)
+	assert.Equal(t, ElevationMesh, p.ElevationMode)
+
+	_, err = NewPointInfo(context.Background(), 35.658, 200, ElevationMesh)
+	assert.Error(t, err)
+}
+
+// 計算条件 opts の補間方法・周囲の地点数・海上の地点の除外は InterpolateWithOptions の計算条件の記録と一致する
+func Test_NewPointInfoWithOptions(t *testing.T) {
+	lat, lon := 35.658, 139.741
+	dir := t.TempDir()
+	data := makeMsmGz(time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC), 365*24, 0.0)
+	for _, name := range RequiredMsmListN(lat, lon, Neighborhood16) {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, msmFileName(name)), data, 0644))
+	}
+
+	for _, c := range []struct {
+		method       InterpolationMethod
+		neighborhood int
+		power        float64
+		excludeSea   bool
+	}{
+		{InterpolationIDW, Neighborhood4, 2, false},
+		{InterpolationIDW, Neighborhood16, 1, true},
+		{InterpolationBicubic, Neighborhood16, 0, false},
+	} {
+		opts := NewOptions(lat, lon)
+		opts.StartYear, opts.EndYear = 2011, 2011
+		opts.MsmFileDir = dir
+		opts.Offline = true
+		opts.Interpolation = c.method
+		opts.Neighborhood = c.neighborhood
+		opts.IDWPower = c.power
+		opts.ExcludeSea = c.excludeSea
+
+		res, err := InterpolateWithOptions(context.Background(), opts)
+		assert.NoError(t, err)
+		p, err := NewPointInfoWithOptions(context.Background(), opts)
+		assert.NoError(t, err)
+
+		meta := res.Metadata
+		assert.Equal(t, meta.Interpolation, p.Interpolation, c)
+		assert.Equal(t, meta.IDWPower, p.IDWPower, c)
+		assert.Equal(t, meta.Neighborhood, p.Neighborhood, c)
+		assert.Equal(t, meta.MsmFiles, p.MsmFiles, c)
+		assert.Equal(t, meta.MsmWeights, p.MsmWeights, c)
+		assert.Equal(t, meta.ExcludedSeaPoints, p.ExcludedSeaPoints, c)
+		assert.Equal(t, meta.Elevation, p.Elevation, c)
+		assert.Equal(t, meta.ElevationMode, p.ElevationMode, c)
+		assert.Len(t, p.MsmElevations, c.neighborhood, c)
+	}
+}
+
+// 周囲4地点の重み weights で按分した場 field の値
+func interpolateField(lat float64, lon float64, weights [4]float64, field func(lat, lon float64) float64) float64 {
+	v := 0.0
+	for i, p := range msmGridPoints(lat, lon) {
+		v += weights[i] * field(p[0], p[1])
+	}
+	return v
+}
+
+func Test_InterpolationWeights(t *testing.T) {
+	points := [][2]float64{{35.658, 139.741}, {33.88, 130.87}, {43.06, 141.33}, {35.65, 139.75}, {35.6749, 139.8124}}
+
+	// 緯度・経度の1次式と双線形の場
+	linear := func(lat, lon float64) float64 { return 3 + 2*lat - 5*lon }
+	bilinear := func(lat, lon float64) float64 { return 1 + lat + 2*lon + 0.5*lat*lon }
+	constant := func(lat, lon float64) float64 { return 7 }
+
+	for _, p := range points {
+		lat, lon := p[0], p[1]
+		for _, m := range []InterpolationMethod{InterpolationIDW, InterpolationBilinear, InterpolationNearest} {
+			w, err := InterpolationWeights(lat, lon, m, 2)
+			assert.NoError(t, err)
+
+			// 重みの合計は1、定数の場はそのまま
+			assert.InDelta(t, 1, w[0]+w[1]+w[2]+w[3], 1e-12, m)
+			assert.InDelta(t, 7, interpolateField(lat, lon, w, constant), 1e-9, m)
+			for _, x := range w {
+				assert.True(t, x >= 0 && x <= 1, m)
+			}
+		}
+
+		// 双線形補間は1次式・双線形の場を再現する
+		w, _ := InterpolationWeights(lat, lon, InterpolationBilinear, 0)
+		assert.InDelta(t, linear(lat, lon), interpolateField(lat, lon, w, linear), 1e-9)
+		assert.InDelta(t, bilinear(lat, lon), interpolateField(lat, lon, w, bilinear), 1e-9)
+
+		// 最近傍は最も近いMSM地点の値そのもの
+		w, _ = InterpolationWeights(lat, lon, InterpolationNearest, 0)
+		d, _ := latLonMsmDistances(lat, lon)
+		for i := range d {
+			if w[i] == 1 {
+				for j := range d {
+					assert.True(t, d[i] <= d[j])
+				}
+			}
+		}
+	}
+
+	// べき数1の逆距離加重は MsmWeights と一致する
+	w1, _ := InterpolationWeights(35.658, 139.741, InterpolationIDW, 1)
+	w0, _ := MsmWeights(35.658, 139.741)
+	assert.Equal(t, w0, w1)
+
+	// べき数が大きいほど最も近い地点の重みが大きくなる
+	w4, _ := InterpolationWeights(35.658, 139.741, InterpolationIDW, 4)
+	assert.Greater(t, w4[1], w1[1])
+
+	// MSM地点上では、その地点の値となる
+	for _, m := range []InterpolationMethod{InterpolationIDW, InterpolationBilinear, InterpolationNearest} {
+		w, err := InterpolationWeights(35.65, 139.75, m, 1)
+		assert.NoError(t, err)
+		assert.InDelta(t, 1, math.Max(math.Max(w[0], w[1]), math.Max(w[2], w[3])), 1e-9, m)
+	}
+
+	_, err := InterpolationWeights(35.658, 139.741, "cubic", 1)
+	assert.Error(t, err)
+	_, err = InterpolationWeights(35.658, 139.741, InterpolationIDW, -1)
+	assert.Error(t, err)
+}
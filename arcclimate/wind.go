@@ -1,47 +1,136 @@
 package arcclimate
 
 import (
+	"fmt"
 	"math"
+	"strconv"
 )
 
 //--------------------------------------
 // 風速風向計算
 //--------------------------------------
 
-// ベクトル風速 UGRD, VGRD から16方位の風向風速 w_dir, w_spd を計算
-func (msm *MsmTarget) WindVectorToDirAndSpeed() {
-	msm.W_spd = make([]float64, len(msm.date))
-	msm.W_dir = make([]float64, len(msm.date))
+// 風向風速の計算条件
+type WindDirection struct {
+	// 風向の方位数 (0: 丸めない(連続値), 8, 16, 36)
+	Sectors int `json:"sectors"`
 
-	for i := 0; i < len(msm.date); i++ {
-		// 風向風速の計算
-		w_spd16, w_dir16 := Wind16(msm.UGRD[i], msm.VGRD[i])
+	// 丸めた方位への風速の射影 (風速 × cos(丸めた方位との差)) を行う場合は true とします。
+	Projection bool `json:"projection,omitempty"`
+}
+
+// 既定の風向風速の計算条件 (16方位、風速の射影あり。HASPの風向風速)
+var DefaultWindDirection = WindDirection{Sectors: 16, Projection: true}
+
+// 出力形式ごとの既定の風向風速の計算条件
+var (
+	CSVWindDirection  = DefaultWindDirection
+	JSONWindDirection = DefaultWindDirection
+	HASWindDirection  = DefaultWindDirection
+	EPWWindDirection  = WindDirection{Sectors: 0} // 連続値の風向とベクトルの大きさの風速
+)
+
+// 文字列 s ("continuous", "8", "16", "36") と射影の有無 projection から風向風速の計算条件を作成します。
+func ParseWindDirection(s string, projection bool) (WindDirection, error) {
+	mode := WindDirection{Projection: projection}
+	if s != "continuous" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return mode, fmt.Errorf("unknown wind direction mode %q (want continuous, 8, 16 or 36)", s)
+		}
+		mode.Sectors = n
+	}
+	return mode, mode.Validate()
+}
+
+// 計算条件の妥当性を確認します。
+func (mode WindDirection) Validate() error {
+	switch mode.Sectors {
+	case 0:
+		if mode.Projection {
+			return fmt.Errorf("wind speed projection requires wind direction sectors (8, 16 or 36)")
+		}
+	case 8, 16, 36:
+	default:
+		return fmt.Errorf("invalid wind direction sectors %d (want 0, 8, 16 or 36)", mode.Sectors)
+	}
+	return nil
+}
+
+func (mode WindDirection) String() string {
+	if mode.Sectors == 0 {
+		return "continuous"
+	}
+	if mode.Projection {
+		return fmt.Sprintf("%d-projected", mode.Sectors)
+	}
+	return strconv.Itoa(mode.Sectors)
+}
 
-		// 風速(16方位)
-		msm.W_spd[i] = w_spd16
+// ベクトル風速 UGRD, VGRD から計算条件 mode の風向風速 w_dir, w_spd を計算
+func (msm *MsmTarget) WindVectorToDirAndSpeed(mode WindDirection) {
+	msm.W_spd, msm.W_dir = windDirAndSpeed(msm.UGRD, msm.VGRD, mode)
+}
 
-		// 風向(16方位)
-		msm.W_dir[i] = w_dir16
+// ベクトル風速 UGRD, VGRD の系列から計算条件 mode の風速・風向の系列を計算
+func windDirAndSpeed(UGRD []float64, VGRD []float64, mode WindDirection) ([]float64, []float64) {
+	w_spd := make([]float64, len(UGRD))
+	w_dir := make([]float64, len(UGRD))
+	for i := range UGRD {
+		w_spd[i], w_dir[i] = WindDirAndSpeed(UGRD[i], VGRD[i], mode)
 	}
+	return w_spd, w_dir
+}
+
+// 出力形式の既定の計算条件 def の風速・風向を返します。
+// 計算条件を指定した場合(MsmTarget.WindDirection)は計算済みの W_spd, W_dir を返します。
+func (msm *MsmTarget) windFor(def WindDirection) ([]float64, []float64) {
+	if msm.WindDirection != nil || def == DefaultWindDirection {
+		return msm.W_spd, msm.W_dir
+	}
+	return windDirAndSpeed(msm.UGRD, msm.VGRD, def)
 }
 
 // ベクトル風速 UGRD (東西のベクトル成分), VGRD (南北のベクトル成分) から
-// 16方位の風向 w_spd16 と 風速 w_dir16 を計算する
-func Wind16(UGRD float64, VGRD float64) (w_spd16 float64, w_dir16 float64) {
+// 計算条件 mode の風速 w_spd と風向 w_dir (北=360°、時計回り) を計算する
+func WindDirAndSpeed(UGRD float64, VGRD float64, mode WindDirection) (w_spd float64, w_dir float64) {
 	// 風速
 	// 三平方の定理により、東西、南北のベクトル成分から風速を計算
-	w_spd := math.Sqrt(UGRD*UGRD + VGRD*VGRD)
+	w_spd = math.Sqrt(UGRD*UGRD + VGRD*VGRD)
 
 	// 風向
 	// 東西、南北のベクトル成分から風向を計算
-	w_dir := radToDegree(math.Atan2(UGRD, VGRD) + math.Pi)
+	w_dir = radToDegree(math.Atan2(UGRD, VGRD) + math.Pi)
 
-	// 16方位への丸め処理
-	w_dir16 = math.Round(w_dir/22.5) * 22.5
-	w_dir16_gap := math.Abs(w_dir16 - w_dir)
-	w_spd16 = math.Cos(degreeToRad(w_dir16_gap)) * w_spd
+	if mode.Sectors == 0 {
+		return w_spd, w_dir
+	}
+
+	// 方位への丸め処理
+	width := 360.0 / float64(mode.Sectors)
+	w_dir_round := math.Round(w_dir/width) * width
+	if mode.Projection {
+		w_dir_gap := math.Abs(w_dir_round - w_dir)
+		w_spd = math.Cos(degreeToRad(w_dir_gap)) * w_spd
+	}
 
-	return w_spd16, w_dir16
+	return w_spd, w_dir_round
+}
+
+// ベクトル風速 UGRD (東西のベクトル成分), VGRD (南北のベクトル成分) から
+// 16方位の風向 w_spd16 と 風速 w_dir16 を計算する
+func Wind16(UGRD float64, VGRD float64) (w_spd16 float64, w_dir16 float64) {
+	return WindDirAndSpeed(UGRD, VGRD, DefaultWindDirection)
+}
+
+// 風向 w_dir [°] のHAS形式の風向 (1:NNE,...,16:N)
+func hasWindDirection(w_dir float64) int {
+	code := int(math.Round(w_dir/22.5)) % 16
+	if code <= 0 {
+		// 真北の場合を0から16へ変更
+		code += 16
+	}
+	return code
 }
 
 func radToDegree(rad float64) float64 {
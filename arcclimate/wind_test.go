@@ -1,6 +1,7 @@
 package arcclimate
 
 import (
+	"math"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -11,3 +12,72 @@ func Test_wind16(t *testing.T) {
 	assert.InDelta(t, 1.4141456, spd, 0.0001)
 	assert.Equal(t, 180.0+45.0, dir)
 }
+
+func Test_WindDirAndSpeed(t *testing.T) {
+	// 連続値: 風向はそのまま、風速はベクトルの大きさ
+	spd, dir := WindDirAndSpeed(1.0, 1.0, WindDirection{})
+	assert.InDelta(t, math.Sqrt2, spd, 1e-12)
+	assert.InDelta(t, 225.0, dir, 1e-12)
+
+	// 方位数と射影 (風向 30°)
+	u, v := -math.Sin(math.Pi/6), -math.Cos(math.Pi/6)
+	spd, dir = WindDirAndSpeed(u, v, WindDirection{Sectors: 8})
+	assert.Equal(t, 45.0, dir)
+	assert.InDelta(t, 1.0, spd, 1e-12)
+	spd, dir = WindDirAndSpeed(u, v, WindDirection{Sectors: 8, Projection: true})
+	assert.Equal(t, 45.0, dir)
+	assert.InDelta(t, math.Cos(math.Pi/12), spd, 1e-12)
+	_, dir = WindDirAndSpeed(u, v, WindDirection{Sectors: 36})
+	assert.InDelta(t, 30.0, dir, 1e-12)
+
+	// Wind16 は16方位・射影あり
+	spd16, dir16 := Wind16(u, v)
+	spd, dir = WindDirAndSpeed(u, v, DefaultWindDirection)
+	assert.Equal(t, spd16, spd)
+	assert.Equal(t, dir16, dir)
+}
+
+func Test_ParseWindDirection(t *testing.T) {
+	mode, err := ParseWindDirection("continuous", false)
+	assert.NoError(t, err)
+	assert.Equal(t, WindDirection{}, mode)
+	mode, err = ParseWindDirection("36", true)
+	assert.NoError(t, err)
+	assert.Equal(t, WindDirection{Sectors: 36, Projection: true}, mode)
+
+	_, err = ParseWindDirection("continuous", true)
+	assert.Error(t, err)
+	_, err = ParseWindDirection("12", false)
+	assert.Error(t, err)
+	_, err = ParseWindDirection("north", false)
+	assert.Error(t, err)
+}
+
+func Test_hasWindDirection(t *testing.T) {
+	assert.Equal(t, 16, hasWindDirection(0))
+	assert.Equal(t, 16, hasWindDirection(360))
+	assert.Equal(t, 16, hasWindDirection(5))
+	assert.Equal(t, 1, hasWindDirection(22.5))
+	assert.Equal(t, 8, hasWindDirection(180))
+	assert.Equal(t, 15, hasWindDirection(337.5))
+}
+
+func Test_MsmTarget_windFor(t *testing.T) {
+	msm := &MsmTarget{UGRD: []float64{1.0}, VGRD: []float64{2.0}}
+	msm.WindVectorToDirAndSpeed(DefaultWindDirection)
+
+	// 既定の条件で計算済みの値
+	spd, dir := msm.windFor(CSVWindDirection)
+	assert.Equal(t, msm.W_spd, spd)
+	assert.Equal(t, msm.W_dir, dir)
+
+	// EPWは連続値で再計算
+	spd, dir = msm.windFor(EPWWindDirection)
+	assert.InDelta(t, math.Sqrt(5), spd[0], 1e-12)
+	assert.NotEqual(t, msm.W_dir[0], dir[0])
+
+	// 条件を指定した場合は計算済みの値
+	msm.WindDirection = &WindDirection{Sectors: 8}
+	spd, _ = msm.windFor(EPWWindDirection)
+	assert.Equal(t, msm.W_spd, spd)
+}
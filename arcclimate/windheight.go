@@ -0,0 +1,166 @@
+package arcclimate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//--------------------------------------
+// 風速の高さ・地表面粗度の換算
+//--------------------------------------
+
+// 地表面粗度区分 (建築基準法施行令第87条、平成12年建設省告示第1454号)
+type TerrainCategory int
+
+const (
+	TerrainI   TerrainCategory = 1 // 海岸線・湖岸線等の極めて平坦で障害物がない区域
+	TerrainII  TerrainCategory = 2 // 田園地帯等の障害物が散在する区域
+	TerrainIII TerrainCategory = 3 // 樹木・低層建築物が散在する区域 (一般の市街地)
+	TerrainIV  TerrainCategory = 4 // 中層建築物が主となる市街地
+	TerrainV   TerrainCategory = 5 // 高層建築物が密集する市街地
+)
+
+// 地表面粗度区分ごとの係数
+var terrainParams = [...]struct {
+	Zb    float64 // 高さの下限 [m]
+	ZG    float64 // 上空風の高さ [m]
+	Alpha float64 // べき指数
+	Z0    float64 // 対数則に用いる粗度長の目安 [m]
+}{
+	TerrainI:   {5, 250, 0.10, 0.005},
+	TerrainII:  {5, 350, 0.15, 0.05},
+	TerrainIII: {5, 450, 0.20, 0.3},
+	TerrainIV:  {10, 550, 0.27, 1.0},
+	TerrainV:   {20, 650, 0.35, 2.0},
+}
+
+// MSMの風速(地上10m)とみなす地表面粗度区分
+const msmTerrain = TerrainII
+
+// MSMの風速の高さ [m]
+const msmWindHeight = 10.0
+
+// 対数則で地表面粗度の影響が無くなる高さ (ブレンディング高さ) [m]
+const windBlendingHeight = 60.0
+
+func (c TerrainCategory) valid() bool {
+	return TerrainI <= c && c <= TerrainV
+}
+
+func (c TerrainCategory) String() string {
+	if !c.valid() {
+		return fmt.Sprintf("TerrainCategory(%d)", int(c))
+	}
+	return [...]string{"", "I", "II", "III", "IV", "V"}[c]
+}
+
+// 文字列 s ("I"～"V" または "1"～"5") を地表面粗度区分に変換します。
+func ParseTerrainCategory(s string) (TerrainCategory, error) {
+	for c := TerrainI; c <= TerrainV; c++ {
+		if strings.EqualFold(s, c.String()) || s == strconv.Itoa(int(c)) {
+			return c, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown terrain category %q (want I, II, III, IV or V)", s)
+}
+
+// 風速の鉛直分布
+type WindProfile string
+
+const (
+	WindProfilePower WindProfile = "power" // べき法則 (建築基準法の平均風速の高さ方向の分布を表す係数 Er)
+	WindProfileLog   WindProfile = "log"   // 対数則
+)
+
+// 文字列 s を風速の鉛直分布に変換します。
+func ParseWindProfile(s string) (WindProfile, error) {
+	switch p := WindProfile(s); p {
+	case WindProfilePower, WindProfileLog:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown wind profile %q (want power or log)", s)
+}
+
+// MSMの地上10mの風速を推計対象の高さ・地表面粗度の風速に換算する条件
+// MSMの風速は地表面粗度区分IIの地上10mの風速とみなします。
+type WindConversion struct {
+	Height    float64         `json:"height"`              // 換算後の高さ [m]
+	Profile   WindProfile     `json:"profile"`             // 風速の鉛直分布
+	Terrain   TerrainCategory `json:"terrain,omitempty"`   // 推計対象地点の地表面粗度区分
+	Roughness float64         `json:"roughness,omitempty"` // 推計対象地点の粗度長 z0 [m] (対数則のみ。0の場合は地表面粗度区分の目安値)
+}
+
+// 換算の条件の妥当性を確認します。
+func (c *WindConversion) Validate() error {
+	if !(c.Height > 0) || math.IsInf(c.Height, 0) {
+		return fmt.Errorf("invalid wind height %v", c.Height)
+	}
+	switch c.Profile {
+	case WindProfilePower:
+		if !c.Terrain.valid() {
+			return fmt.Errorf("wind profile %q requires a terrain category (I to V)", c.Profile)
+		}
+		if c.Roughness != 0 {
+			return fmt.Errorf("roughness length is only used with wind profile %q", WindProfileLog)
+		}
+	case WindProfileLog:
+		if c.Roughness == 0 && !c.Terrain.valid() {
+			return fmt.Errorf("wind profile %q requires a terrain category (I to V) or a roughness length", c.Profile)
+		}
+		if c.Roughness < 0 || math.IsNaN(c.Roughness) || math.IsInf(c.Roughness, 0) {
+			return fmt.Errorf("invalid roughness length %v", c.Roughness)
+		}
+		// ブレンディング高さ以上の粗度長では ln(60/z0) が0以下となり換算できない
+		if c.Roughness >= windBlendingHeight {
+			return fmt.Errorf("roughness length %vm must be below the blending height %vm", c.Roughness, windBlendingHeight)
+		}
+		if c.Height <= c.roughness() {
+			return fmt.Errorf("wind height %vm must be above the roughness length %vm", c.Height, c.roughness())
+		}
+	default:
+		return fmt.Errorf("unknown wind profile %q (want power or log)", c.Profile)
+	}
+	return nil
+}
+
+// 対数則に用いる粗度長 [m]
+func (c *WindConversion) roughness() float64 {
+	if c.Roughness > 0 {
+		return c.Roughness
+	}
+	if c.Terrain.valid() {
+		return terrainParams[c.Terrain].Z0
+	}
+	return math.NaN()
+}
+
+// MSMの地上10mの風速に対する換算後の風速の倍率
+func (c *WindConversion) Factor() float64 {
+	if c.Profile == WindProfileLog {
+		// 地表面粗度区分IIの10mの風速からブレンディング高さの風速を求め、推計対象地点の粗度長で高さ c.Height に換算
+		z0_msm := terrainParams[msmTerrain].Z0
+		z0 := c.roughness()
+		return math.Log(windBlendingHeight/z0_msm) / math.Log(msmWindHeight/z0_msm) *
+			math.Log(c.Height/z0) / math.Log(windBlendingHeight/z0)
+	}
+	return powerLawEr(c.Height, c.Terrain) / powerLawEr(msmWindHeight, msmTerrain)
+}
+
+// 地表面粗度区分 terrain の高さ z [m] の平均風速の高さ方向の分布を表す係数 Er
+// Er = 1.7 (max(z, Zb) / ZG)^α (z は ZG を上限とします)
+func powerLawEr(z float64, terrain TerrainCategory) float64 {
+	p := terrainParams[terrain]
+	z = math.Min(math.Max(z, p.Zb), p.ZG)
+	return 1.7 * math.Pow(z/p.ZG, p.Alpha)
+}
+
+// ベクトル風速 UGRD, VGRD を倍率 factor で換算します。
+// 風向風速 (WindVectorToDirAndSpeed) の計算前に使用します。
+func (msm *MsmTarget) ScaleWind(factor float64) {
+	for i := range msm.UGRD {
+		msm.UGRD[i] *= factor
+		msm.VGRD[i] *= factor
+	}
+}
@@ -0,0 +1,64 @@
+package arcclimate
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseTerrainCategory(t *testing.T) {
+	for s, want := range map[string]TerrainCategory{"I": TerrainI, "iii": TerrainIII, "5": TerrainV} {
+		c, err := ParseTerrainCategory(s)
+		assert.NoError(t, err, s)
+		assert.Equal(t, want, c, s)
+	}
+	_, err := ParseTerrainCategory("VI")
+	assert.Error(t, err)
+	assert.Equal(t, "IV", TerrainIV.String())
+}
+
+func Test_WindConversion_Factor(t *testing.T) {
+	// MSMと同じ条件(地表面粗度区分II、10m)は換算しない
+	for _, p := range []WindProfile{WindProfilePower, WindProfileLog} {
+		c := &WindConversion{Height: 10, Profile: p, Terrain: TerrainII}
+		assert.NoError(t, c.Validate())
+		assert.InDelta(t, 1.0, c.Factor(), 1e-12, p)
+	}
+
+	// べき法則: Er(30, III) / Er(10, II)
+	c := &WindConversion{Height: 30, Profile: WindProfilePower, Terrain: TerrainIII}
+	want := math.Pow(30.0/450, 0.20) / math.Pow(10.0/350, 0.15)
+	assert.InDelta(t, want, c.Factor(), 1e-12)
+
+	// 高さの下限 Zb
+	c = &WindConversion{Height: 3, Profile: WindProfilePower, Terrain: TerrainIV}
+	assert.InDelta(t, math.Pow(10.0/550, 0.27)/math.Pow(10.0/350, 0.15), c.Factor(), 1e-12)
+
+	// 対数則: 粗度長の指定
+	c = &WindConversion{Height: 20, Profile: WindProfileLog, Roughness: 0.5}
+	assert.NoError(t, c.Validate())
+	want = math.Log(60/0.05) / math.Log(10/0.05) * math.Log(20/0.5) / math.Log(60/0.5)
+	assert.InDelta(t, want, c.Factor(), 1e-12)
+}
+
+func Test_WindConversion_Validate(t *testing.T) {
+	assert.Error(t, (&WindConversion{Height: 0, Profile: WindProfilePower, Terrain: TerrainIII}).Validate())
+	assert.Error(t, (&WindConversion{Height: 10, Profile: WindProfilePower}).Validate())
+	assert.Error(t, (&WindConversion{Height: 10, Profile: WindProfilePower, Terrain: TerrainIII, Roughness: 1}).Validate())
+	assert.Error(t, (&WindConversion{Height: 10, Profile: WindProfileLog}).Validate())
+	assert.Error(t, (&WindConversion{Height: 1, Profile: WindProfileLog, Roughness: 2}).Validate())
+
+	// 粗度長はブレンディング高さ (60m) 未満
+	assert.NoError(t, (&WindConversion{Height: 100, Profile: WindProfileLog, Roughness: 59}).Validate())
+	assert.Error(t, (&WindConversion{Height: 100, Profile: WindProfileLog, Roughness: 60}).Validate())
+	assert.Error(t, (&WindConversion{Height: 100, Profile: WindProfileLog, Roughness: 80}).Validate())
+	assert.Error(t, (&WindConversion{Height: 10, Profile: "linear", Terrain: TerrainIII}).Validate())
+}
+
+func Test_MsmTarget_ScaleWind(t *testing.T) {
+	msm := &MsmTarget{UGRD: []float64{1, -2}, VGRD: []float64{0.5, 4}}
+	msm.ScaleWind(1.5)
+	assert.Equal(t, []float64{1.5, -3}, msm.UGRD)
+	assert.Equal(t, []float64{0.75, 6}, msm.VGRD)
+}
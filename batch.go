@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/akamensky/argparse"
+	"github.com/udawtr/arcclimate-go/arcclimate"
+)
+
+// 地点一覧ファイルの1地点
+type batchSite struct {
+	ID         string   `json:"id"`
+	Lat        *float64 `json:"lat"`
+	Lon        *float64 `json:"lon"`
+	MeshCode   string   `json:"meshcode,omitempty"`   // 標準地域メッシュコード (緯度・経度の代わりにメッシュの中心を使用)
+	Mode       string   `json:"mode,omitempty"`       // 計算モード (空の場合は --mode)
+	Separation string   `json:"separation,omitempty"` // 直散分離の方法 (空の場合は --mode_separate)
+	Elevation  *float64 `json:"elevation,omitempty"`  // 標高 [m] (省略した場合は --mode_elevation で判定)
+	Format     string   `json:"format,omitempty"`     // 出力形式 (空の場合は --file)
+}
+
+// batch サブコマンドの結果一覧(マニフェスト)
+type batchManifest struct {
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Sites     []batchEntry `json:"sites"`
+}
+
+// 地点ごとの結果
+type batchEntry struct {
+	ID       string                  `json:"id"`
+	Lat      float64                 `json:"lat"`
+	Lon      float64                 `json:"lon"`
+	Status   string                  `json:"status"` // "ok" または "failed"
+	Error    string                  `json:"error,omitempty"`
+	Format   string                  `json:"format"`
+	Output   string                  `json:"output,omitempty"`
+	Metadata *arcclimate.RunMetadata `json:"metadata,omitempty"` // 使用した標高・MSMファイルと重み等
+}
+
+// batch サブコマンド: 地点一覧ファイルの各地点の気象データを作成
+func runBatch(args []string) int {
+	parser := argparse.NewParser("arcclimate-go batch", "Creates meteorological data sets for the sites listed in a CSV or JSON file")
+
+	sitesFile := parser.StringPositional(&argparse.Options{
+		Help: "地点一覧ファイル (CSV: id,lat,lon または id,meshcode[,mode,separation,elevation,format] または同じ項目のJSON配列)"})
+
+	outputDir := parser.String("", "output_dir", &argparse.Options{
+		Default: ".",
+		Help:    "出力ディレクトリ"})
+
+	outputTemplate := parser.String("", "output_template", &argparse.Options{
+		Default: "{id}.{ext}",
+		Help:    "出力ファイル名 ({id}, {lat}, {lon}, {format}, {ext} を地点ごとの値に置換)"})
+
+	format := parser.Selector("f", "file", outputFormats, &argparse.Options{
+		Default: "CSV",
+		Help:    "地点一覧で指定しない場合の出力形式 CSV, EPW, HAS or JSON"})
+
+	manifestFile := parser.String("", "manifest", &argparse.Options{
+		Default: "",
+		Help:    "地点ごとの結果を保存するJSONファイル名 (デフォルト: 出力ディレクトリの manifest.json)"})
+
+	workers := parser.Int("", "workers", &argparse.Options{
+		Default: 4,
+		Help:    "同時に計算する地点数・読み込むMSMファイル数"})
+
+	flags := addOptionFlags(&parser.Command)
+
+	if err := parser.Parse(args); err != nil {
+		fmt.Fprint(os.Stderr, parser.Usage(err))
+		return 2
+	}
+	if *sitesFile == "" {
+		fmt.Fprint(os.Stderr, parser.Usage("sites file is required"))
+		return 2
+	}
+
+	opts, code := flags.options()
+	if code != 0 {
+		return code
+	}
+	opts.Parallel = *workers
+
+	// 地点一覧の読み込み
+	list, err := readBatchSites(*sitesFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", *sitesFile, err)
+		return 2
+	}
+
+	// 出力ファイル名
+	outputs := make([]string, len(list))
+	formats := make([]string, len(list))
+	seen := make(map[string]string)
+	for i, s := range list {
+		formats[i] = *format
+		if s.Format != "" {
+			formats[i] = strings.ToUpper(s.Format)
+		}
+		outputs[i] = filepath.Join(*outputDir, expandOutputTemplate(*outputTemplate, s, formats[i]))
+		if id, ok := seen[outputs[i]]; ok {
+			fmt.Fprintf(os.Stderr, "Error: sites %q and %q have the same output %s\n", id, s.ID, outputs[i])
+			return 2
+		}
+		seen[outputs[i]] = s.ID
+	}
+
+	// 各地点の結果
+	entries := make([]batchEntry, len(list))
+	fail := func(i int, err error) {
+		log.Printf("地点 %s の計算に失敗しました: %v", list[i].ID, err)
+		entries[i].Status = "failed"
+		entries[i].Error = err.Error()
+	}
+
+	// 計算する地点 (sites[k] は list[index[k]])
+	sites := []arcclimate.Site{}
+	index := []int{}
+	for i, s := range list {
+		entries[i] = batchEntry{ID: s.ID, Lat: *s.Lat, Lon: *s.Lon, Status: "ok", Format: formats[i]}
+		if !isOutputFormat(formats[i]) {
+			fail(i, fmt.Errorf("unknown output format %q (want CSV, EPW, HAS or JSON)", formats[i]))
+			continue
+		}
+		sites = append(sites, arcclimate.Site{
+			Name:             s.ID,
+			Lat:              *s.Lat,
+			Lon:              *s.Lon,
+			Mode:             arcclimate.Mode(s.Mode),
+			SeparationMethod: arcclimate.SeparationMethod(s.Separation),
+			Elevation:        s.Elevation,
+		})
+		index = append(index, i)
+	}
+
+	// 各地点の計算と保存 (失敗した地点があっても他の地点は続行する)
+	err = arcclimate.InterpolateEach(context.Background(), sites, opts, func(k int, r arcclimate.SiteResult) {
+		i := index[k]
+		if r.Err == nil {
+			r.Err = writeBatchOutput(outputs[i], r.Target, formats[i], r.Site.Lat, r.Site.Lon)
+		}
+		if r.Err != nil {
+			fail(i, r.Err)
+			return
+		}
+		log.Printf("地点 %s 保存: %s", list[i].ID, outputs[i])
+		entries[i].Output = outputs[i]
+		entries[i].Metadata = r.Target.Metadata
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	// 結果一覧の保存
+	manifest := batchManifest{Sites: entries}
+	for _, e := range entries {
+		if e.Status == "ok" {
+			manifest.Succeeded++
+		} else {
+			manifest.Failed++
+		}
+	}
+	path := *manifestFile
+	if path == "" {
+		path = filepath.Join(*outputDir, "manifest.json")
+	}
+	log.Printf("結果一覧保存: %s", path)
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	enc.Encode(manifest)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	log.Printf("計算が終了しました (成功 %d, 失敗 %d)", manifest.Succeeded, manifest.Failed)
+	if manifest.Failed > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %d of %d sites failed (see %s)\n", manifest.Failed, len(entries), path)
+		return 1
+	}
+	return 0
+}
+
+// 出力ファイル名の書式 tmpl を地点 s の値で置換します。
+func expandOutputTemplate(tmpl string, s batchSite, format string) string {
+	return strings.NewReplacer(
+		"{id}", s.ID,
+		"{lat}", strconv.FormatFloat(*s.Lat, 'f', -1, 64),
+		"{lon}", strconv.FormatFloat(*s.Lon, 'f', -1, 64),
+		"{format}", format,
+		"{ext}", strings.ToLower(format),
+	).Replace(tmpl)
+}
+
+// 計算結果 res を出力形式 format でファイル path に保存します。
+func writeBatchOutput(path string, res *arcclimate.MsmTarget, format string, lat float64, lon float64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := writeTarget(&buf, res, format, lat, lon); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// 地点一覧ファイル path を読み込みます。拡張子が .json の場合はJSON配列、それ以外はCSVとして読み込みます。
+func readBatchSites(path string) ([]batchSite, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var list []batchSite
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&list); err != nil {
+			return nil, err
+		}
+	} else {
+		list, err = readBatchSitesCSV(f)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(list) == 0 {
+		return nil, fmt.Errorf("no sites")
+	}
+	ids := make(map[string]bool)
+	for i, s := range list {
+		if s.ID == "" {
+			return nil, fmt.Errorf("site %d: id is required", i+1)
+		}
+		if s.ID == "." || s.ID == ".." || strings.ContainsAny(s.ID, `/\`) {
+			return nil, fmt.Errorf("site %d: invalid id %q", i+1, s.ID)
+		}
+		if ids[s.ID] {
+			return nil, fmt.Errorf("site %d: duplicate id %q", i+1, s.ID)
+		}
+		ids[s.ID] = true
+		if s.MeshCode != "" {
+			if s.Lat != nil || s.Lon != nil {
+				return nil, fmt.Errorf("site %q: specify either lat/lon or meshcode", s.ID)
+			}
+			lat, lon, err := arcclimate.MeshCodeLatLon(s.MeshCode)
+			if err != nil {
+				return nil, fmt.Errorf("site %q: %w", s.ID, err)
+			}
+			list[i].Lat, list[i].Lon = &lat, &lon
+		} else if s.Lat == nil || s.Lon == nil {
+			return nil, fmt.Errorf("site %q: lat and lon (or meshcode) are required", s.ID)
+		}
+	}
+
+	return list, nil
+}
+
+// CSV形式の地点一覧を読み込みます。
+// 1行目は列名 (id と lat,lon または meshcode は必須、mode,separation,elevation,format は省略可) とします。
+func readBatchSitesCSV(r io.Reader) ([]batchSite, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no header")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range records[0] {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "id", "lat", "lon", "meshcode", "mode", "separation", "elevation", "format":
+		default:
+			return nil, fmt.Errorf("unknown column %q", name)
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["id"]; !ok {
+		return nil, fmt.Errorf("column %q is required", "id")
+	}
+	_, hasLat := columns["lat"]
+	_, hasLon := columns["lon"]
+	_, hasMesh := columns["meshcode"]
+	if !(hasLat && hasLon) && !hasMesh {
+		return nil, fmt.Errorf("columns \"lat\" and \"lon\" (or \"meshcode\") are required")
+	}
+
+	list := make([]batchSite, 0, len(records)-1)
+	for line, record := range records[1:] {
+		value := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		number := func(name string) (*float64, error) {
+			v := value(name)
+			if v == "" {
+				return nil, nil
+			}
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid %s %q", line+2, name, v)
+			}
+			return &f, nil
+		}
+
+		s := batchSite{
+			ID:         value("id"),
+			MeshCode:   value("meshcode"),
+			Mode:       value("mode"),
+			Separation: value("separation"),
+			Format:     value("format"),
+		}
+		if s.Lat, err = number("lat"); err != nil {
+			return nil, err
+		}
+		if s.Lon, err = number("lon"); err != nil {
+			return nil, err
+		}
+		if s.Elevation, err = number("elevation"); err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+
+	return list, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/udawtr/arcclimate-go/arcclimate"
+)
+
+// 緯度 lat, 経度 lon の計算に必要なMSMファイル(2011年、一定値)をディレクトリ dir に作成します。
+func writeTestMsm(t *testing.T, dir string, lat float64, lon float64) {
+	var csv bytes.Buffer
+	csv.WriteString("date,TMP,MR,DSWRF_est,DSWRF_msm,Ld,VGRD,UGRD,PRES,APCP01\n")
+	start := time.Date(2011, 1, 1, 0, 0, 0, 0, time.UTC)
+	for h := 0; h < 365*24; h++ {
+		fmt.Fprintf(&csv, "%s,%d,5.0,0.0,0.0,300.0,1.0,-1.0,101325.0,0.0\n",
+			start.Add(time.Duration(h)*time.Hour).Format("2006-01-02 15:04:05"), 10+h%24)
+	}
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(csv.Bytes())
+	zw.Close()
+
+	for _, name := range arcclimate.RequiredMsmList(lat, lon) {
+		if err := os.WriteFile(filepath.Join(dir, name+".csv.gz"), gz.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func Test_readBatchSitesCSV(t *testing.T) {
+	lat, lon := 35.658, 139.741
+	for _, c := range []struct {
+		name string
+		csv  string
+		want []batchSite
+		err  bool
+	}{
+		{name: "lat/lon", csv: "id,lat,lon\ntokyo,35.658,139.741\n",
+			want: []batchSite{{ID: "tokyo", Lat: &lat, Lon: &lon}}},
+		{name: "optional columns", csv: " ID , Lat,Lon,mode,format\na,35.658,139.741,EA,epw\n",
+			want: []batchSite{{ID: "a", Lat: &lat, Lon: &lon, Mode: "EA", Format: "epw"}}},
+		{name: "meshcode", csv: "id,meshcode\nm,53393599\n",
+			want: []batchSite{{ID: "m", MeshCode: "53393599"}}},
+		{name: "short row", csv: "id,lat,lon,elevation\na,35.658,139.741\n",
+			want: []batchSite{{ID: "a", Lat: &lat, Lon: &lon}}},
+		{name: "empty", csv: "", err: true},
+		{name: "unknown column", csv: "id,lat,lon,height\n", err: true},
+		{name: "no id", csv: "lat,lon\n", err: true},
+		{name: "no location", csv: "id,lat\n", err: true},
+		{name: "invalid number", csv: "id,lat,lon\na,north,139.741\n", err: true},
+	} {
+		got, err := readBatchSitesCSV(strings.NewReader(c.csv))
+		if c.err {
+			assert.Error(t, err, c.name)
+			continue
+		}
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.want, got, c.name)
+	}
+}
+
+func Test_readBatchSites(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, content string) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		return path
+	}
+
+	// JSON、メッシュコードはメッシュの中心
+	list, err := readBatchSites(write("sites.json", `[{"id":"a","lat":35.658,"lon":139.741,"format":"csv"},{"id":"m","meshcode":"53393599"}]`))
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, "csv", list[0].Format)
+	assert.InDelta(t, 35.6625, *list[1].Lat, 1e-9)
+	assert.InDelta(t, 139.74375, *list[1].Lon, 1e-9)
+
+	for name, content := range map[string]string{
+		"unknown.json":   `[{"id":"a","lat":35.6,"lon":139.7,"height":3}]`,
+		"duplicate.csv":  "id,lat,lon\na,35.6,139.7\na,35.7,139.7\n",
+		"invalid_id.csv": "id,lat,lon\n../a,35.6,139.7\n",
+		"both.csv":       "id,lat,lon,meshcode\na,35.6,139.7,53393599\n",
+		"missing.csv":    "id,lat,lon,meshcode\na,35.6,,\n",
+		"no_sites.csv":   "id,lat,lon\n",
+		"bad_mesh.csv":   "id,meshcode\na,12\n",
+	} {
+		_, err := readBatchSites(write(name, content))
+		assert.Error(t, err, name)
+	}
+}
+
+func Test_expandOutputTemplate(t *testing.T) {
+	lat, lon := 35.658, 139.741
+	s := batchSite{ID: "tokyo", Lat: &lat, Lon: &lon}
+	assert.Equal(t, "tokyo.csv", expandOutputTemplate("{id}.{ext}", s, "CSV"))
+	assert.Equal(t, "EPW/tokyo_35.658_139.741.epw", expandOutputTemplate("{format}/{id}_{lat}_{lon}.{ext}", s, "EPW"))
+}
+
+// 失敗した地点があっても他の地点は計算し、結果一覧に記録する
+func Test_runBatch(t *testing.T) {
+	msmDir := t.TempDir()
+	writeTestMsm(t, msmDir, 35.658, 139.741)
+
+	dir := t.TempDir()
+	sites := filepath.Join(dir, "sites.csv")
+	assert.NoError(t, os.WriteFile(sites, []byte(
+		"id,lat,lon,format\n"+
+			"csv,35.658,139.741,csv\n"+
+			"epw,35.658,139.741,epw\n"+
+			"no_msm,43.06,141.35,\n"+
+			"bad_format,35.658,139.741,xml\n"), 0644))
+	out := filepath.Join(dir, "out")
+
+	code := runBatch([]string{"batch", sites, "--output_dir", out, "--msm_source", msmDir, "--offline",
+		"--mode_elevation", "mesh", "--start_year", "2011", "--end_year", "2011"})
+	assert.Equal(t, 1, code)
+
+	b, err := os.ReadFile(filepath.Join(out, "manifest.json"))
+	assert.NoError(t, err)
+	var manifest batchManifest
+	assert.NoError(t, json.Unmarshal(b, &manifest))
+	assert.Equal(t, 2, manifest.Succeeded)
+	assert.Equal(t, 2, manifest.Failed)
+
+	status := map[string]batchEntry{}
+	for _, e := range manifest.Sites {
+		status[e.ID] = e
+	}
+	assert.Equal(t, "ok", status["csv"].Status)
+	assert.Equal(t, "CSV", status["csv"].Format)
+	assert.Equal(t, filepath.Join(out, "csv.csv"), status["csv"].Output)
+	assert.NotNil(t, status["csv"].Metadata)
+	assert.Equal(t, "ok", status["epw"].Status)
+	assert.FileExists(t, filepath.Join(out, "epw.epw"))
+	assert.Equal(t, "failed", status["no_msm"].Status)
+	assert.NotEmpty(t, status["no_msm"].Error)
+	assert.Equal(t, "failed", status["bad_format"].Status)
+	assert.Contains(t, status["bad_format"].Error, "unknown output format")
+
+	csv, err := os.ReadFile(filepath.Join(out, "csv.csv"))
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(csv), "date,TMP,MR,"))
+}
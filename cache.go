@@ -0,0 +1,365 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/akamensky/argparse"
+	"github.com/udawtr/arcclimate-go/arcclimate"
+)
+
+// cache サブコマンド: MSMファイルのキャッシュディレクトリの管理
+func runCache(args []string) int {
+	parser := argparse.NewParser("arcclimate-go cache", "Manages the MSM cache directory")
+
+	msmFileDir := parser.String("", "msm_file_dir", &argparse.Options{
+		Default: ".msm_cache",
+		Help:    "MSMファイルの格納ディレクトリ"})
+
+	listCmd := parser.NewCommand("list", "キャッシュしたMSMファイルの一覧を表示")
+
+	verifyCmd := parser.NewCommand("verify", "キャッシュしたMSMファイルをすべて読み込んで検証")
+	verifyRemove := verifyCmd.Flag("", "remove", &argparse.Options{
+		Help: "検証に失敗したファイルを削除する"})
+
+	convertCmd := parser.NewCommand("convert", "キャッシュしたMSMファイルのバイナリキャッシュを作成")
+
+	pruneCmd := parser.NewCommand("prune", "最終使用日時の古いMSMファイルを削除")
+	pruneMaxSize := pruneCmd.String("", "max_size", &argparse.Options{
+		Default: "",
+		Help:    "キャッシュの合計サイズの上限 (例: 500M, 2G)"})
+	pruneMaxAge := pruneCmd.String("", "max_age", &argparse.Options{
+		Default: "",
+		Help:    "最終使用日時からの経過時間の上限 (例: 30d, 720h)"})
+	pruneDryRun := pruneCmd.Flag("", "dry_run", &argparse.Options{
+		Help: "削除対象を表示するのみで削除しない"})
+
+	prefetchCmd := parser.NewCommand("prefetch", "指定した範囲・地点の計算に必要なMSMファイルを事前にダウンロード")
+	prefetchBBox := prefetchCmd.String("", "bbox", &argparse.Options{
+		Default: "",
+		Help:    "対象範囲 南端緯度,西端経度,北端緯度,東端経度"})
+	prefetchPoints := prefetchCmd.StringList("", "point", &argparse.Options{
+		Help: "対象地点 緯度,経度 (複数指定可)"})
+	prefetchSource := prefetchCmd.String("", "msm_source", &argparse.Options{
+		Default: "",
+		Help:    "MSMファイルの取得元 既定のダウンロード元=空(デフォルト), ミラー=URL(カンマ区切りで複数指定可), ローカル=ディレクトリ"})
+	prefetchArchives := prefetchCmd.StringList("", "msm_archive", &argparse.Options{
+		Help: "期間別アーカイブ [名前=]取得元 (複数指定可、アーカイブ名のサブディレクトリに保存)"})
+	prefetchNeighborhood := prefetchCmd.Selector("", "neighborhood", []string{"4", "16"}, &argparse.Options{
+		Default: "4",
+		Help:    "空間補間に使用する周囲のMSM地点数 2×2=4(デフォルト), 4×4=16 (--neighborhood 16 で計算する場合は16)"})
+	prefetchParallel := prefetchCmd.Int("", "parallel", &argparse.Options{
+		Default: 4,
+		Help:    "同時にダウンロードするファイル数"})
+
+	if err := parser.Parse(args); err != nil {
+		fmt.Fprint(os.Stderr, parser.Usage(err))
+		return 2
+	}
+
+	switch {
+	case listCmd.Happened():
+		return cacheList(*msmFileDir)
+	case verifyCmd.Happened():
+		return cacheVerify(*msmFileDir, *verifyRemove)
+	case convertCmd.Happened():
+		return cacheConvert(*msmFileDir)
+	case pruneCmd.Happened():
+		maxSize, err := parseByteSize(*pruneMaxSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --max_size: %v\n", err)
+			return 2
+		}
+		maxAge, err := parseAge(*pruneMaxAge)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --max_age: %v\n", err)
+			return 2
+		}
+		if maxSize <= 0 && maxAge <= 0 {
+			fmt.Fprintln(os.Stderr, "Error: --max_size or --max_age is required")
+			return 2
+		}
+		return cachePrune(*msmFileDir, maxSize, maxAge, *pruneDryRun)
+	case prefetchCmd.Happened():
+		neighborhood, _ := strconv.Atoi(*prefetchNeighborhood)
+		msmList, err := prefetchList(*prefetchBBox, *prefetchPoints, neighborhood)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+		if len(*prefetchArchives) > 0 {
+			if *prefetchSource != "" {
+				fmt.Fprintln(os.Stderr, "Error: --msm_source and --msm_archive cannot be used together")
+				return 2
+			}
+			code := 0
+			for _, spec := range *prefetchArchives {
+				a, err := arcclimate.ParseMsmArchive(spec)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Error: --msm_archive: %v\n", err)
+					return 2
+				}
+				fmt.Printf("archive %s\n", a.Name)
+				if c := cachePrefetch(filepath.Join(*msmFileDir, a.Name), a.Source, msmList, *prefetchParallel); c != 0 {
+					code = c
+				}
+			}
+			return code
+		}
+		src, err := arcclimate.ParseMsmSource(*prefetchSource)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 2
+		}
+		return cachePrefetch(*msmFileDir, src, msmList, *prefetchParallel)
+	}
+
+	fmt.Fprint(os.Stderr, parser.Usage(nil))
+	return 2
+}
+
+func cacheList(dir string) int {
+	entries, err := arcclimate.ListMsmCache(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSIZE\tLAST USED\tSTART\tEND\tROWS\tBINARY")
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+		start, end, rows := "-", "-", "-"
+		if e.Manifest != nil {
+			start = e.Manifest.Start.Format("2006-01-02 15:04")
+			end = e.Manifest.End.Format("2006-01-02 15:04")
+			rows = strconv.Itoa(e.Manifest.Rows)
+		}
+		binary := "-"
+		if e.Binary {
+			binary = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.DisplayName(), formatByteSize(e.Size), e.LastUsed.Format("2006-01-02 15:04"), start, end, rows, binary)
+	}
+	w.Flush()
+	fmt.Printf("%d files, %s\n", len(entries), formatByteSize(total))
+
+	return 0
+}
+
+func cacheVerify(dir string, remove bool) int {
+	entries, err := arcclimate.ListMsmCache(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	failed := 0
+	for _, e := range entries {
+		m, err := arcclimate.VerifyCachedMsm(e.Path)
+		if err == nil {
+			fmt.Printf("OK  %s (%d rows, %s - %s)\n", e.DisplayName(), m.Rows,
+				m.Start.Format("2006-01-02 15:04"), m.End.Format("2006-01-02 15:04"))
+			continue
+		}
+
+		failed++
+		fmt.Printf("NG  %s: %v\n", e.DisplayName(), err)
+		if remove {
+			if err := arcclimate.RemoveCachedMsm(e); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+		}
+	}
+
+	fmt.Printf("%d files, %d failed\n", len(entries), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func cacheConvert(dir string) int {
+	entries, err := arcclimate.ListMsmCache(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	failed := 0
+	for _, e := range entries {
+		if err := arcclimate.ConvertCachedMsm(e.Path); err != nil {
+			failed++
+			fmt.Printf("NG  %s: %v\n", e.DisplayName(), err)
+			continue
+		}
+		fmt.Printf("OK  %s\n", e.DisplayName())
+	}
+
+	fmt.Printf("%d files, %d failed\n", len(entries), failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func cachePrune(dir string, maxSize int64, maxAge time.Duration, dryRun bool) int {
+	entries, err := arcclimate.ListMsmCache(dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	var freed int64
+	for _, e := range arcclimate.PruneCandidates(entries, maxSize, maxAge, time.Now()) {
+		fmt.Printf("remove %s (%s, last used %s)\n", e.DisplayName(), formatByteSize(e.Size), e.LastUsed.Format("2006-01-02 15:04"))
+		if !dryRun {
+			if err := arcclimate.RemoveCachedMsm(e); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+		}
+		freed += e.Size
+	}
+	fmt.Printf("%s freed\n", formatByteSize(freed))
+
+	return 0
+}
+
+func cachePrefetch(dir string, src arcclimate.MsmSource, msmList []string, parallel int) int {
+	cached := &arcclimate.CachedMsmSource{
+		Source:    src,
+		Dir:       dir,
+		UseCache:  true,
+		SaveCache: true,
+	}
+
+	errs := arcclimate.PrefetchMsm(context.Background(), msmList, cached, parallel)
+	for _, name := range msmList {
+		if err, ok := errs[name]; ok {
+			fmt.Printf("NG  %s: %v\n", name, err)
+		}
+	}
+	fmt.Printf("%d files, %d failed\n", len(msmList), len(errs))
+
+	if len(errs) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// 範囲 bbox と地点 points の周囲 n 地点による計算のために事前に取得するMSMファイルの一覧を作成します。
+func prefetchList(bbox string, points []string, n int) ([]string, error) {
+	seen := make(map[string]bool)
+	list := []string{}
+	add := func(names []string) {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				list = append(list, name)
+			}
+		}
+	}
+
+	if bbox != "" {
+		v, err := parseFloats(bbox, 4)
+		if err != nil {
+			return nil, fmt.Errorf("--bbox: %w", err)
+		}
+		add(arcclimate.RequiredMsmListInBoundsN(v[0], v[1], v[2], v[3], n))
+	}
+
+	for _, point := range points {
+		v, err := parseFloats(point, 2)
+		if err != nil {
+			return nil, fmt.Errorf("--point: %w", err)
+		}
+		add(arcclimate.RequiredMsmListN(v[0], v[1], n))
+	}
+
+	if len(list) == 0 {
+		return nil, fmt.Errorf("--bbox or --point is required")
+	}
+
+	return list, nil
+}
+
+// カンマ区切りの n 個の数値 s を読み取ります。
+func parseFloats(s string, n int) ([]float64, error) {
+	fields := strings.Split(s, ",")
+	if len(fields) != n {
+		return nil, fmt.Errorf("%q: expected %d comma separated values", s, n)
+	}
+	v := make([]float64, n)
+	for i, f := range fields {
+		var err error
+		v[i], err = strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", s, err)
+		}
+	}
+	return v, nil
+}
+
+// "500M" や "2G" のようなサイズの指定 s をバイト数に変換します。空の場合は0を返します。
+func parseByteSize(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	unit := int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		unit = 1 << 10
+	case "M":
+		unit = 1 << 20
+	case "G":
+		unit = 1 << 30
+	}
+	if unit > 1 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v * float64(unit)), nil
+}
+
+// "30d" や "720h" のような期間の指定 s を変換します。空の場合は0を返します。
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(strings.TrimSuffix(s, "d"), 64)
+		if err != nil || days < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(days * 24 * float64(time.Hour)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
+
+func formatByteSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
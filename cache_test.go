@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/udawtr/arcclimate-go/arcclimate"
+)
+
+func Test_prefetchList(t *testing.T) {
+	list, err := prefetchList("", []string{"35.658,139.741"}, arcclimate.Neighborhood4)
+	assert.NoError(t, err)
+	assert.Equal(t, arcclimate.RequiredMsmList(35.658, 139.741), list)
+
+	// --neighborhood 16 で計算する地点は外周の地点も取得する
+	list, err = prefetchList("", []string{"35.658,139.741", "35.66,139.742"}, arcclimate.Neighborhood16)
+	assert.NoError(t, err)
+	assert.Equal(t, arcclimate.RequiredMsmListN(35.658, 139.741, arcclimate.Neighborhood16), list)
+
+	list, err = prefetchList("35.658,139.741,35.758,139.801", nil, arcclimate.Neighborhood16)
+	assert.NoError(t, err)
+	assert.Subset(t, list, arcclimate.RequiredMsmListN(35.758, 139.801, arcclimate.Neighborhood16))
+
+	for _, c := range []struct {
+		bbox   string
+		points []string
+	}{{"", nil}, {"35.6,139.7", nil}, {"", []string{"35.6"}}, {"", []string{"north,139.7"}}} {
+		_, err := prefetchList(c.bbox, c.points, arcclimate.Neighborhood4)
+		assert.Error(t, err, c)
+	}
+}
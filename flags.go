@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/akamensky/argparse"
+	"github.com/udawtr/arcclimate-go/arcclimate"
+)
+
+// 計算条件のコマンドライン引数 (1地点の計算と batch, serve サブコマンドで共通)
+type optionFlags struct {
+	startYear     *int
+	endYear       *int
+	mode          *string
+	modeEle       *string
+	disableEst    *bool
+	msmFileDir    *string
+	disableCache  *bool
+	binaryCache   *bool
+	msmSource     *string
+	msmArchives   *[]string
+	msmPrecedence *string
+	offline       *bool
+	modeSep       *string
+	eleWarning    *float64
+	interp        *string
+	idwPower      *float64
+	neighborhood  *string
+	excludeSea    *bool
+	lapseRate     *string
+	lapseRateFile *string
+	demTileDir    *string
+	windHeight    *float64
+	windProfile   *string
+	terrain       *string
+	roughness     *float64
+	windDir       *string
+	windProject   *bool
+	windCalm      *float64
+	psychro       *bool
+}
+
+// 計算条件のコマンドライン引数を cmd に登録します。
+func addOptionFlags(cmd *argparse.Command) *optionFlags {
+	f := &optionFlags{}
+
+	f.startYear = cmd.Int("", "start_year", &argparse.Options{
+		Default: 2011,
+		Help:    "出力する気象データの開始年（標準年データの検討期間も兼ねる）"})
+
+	f.endYear = cmd.Int("", "end_year", &argparse.Options{
+		Default: 2020,
+		Help:    "出力する気象データの終了年（標準年データの検討期間も兼ねる）"})
+
+	f.mode = cmd.Selector("", "mode", []string{"normal", "EA"}, &argparse.Options{
+		Default: "normal",
+		Help:    "計算モードの指定 標準=normal(デフォルト), 標準年=EA"})
+
+	f.modeEle = cmd.Selector("", "mode_elevation", []string{"mesh", "api", "tile"}, &argparse.Options{
+		Default: "api",
+		Help:    "標高判定方法 API=api(デフォルト), メッシュデータ=mesh, 国土地理院の標高タイル=tile(--dem_tile_dir が必要)"})
+
+	f.demTileDir = cmd.String("", "dem_tile_dir", &argparse.Options{
+		Help: "国土地理院の標高タイル(dem5a_png/{z}/{x}/{y}.png, dem10b/{z}/{x}/{y}.txt 等)の格納ディレクトリ"})
+
+	f.disableEst = cmd.Flag("", "disable_est", &argparse.Options{
+		Help: "標準年データの検討に日射量の推計値を使用しない（使用しない場合2018年以降のデータのみで作成）"})
+
+	f.msmFileDir = cmd.String("", "msm_file_dir", &argparse.Options{
+		Default: ".msm_cache",
+		Help:    "MSMファイルの格納ディレクトリ"})
+
+	f.disableCache = cmd.Flag("", "disable_cache", &argparse.Options{
+		Help: "MSMファイルを格納ディレクトリに保存・再利用しない"})
+
+	f.binaryCache = cmd.Flag("", "binary_cache", &argparse.Options{
+		Help: "解析済みのMSMデータをバイナリ形式でも格納ディレクトリに保存し、次回以降の読み込みを高速化する"})
+
+	f.msmSource = cmd.String("", "msm_source", &argparse.Options{
+		Default: "",
+		Help:    "MSMファイルの取得元 既定のダウンロード元=空(デフォルト), ミラー=URL(カンマ区切りで複数指定可), ローカル=ディレクトリ"})
+
+	f.msmArchives = cmd.StringList("", "msm_archive", &argparse.Options{
+		Help: "結合する期間別アーカイブ [名前=]取得元 (古い順に複数指定、取得元は --msm_source と同じ形式)"})
+
+	f.msmPrecedence = cmd.Selector("", "msm_precedence", []string{"first", "last"}, &argparse.Options{
+		Default: "last",
+		Help:    "アーカイブの期間が重複した場合に優先するアーカイブ 後に指定=last(デフォルト), 先に指定=first"})
+
+	f.offline = cmd.Flag("", "offline", &argparse.Options{
+		Help: "ネットワークを使用せず、格納ディレクトリのMSMファイルと3次メッシュの平均標高のみで計算する"})
+
+	f.modeSep = cmd.Selector("", "mode_separate", []string{"Nagata", "Watanabe", "Erbs", "Udagawa", "Perez"}, &argparse.Options{
+		Default: "Perez",
+		Help:    "直散分離の方法"})
+
+	f.interp = cmd.Selector("", "interpolation", []string{"idw", "bilinear", "nearest", "bicubic"}, &argparse.Options{
+		Default: "idw",
+		Help:    "周囲のMSMの空間補間の方法 逆距離加重=idw(デフォルト), 双線形補間=bilinear, 最近傍=nearest, 双3次補間=bicubic(16地点のみ)"})
+
+	f.idwPower = cmd.Float("", "idw_power", &argparse.Options{
+		Default: 1.0,
+		Help:    "逆距離加重(idw)の距離のべき数"})
+
+	f.neighborhood = cmd.Selector("", "neighborhood", []string{"4", "16"}, &argparse.Options{
+		Default: "4",
+		Help:    "空間補間に使用する周囲のMSM地点数 2×2=4(デフォルト), 4×4=16"})
+
+	f.excludeSea = cmd.Flag("", "exclude_sea", &argparse.Options{
+		Help: "海上のMSM地点(標高0m)を空間補間から除外する"})
+
+	f.lapseRate = cmd.String("", "lapse_rate", &argparse.Options{
+		Default: "constant",
+		Help:    "標高補正の気温減率[℃/m] 0.0065℃/m=constant(デフォルト), 一定値=constant:値, 月別=monthly:1月の値,...,12月の値, 補正しない=none"})
+
+	f.lapseRateFile = cmd.String("", "lapse_rate_file", &argparse.Options{
+		Help: "標高補正の気温減率[℃/m]の表 (CSV, 12行×1列=月別, 12行×24列=月別・時刻別)。指定した場合は --lapse_rate より優先する"})
+
+	f.windHeight = cmd.Float("", "wind_height", &argparse.Options{
+		Default: 0.0,
+		Help:    "MSMの地上10mの風速を換算する高さ[m] (0の場合は換算しない)"})
+
+	f.windProfile = cmd.Selector("", "wind_profile", []string{"power", "log"}, &argparse.Options{
+		Default: "power",
+		Help:    "風速の換算に用いる鉛直分布 べき法則(建築基準法)=power(デフォルト), 対数則=log"})
+
+	f.terrain = cmd.Selector("", "terrain", []string{"I", "II", "III", "IV", "V"}, &argparse.Options{
+		Default: "III",
+		Help:    "風速の換算に用いる推計対象地点の地表面粗度区分 (デフォルト III)"})
+
+	f.roughness = cmd.Float("", "roughness", &argparse.Options{
+		Default: 0.0,
+		Help:    "風速の換算(対数則)に用いる粗度長[m] (0の場合は地表面粗度区分の目安値)"})
+
+	f.windDir = cmd.String("", "wind_direction", &argparse.Options{
+		Help: "風向の方位 continuous (丸めない), 8, 16, 36 (省略時は出力形式ごとの既定値 CSV/JSON/HAS=16方位・射影あり, EPW=continuous)"})
+
+	f.windProject = cmd.Flag("", "wind_projection", &argparse.Options{
+		Help: "丸めた方位へ風速を射影する (風速×cos(丸めた方位との差))。--wind_direction と共に指定する"})
+
+	f.windCalm = cmd.Float("", "wind_calm", &argparse.Options{
+		Default: 0.0,
+		Help:    "静穏(無風)とみなす風速の上限[m/s] HAS形式の風向 0 に使用 (デフォルト 0)"})
+
+	f.psychro = cmd.Flag("", "psychrometrics", &argparse.Options{
+		Help: "湿球温度(TWB)、絶対湿度(HR)、比エンタルピー(ENTH)、湿り空気の密度(RHO)、比容積(SV)の列を出力する (CSV, JSON)"})
+
+	f.eleWarning = cmd.Float("", "elevation_warning", &argparse.Options{
+		Default: 300.0,
+		Help:    "推計対象地点と周囲のMSMの標高差がこの値[m]を超える場合に警告する (0の場合は確認しない)"})
+
+	return f
+}
+
+// コマンドライン引数から計算条件(緯度経度を除く)を作成します。
+// 引数が不正な場合はエラーを表示し、0 以外の終了コードを返します。
+func (f *optionFlags) options() (arcclimate.Options, int) {
+	neighborhood, _ := strconv.Atoi(*f.neighborhood)
+	if *f.interp == "bicubic" && neighborhood != arcclimate.Neighborhood16 {
+		fmt.Fprintln(os.Stderr, "Error: --interpolation bicubic requires --neighborhood 16")
+		return arcclimate.Options{}, 2
+	}
+
+	if !(*f.idwPower > 0) || math.IsInf(*f.idwPower, 0) {
+		fmt.Fprintf(os.Stderr, "Error: --idw_power must be positive (got %v)\n", *f.idwPower)
+		return arcclimate.Options{}, 2
+	}
+
+	// 標高の取得元
+	modeEle := arcclimate.ElevationMode(*f.modeEle)
+	var eleProvider arcclimate.ElevationProvider
+	if modeEle == arcclimate.ElevationTile {
+		if *f.demTileDir == "" {
+			fmt.Fprintln(os.Stderr, "Error: --mode_elevation tile requires --dem_tile_dir")
+			return arcclimate.Options{}, 2
+		}
+		// 標高タイルが無い地点は3次メッシュの平均標高を使用する
+		modeEle = arcclimate.ElevationMesh
+		eleProvider = arcclimate.NewDEMTileElevationProvider(*f.demTileDir)
+	} else if *f.demTileDir != "" {
+		fmt.Fprintln(os.Stderr, "Error: --dem_tile_dir requires --mode_elevation tile")
+		return arcclimate.Options{}, 2
+	}
+
+	// 風速の高さ・地表面粗度の換算
+	var wind *arcclimate.WindConversion
+	if *f.windHeight != 0 || *f.roughness != 0 {
+		terrain, _ := arcclimate.ParseTerrainCategory(*f.terrain)
+		wind = &arcclimate.WindConversion{
+			Height:    *f.windHeight,
+			Profile:   arcclimate.WindProfile(*f.windProfile),
+			Terrain:   terrain,
+			Roughness: *f.roughness,
+		}
+		if err := wind.Validate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --wind_height: %v\n", err)
+			return arcclimate.Options{}, 2
+		}
+	}
+
+	// 風向風速の計算条件
+	var windDir *arcclimate.WindDirection
+	if *f.windDir != "" {
+		mode, err := arcclimate.ParseWindDirection(*f.windDir, *f.windProject)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --wind_direction: %v\n", err)
+			return arcclimate.Options{}, 2
+		}
+		windDir = &mode
+	} else if *f.windProject {
+		fmt.Fprintln(os.Stderr, "Error: --wind_projection requires --wind_direction")
+		return arcclimate.Options{}, 2
+	}
+
+	// 標高補正の気温減率
+	lapse, err := f.lapse()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return arcclimate.Options{}, 2
+	}
+
+	// MSMファイルの取得元
+	src, err := arcclimate.ParseMsmSource(*f.msmSource)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return arcclimate.Options{}, 2
+	}
+
+	// 期間別アーカイブの結合
+	var archives []arcclimate.MsmArchive
+	for _, spec := range *f.msmArchives {
+		a, err := arcclimate.ParseMsmArchive(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --msm_archive: %v\n", err)
+			return arcclimate.Options{}, 2
+		}
+		archives = append(archives, a)
+	}
+	if len(archives) > 0 {
+		if *f.msmSource != "" {
+			fmt.Fprintln(os.Stderr, "Error: --msm_source and --msm_archive cannot be used together")
+			return arcclimate.Options{}, 2
+		}
+		src = nil
+	}
+
+	// ダウンロードしたMSMファイルは格納ディレクトリに保存して再利用する(ローカルディレクトリから読み込む場合を除く)
+	useCache := !*f.disableCache
+
+	return arcclimate.Options{
+		StartYear:        *f.startYear,
+		EndYear:          *f.endYear,
+		Mode:             arcclimate.Mode(*f.mode),
+		ElevationMode:    modeEle,
+		SeparationMethod: arcclimate.SeparationMethod(*f.modeSep),
+		UseEst:           !*f.disableEst, // 期間の確認は Options.Validate で行う
+		Source:           src,
+		Archives:         archives,
+		Precedence:       arcclimate.MsmPrecedence(*f.msmPrecedence),
+		UseCache:         useCache,
+		SaveCache:        useCache,
+		MsmFileDir:       *f.msmFileDir,
+		BinaryCache:      *f.binaryCache,
+		Offline:          *f.offline,
+		ElevationWarning: *f.eleWarning,
+		Interpolation:    arcclimate.InterpolationMethod(*f.interp),
+		IDWPower:         *f.idwPower,
+		Neighborhood:     neighborhood,
+		ExcludeSea:       *f.excludeSea,
+		LapseRate:        lapse,
+		Wind:             wind,
+		WindDirection:    windDir,
+		WindCalm:         *f.windCalm,
+		Psychrometrics:   *f.psychro,
+
+		ElevationProvider: eleProvider,
+	}, 0
+}
+
+// コマンドライン引数から標高補正の気温減率を作成します。
+func (f *optionFlags) lapse() (arcclimate.LapseRate, error) {
+	if *f.lapseRateFile == "" {
+		lapse, err := arcclimate.ParseLapseRate(*f.lapseRate)
+		if err != nil {
+			return nil, fmt.Errorf("--lapse_rate: %w", err)
+		}
+		return lapse, nil
+	}
+
+	file, err := os.Open(*f.lapseRateFile)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lapse, err := arcclimate.ReadLapseRateTable(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", *f.lapseRateFile, err)
+	}
+	return lapse, nil
+}
+
+// 出力形式
+var outputFormats = []string{"CSV", "EPW", "HAS", "JSON"}
+
+// 計算結果 res を出力形式 format (CSV, EPW, HAS or JSON) で書き込みます。
+func writeTarget(buf *bytes.Buffer, res *arcclimate.MsmTarget, format string, lat float64, lon float64) error {
+	switch format {
+	case "CSV":
+		res.ToCSV(buf)
+	case "EPW":
+		res.ToEPW(buf, lat, lon)
+	case "HAS":
+		res.ToHAS(buf)
+	case "JSON":
+		return res.ToJSON(buf)
+	default:
+		return fmt.Errorf("unknown output format %q (want CSV, EPW, HAS or JSON)", format)
+	}
+	return nil
+}
+
+// format が出力形式か
+func isOutputFormat(format string) bool {
+	for _, f := range outputFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/akamensky/argparse"
+	"github.com/stretchr/testify/assert"
+)
+
+// コマンドライン引数 args から計算条件を作成します。
+func parseOptionFlags(t *testing.T, args ...string) (*optionFlags, int) {
+	parser := argparse.NewParser("arcclimate-go", "")
+	f := addOptionFlags(&parser.Command)
+	if err := parser.Parse(append([]string{"arcclimate-go"}, args...)); err != nil {
+		t.Fatal(err)
+	}
+	_, code := f.options()
+	return f, code
+}
+
+// --idw_power は正の値のみ受け付ける (0 は Options の既定値を表すため指定できない)
+func Test_optionFlags_IDWPower(t *testing.T) {
+	for _, c := range []struct {
+		value string
+		code  int
+	}{{"1", 0}, {"2.5", 0}, {"0", 2}, {"-1", 2}, {"NaN", 2}, {"Inf", 2}} {
+		_, code := parseOptionFlags(t, "--idw_power", c.value)
+		assert.Equal(t, c.code, code, c.value)
+	}
+}
+
+// --disable_est はそのまま UseEst に反映し、期間は Options.Validate が確認する
+func Test_optionFlags_DisableEst(t *testing.T) {
+	for _, c := range []struct {
+		args  []string
+		valid bool
+	}{
+		{[]string{"--mode", "EA", "--disable_est", "--start_year", "2011", "--end_year", "2017"}, false},
+		{[]string{"--mode", "EA", "--disable_est", "--start_year", "2011", "--end_year", "2018"}, true},
+		{[]string{"--mode", "EA", "--disable_est", "--start_year", "2018", "--end_year", "2020"}, true},
+	} {
+		f, code := parseOptionFlags(t, c.args...)
+		assert.Equal(t, 0, code, c.args)
+		opts, _ := f.options()
+		assert.False(t, opts.UseEst, c.args)
+
+		opts.Lat, opts.Lon = 35.658, 139.741
+		assert.Equal(t, c.valid, opts.Validate() == nil, c.args)
+	}
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -14,6 +15,18 @@ import (
 func main() {
 	log.SetFlags(log.Lmicroseconds)
 
+	// サブコマンド
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "cache":
+			os.Exit(runCache(os.Args[1:]))
+		case "batch":
+			os.Exit(runBatch(os.Args[1:]))
+		case "serve":
+			os.Exit(runServe(os.Args[1:]))
+		}
+	}
+
 	// コマンドライン引数の処理
 	parser := argparse.NewParser("ArcClimate", "Creates a design meteorological data set for any specified point")
 
@@ -29,81 +42,67 @@ func main() {
 		Default: "",
 		Help:    "保存ファイルパス"})
 
-	startYear := parser.Int("", "start_year", &argparse.Options{
-		Default: 2011,
-		Help:    "出力する気象データの開始年（標準年データの検討期間も兼ねる）"})
-
-	endYear := parser.Int("", "end_year", &argparse.Options{
-		Default: 2020,
-		Help:    "出力する気象データの終了年（標準年データの検討期間も兼ねる）"})
+	format := parser.Selector("f", "file", outputFormats, &argparse.Options{
+		Default: "CSV",
+		Help:    "出力形式 CSV, EPW, HAS or JSON"})
 
-	mode := parser.Selector("", "mode", []string{"normal", "EA"}, &argparse.Options{
-		Default: "normal",
-		Help:    "計算モードの指定 標準=normal(デフォルト), 標準年=EA"})
+	metadataFile := parser.String("", "metadata", &argparse.Options{
+		Default: "",
+		Help:    "計算条件(使用した標高・MSMファイル等)をJSON形式で保存するファイル名"})
 
-	format := parser.Selector("f", "file", []string{"CSV", "EPW", "HAS"}, &argparse.Options{
-		Default: "CSV",
-		Help:    "出力形式 CSV, EPW or HAS"})
+	spreadFile := parser.String("", "spread", &argparse.Options{
+		Default: "",
+		Help:    "周囲のMSM地点の標高補正後の値とばらつき(最小・最大・重み付き標準偏差)をCSV形式で保存するファイル名 (標準年では使用不可)"})
 
-	modeEle := parser.Selector("", "mode_elevation", []string{"mesh", "api"}, &argparse.Options{
-		Default: "api",
-		Help:    "標高判定方法 API=api(デフォルト), メッシュデータ=mesh"})
+	meshcode := parser.String("", "meshcode", &argparse.Options{
+		Default: "",
+		Help:    "推計対象地点の標準地域メッシュコード (1次～3次、2分の1～8分の1地域メッシュ。メッシュの中心を対象地点とする。緯度・経度の代わりに指定)"})
 
-	disableEst := parser.Flag("", "disable_est", &argparse.Options{
-		Help: "標準年データの検討に日射量の推計値を使用しない（使用しない場合2018年以降のデータのみで作成）"})
+	amedas := parser.String("", "amedas", &argparse.Options{
+		Default: "",
+		Help:    "推計対象地点のアメダス観測所番号 (観測所の位置・標高を使用する。緯度・経度の代わりに指定)"})
 
-	msmFileDir := parser.String("", "msm_file_dir", &argparse.Options{
-		Default: ".msm_cache",
-		Help:    "MSMファイルの格納ディレクトリ"})
+	amedasTable := parser.String("", "amedas_table", &argparse.Options{
+		Default: "",
+		Help:    "アメダス観測所の一覧 (CSV: id,name,lat,lon,elevation)。省略した場合は組み込みの一覧(各地の気象台)を使用"})
 
-	modeSep := parser.Selector("", "mode_separate", []string{"Nagata", "Watanabe", "Erbs", "Udagawa", "Perez"}, &argparse.Options{
-		Default: "Perez",
-		Help:    "直散分離の方法"})
+	flags := addOptionFlags(&parser.Command)
 
 	err := parser.Parse(os.Args)
 	if err != nil {
-		fmt.Print(parser.Usage(err))
+		fmt.Fprint(os.Stderr, parser.Usage(err))
+		os.Exit(2)
 	}
 
 	// MSMフォルダの作成
 	// os.MkdirAll(*msmFileDir, os.ModePerm)
 
-	// EA方式かつ日射量の推計値を使用しない場合に開始年が2018年以上となっているか確認
-	if *mode == "EA" {
-		if *disableEst {
-			if *startYear < 2018 {
-				log.Printf("--disable_estを設定した場合は開始年を2018年以降にする必要があります")
-				fmt.Fprintln(os.Stderr, "Error: If \"disable_est\" is set, the start year must be 2018 or later")
-				os.Exit(1)
-			} else {
-				*disableEst = false
-			}
-		}
+	opts, code := flags.options()
+	if code != 0 {
+		os.Exit(code)
+	}
+
+	opts.Spread = *spreadFile != ""
+
+	// 推計対象地点
+	opts.Lat = *lat
+	opts.Lon = *lon
+	if code := setTargetPoint(&opts, parser, *meshcode, *amedas, *amedasTable); code != 0 {
+		os.Exit(code)
 	}
 
 	// 補間処理 (0.3s)
-	res := arcclimate.Interpolate(
-		*lat,
-		*lon,
-		*startYear,
-		*endYear,
-		*modeEle,
-		*mode,
-		!*disableEst,
-		*modeSep,
-		false,
-		false,
-		*msmFileDir,
-	)
+	res, err := arcclimate.InterpolateWithOptions(context.Background(), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
 	// 保存
 	var buf *bytes.Buffer = bytes.NewBuffer([]byte{})
-	if *format == "CSV" {
-		res.ToCSV(buf)
-	} else if *format == "EPW" {
-		res.ToEPW(buf, *lat, *lon)
-	} else if *format == "HAS" {
-		res.ToHAS(buf)
+	if err := writeTarget(buf, res, *format, opts.Lat, opts.Lon); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
 
 	if *filename == "" {
@@ -112,9 +111,103 @@ func main() {
 		log.Printf("CSV保存: %s", *filename)
 		err := os.WriteFile(*filename, buf.Bytes(), os.ModePerm)
 		if err != nil {
-			panic(err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// 計算条件の保存
+	if *metadataFile != "" {
+		log.Printf("計算条件保存: %s", *metadataFile)
+		var meta bytes.Buffer
+		res.Metadata.WriteJSON(&meta)
+		err := os.WriteFile(*metadataFile, meta.Bytes(), 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// 周囲のMSM地点のばらつきの保存
+	if *spreadFile != "" {
+		log.Printf("ばらつき保存: %s", *spreadFile)
+		var spread bytes.Buffer
+		res.Spread.ToCSV(&spread)
+		err := os.WriteFile(*spreadFile, spread.Bytes(), 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
 	}
 
 	log.Printf("計算が終了しました")
 }
+
+// 3次メッシュコード meshcode またはアメダス観測所番号 amedas が指定された場合は、推計対象地点とします。
+// 観測所の場合は観測所の標高を推計対象地点の標高とします。
+// 引数が不正な場合はエラーを表示し、0 以外の終了コードを返します。
+func setTargetPoint(opts *arcclimate.Options, parser *argparse.Parser, meshcode string, amedas string, amedasTable string) int {
+	if meshcode == "" && amedas == "" {
+		if amedasTable != "" {
+			fmt.Fprintln(os.Stderr, "Error: --amedas_table requires --amedas")
+			return 2
+		}
+		return 0
+	}
+
+	// 緯度・経度との同時指定は不可
+	positional := meshcode != "" && amedas != ""
+	for _, arg := range parser.GetArgs() {
+		if arg.GetPositional() && arg.GetParsed() {
+			positional = true
+		}
+	}
+	if positional {
+		fmt.Fprintln(os.Stderr, "Error: specify only one of lat/lon, --meshcode and --amedas")
+		return 2
+	}
+
+	if meshcode != "" {
+		if err := opts.SetMeshCode(meshcode); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: --meshcode: %v\n", err)
+			return 2
+		}
+		log.Printf("3次メッシュ %s の中心 (%f, %f) で計算します", meshcode, opts.Lat, opts.Lon)
+		return 0
+	}
+
+	table, err := readAmedasTable(amedasTable)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --amedas_table: %v\n", err)
+		return 2
+	}
+	st, err := table.Lookup(amedas)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: --amedas: %v\n", err)
+		if amedasTable == "" {
+			// 組み込みの一覧は主な観測所のみ
+			fmt.Fprintln(os.Stderr, "The built-in table lists the main observatories only; pass JMA's station list with --amedas_table amedastable.json")
+		}
+		return 2
+	}
+	opts.SetAmedasStation(st)
+	log.Printf("アメダス観測所 %s %s (%f, %f) の標高 %fm で計算します", st.ID, st.Name, st.Lat, st.Lon, st.Elevation)
+	return 0
+}
+
+// アメダス観測所の一覧ファイル path を読み込みます。path が空の場合は組み込みの一覧を返します。
+func readAmedasTable(path string) (arcclimate.AmedasTable, error) {
+	if path == "" {
+		return arcclimate.DefaultAmedasTable()
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	table, err := arcclimate.ReadAmedasTable(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return table, nil
+}
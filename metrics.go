@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/udawtr/arcclimate-go/arcclimate"
+)
+
+// 応答時間のヒストグラムの区切り [秒]
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120}
+
+// HTTPサーバーの計測値 (/metrics で Prometheus のテキスト形式で出力)
+type metrics struct {
+	mu        sync.Mutex
+	requests  map[requestKey]uint64
+	latencies map[latencyKey]*histogram
+
+	resultHits   uint64 // 計算結果のキャッシュを使用した回数
+	resultMisses uint64 // 計算結果のキャッシュが無く計算した回数
+}
+
+// リクエストのラベル
+type requestLabels struct {
+	mode   string
+	format string
+}
+
+type latencyKey struct {
+	endpoint string
+	requestLabels
+}
+
+type requestKey struct {
+	latencyKey
+	code int
+}
+
+type histogram struct {
+	counts []uint64 // latencyBuckets の各区切り以下の件数
+	sum    float64
+	count  uint64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:  make(map[requestKey]uint64),
+		latencies: make(map[latencyKey]*histogram),
+	}
+}
+
+// リクエストの結果を記録します。
+func (m *metrics) observe(endpoint string, labels requestLabels, code int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lk := latencyKey{endpoint, labels}
+	m.requests[requestKey{lk, code}]++
+
+	h := m.latencies[lk]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(latencyBuckets))}
+		m.latencies[lk] = h
+	}
+	sec := d.Seconds()
+	for i, le := range latencyBuckets {
+		if sec <= le {
+			h.counts[i]++
+		}
+	}
+	h.sum += sec
+	h.count++
+}
+
+// 計算結果のキャッシュの使用を記録します。
+func (m *metrics) observeResultCache(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.resultHits++
+	} else {
+		m.resultMisses++
+	}
+}
+
+// 計測値を Prometheus のテキスト形式で w に書き込みます。
+func (m *metrics) write(w io.Writer, s *server) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header := func(name string, typ string, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	header("arcclimate_http_requests_total", "counter", "Number of HTTP requests by endpoint, mode, format and status code.")
+	keys := make([]requestKey, 0, len(m.requests))
+	for k := range m.requests {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	for _, k := range keys {
+		fmt.Fprintf(w, "arcclimate_http_requests_total{%s,code=\"%d\"} %d\n", k.latencyKey, k.code, m.requests[k])
+	}
+
+	header("arcclimate_http_request_duration_seconds", "histogram", "HTTP request latencies by endpoint, mode and format.")
+	lkeys := make([]latencyKey, 0, len(m.latencies))
+	for k := range m.latencies {
+		lkeys = append(lkeys, k)
+	}
+	sort.Slice(lkeys, func(i, j int) bool {
+		return lkeys[i].String() < lkeys[j].String()
+	})
+	for _, k := range lkeys {
+		h := m.latencies[k]
+		for i, le := range latencyBuckets {
+			fmt.Fprintf(w, "arcclimate_http_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", k, strconv.FormatFloat(le, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(w, "arcclimate_http_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", k, h.count)
+		fmt.Fprintf(w, "arcclimate_http_request_duration_seconds_sum{%s} %g\n", k, h.sum)
+		fmt.Fprintf(w, "arcclimate_http_request_duration_seconds_count{%s} %d\n", k, h.count)
+	}
+
+	header("arcclimate_http_requests_in_flight", "gauge", "Number of weather requests being calculated.")
+	fmt.Fprintf(w, "arcclimate_http_requests_in_flight %d\n", len(s.sem))
+
+	header("arcclimate_result_cache_requests_total", "counter", "Number of weather requests served from the result cache (hit) or calculated (miss).")
+	fmt.Fprintf(w, "arcclimate_result_cache_requests_total{result=\"hit\"} %d\n", m.resultHits)
+	fmt.Fprintf(w, "arcclimate_result_cache_requests_total{result=\"miss\"} %d\n", m.resultMisses)
+
+	st := arcclimate.ReadStats()
+
+	header("arcclimate_msm_cache_requests_total", "counter", "Number of MSM file loads served from the disk or memory cache (hit) or loaded from the source (miss).")
+	fmt.Fprintf(w, "arcclimate_msm_cache_requests_total{cache=\"disk\",result=\"hit\"} %d\n", st.MsmCacheHits)
+	fmt.Fprintf(w, "arcclimate_msm_cache_requests_total{cache=\"disk\",result=\"miss\"} %d\n", st.MsmCacheMisses)
+	fmt.Fprintf(w, "arcclimate_msm_cache_requests_total{cache=\"memory\",result=\"hit\"} %d\n", st.MsmMemoryHits)
+	fmt.Fprintf(w, "arcclimate_msm_cache_requests_total{cache=\"memory\",result=\"miss\"} %d\n", st.MsmMemoryMisses)
+
+	header("arcclimate_msm_memory_cache_entries", "gauge", "Number of MSM files held in memory.")
+	fmt.Fprintf(w, "arcclimate_msm_memory_cache_entries %d\n", s.opts.MemoryCache.Len())
+
+	header("arcclimate_msm_download_bytes_total", "counter", "Bytes of MSM files downloaded over HTTP.")
+	fmt.Fprintf(w, "arcclimate_msm_download_bytes_total %d\n", st.DownloadBytes)
+
+	header("arcclimate_gsi_api_requests_total", "counter", "Number of elevation requests to the GSI API.")
+	fmt.Fprintf(w, "arcclimate_gsi_api_requests_total %d\n", st.ElevationAPIRequests)
+
+	header("arcclimate_gsi_api_failures_total", "counter", "Number of elevation requests to the GSI API that failed with a transport or response error (fell back to the mesh elevation).")
+	fmt.Fprintf(w, "arcclimate_gsi_api_failures_total %d\n", st.ElevationAPIFailures)
+}
+
+// ラベルの文字列表現 (endpoint="...",mode="...",format="...")
+func (k latencyKey) String() string {
+	return fmt.Sprintf("endpoint=%q,mode=%q,format=%q", k.endpoint, k.mode, k.format)
+}
+
+func (k requestKey) String() string {
+	return fmt.Sprintf("%s,code=%d", k.latencyKey, k.code)
+}
+
+// 応答のステータスコードを記録する ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_metrics_write(t *testing.T) {
+	s := newTestServer(t)
+	m := s.metrics
+
+	csv := requestLabels{mode: "normal", format: "csv"}
+	m.observe("/v1/weather", csv, http.StatusOK, 30*time.Millisecond)
+	m.observe("/v1/weather", csv, http.StatusOK, 2*time.Second)
+	m.observe("/v1/weather", csv, http.StatusNotFound, 200*time.Millisecond)
+	m.observe("/v1/point", requestLabels{}, http.StatusOK, 10*time.Millisecond)
+	m.observeResultCache(true)
+	m.observeResultCache(false)
+	m.observeResultCache(false)
+
+	var buf bytes.Buffer
+	m.write(&buf, s)
+	out := buf.String()
+	lines := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		lines[line] = true
+	}
+
+	for _, want := range []string{
+		"# TYPE arcclimate_http_requests_total counter",
+		`arcclimate_http_requests_total{endpoint="/v1/weather",mode="normal",format="csv",code="200"} 2`,
+		`arcclimate_http_requests_total{endpoint="/v1/weather",mode="normal",format="csv",code="404"} 1`,
+		`arcclimate_http_requests_total{endpoint="/v1/point",mode="",format="",code="200"} 1`,
+
+		// ヒストグラムは区切り以下の累積件数
+		"# TYPE arcclimate_http_request_duration_seconds histogram",
+		`arcclimate_http_request_duration_seconds_bucket{endpoint="/v1/weather",mode="normal",format="csv",le="0.05"} 1`,
+		`arcclimate_http_request_duration_seconds_bucket{endpoint="/v1/weather",mode="normal",format="csv",le="0.25"} 2`,
+		`arcclimate_http_request_duration_seconds_bucket{endpoint="/v1/weather",mode="normal",format="csv",le="1"} 2`,
+		`arcclimate_http_request_duration_seconds_bucket{endpoint="/v1/weather",mode="normal",format="csv",le="2.5"} 3`,
+		`arcclimate_http_request_duration_seconds_bucket{endpoint="/v1/weather",mode="normal",format="csv",le="+Inf"} 3`,
+		`arcclimate_http_request_duration_seconds_sum{endpoint="/v1/weather",mode="normal",format="csv"} 2.23`,
+		`arcclimate_http_request_duration_seconds_count{endpoint="/v1/weather",mode="normal",format="csv"} 3`,
+
+		"arcclimate_http_requests_in_flight 0",
+		`arcclimate_result_cache_requests_total{result="hit"} 1`,
+		`arcclimate_result_cache_requests_total{result="miss"} 2`,
+		"arcclimate_msm_memory_cache_entries 0",
+		"# TYPE arcclimate_gsi_api_failures_total counter",
+	} {
+		assert.True(t, lines[want], want)
+	}
+
+	// ラベルの並びは一定
+	var again bytes.Buffer
+	m.write(&again, s)
+	assert.Equal(t, out, again.String())
+}
+
+// /metrics はハンドラが記録したステータスコードを出力する
+func Test_handleMetrics(t *testing.T) {
+	h := newTestServer(t).handler()
+
+	serveRequest(h, http.MethodGet, "/v1/weather?lat=35.658&lon=139.741&format=xml")
+	serveRequest(h, http.MethodGet, "/v1/point?lat=35.658&lon=139.741")
+
+	rec := serveRequest(h, http.MethodGet, "/metrics")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"))
+	out := rec.Body.String()
+	assert.Contains(t, out, `arcclimate_http_requests_total{endpoint="/v1/weather",mode="",format="",code="400"} 1`)
+	assert.Contains(t, out, `arcclimate_http_requests_total{endpoint="/v1/point",mode="",format="",code="200"} 1`)
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serveRequest(h, http.MethodPost, "/metrics").Code)
+}
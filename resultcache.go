@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/udawtr/arcclimate-go/arcclimate"
+)
+
+// 計算結果のキャッシュの版。計算方法や出力形式を変更した場合は更新し、以前の計算結果を使用しないようにします。
+const resultCacheVersion = "1"
+
+// 計算結果に影響するパラメータ (計算結果のキャッシュのキー)
+type resultKey struct {
+	Version string `json:"version"` // resultCacheVersion
+	Data    string `json:"data"`    // MSMデータの版 (取得元と --data_version)
+
+	Lat              float64 `json:"lat"`
+	Lon              float64 `json:"lon"`
+	StartYear        int     `json:"start_year"`
+	EndYear          int     `json:"end_year"`
+	Mode             string  `json:"mode"`
+	SeparationMethod string  `json:"separation_method"`
+	ElevationMode    string  `json:"elevation_mode"`
+	ElevationSource  string  `json:"elevation_source,omitempty"`
+	UseEst           bool    `json:"use_est"`
+	Offline          bool    `json:"offline"`
+	ElevationWarning float64 `json:"elevation_warning"`
+	Interpolation    string  `json:"interpolation"`
+	IDWPower         float64 `json:"idw_power"`
+	Neighborhood     int     `json:"neighborhood"`
+	ExcludeSea       bool    `json:"exclude_sea"`
+	LapseRate        string  `json:"lapse_rate"`
+	Format           string  `json:"format"`
+
+	Wind           *arcclimate.WindConversion `json:"wind,omitempty"`
+	WindDirection  *arcclimate.WindDirection  `json:"wind_direction,omitempty"`
+	WindCalm       float64                    `json:"wind_calm,omitempty"`
+	Psychrometrics bool                       `json:"psychrometrics,omitempty"`
+}
+
+// 計算条件 opts, 出力形式 format, MSMデータの版 data の計算結果のキー
+func newResultKey(opts arcclimate.Options, format string, data string) resultKey {
+	// 逆距離加重のべき数は同じ計算結果となる値をまとめる (0 は既定値の1、逆距離加重以外では使用しない)
+	interp, power := opts.Interpolation, opts.IDWPower
+	if interp == "" {
+		interp = arcclimate.InterpolationIDW
+	}
+	if interp != arcclimate.InterpolationIDW {
+		power = 0
+	} else if power == 0 {
+		power = 1
+	}
+
+	return resultKey{
+		Version:          resultCacheVersion,
+		Data:             data,
+		Lat:              opts.Lat,
+		Lon:              opts.Lon,
+		StartYear:        opts.StartYear,
+		EndYear:          opts.EndYear,
+		Mode:             string(opts.Mode),
+		SeparationMethod: string(opts.SeparationMethod),
+		ElevationMode:    string(opts.ElevationMode),
+		ElevationSource:  elevationSourceName(opts.ElevationProvider),
+		UseEst:           opts.UseEst,
+		Offline:          opts.Offline,
+		ElevationWarning: opts.ElevationWarning,
+		Interpolation:    string(interp),
+		IDWPower:         power,
+		Neighborhood:     opts.Neighborhood,
+		ExcludeSea:       opts.ExcludeSea,
+		LapseRate:        lapseRateName(opts.LapseRate),
+		Wind:             opts.Wind,
+		WindDirection:    opts.WindDirection,
+		WindCalm:         opts.WindCalm,
+		Psychrometrics:   opts.Psychrometrics,
+		Format:           format,
+	}
+}
+
+// 標高の取得元 p の名前 (nil の場合は空)
+func elevationSourceName(p arcclimate.ElevationProvider) string {
+	if p == nil {
+		return ""
+	}
+	return p.String()
+}
+
+// 気温減率 lapse の名前 (nil の場合は既定の気温減率)
+func lapseRateName(lapse arcclimate.LapseRate) string {
+	if lapse == nil {
+		lapse = arcclimate.DefaultLapseRate
+	}
+	return lapse.String()
+}
+
+// キーのハッシュ (SHA-256)
+func (k resultKey) hash() string {
+	b, _ := json.Marshal(k)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// MSMデータの版を表す文字列 (取得元と、運用者が指定する版 version)
+func dataVersion(opts arcclimate.Options, version string) string {
+	srcs := []string{}
+	if len(opts.Archives) > 0 {
+		for _, a := range opts.Archives {
+			srcs = append(srcs, a.Name+"="+fmt.Sprint(a.Source))
+		}
+		srcs = append(srcs, string(opts.Precedence))
+	} else if opts.Source != nil {
+		srcs = append(srcs, fmt.Sprint(opts.Source))
+	} else {
+		srcs = append(srcs, arcclimate.DefaultMsmURL)
+	}
+	return strings.Join(srcs, ",") + ";" + version
+}
+
+// 計算結果(出力内容)をキーのハッシュをファイル名としてディレクトリ dir に保存するキャッシュ
+type resultCache struct {
+	dir string
+}
+
+// キー key の計算結果のパス
+func (c *resultCache) path(key resultKey) string {
+	h := key.hash()
+	return filepath.Join(c.dir, h[:2], h+"."+strings.ToLower(key.Format))
+}
+
+// キー key の計算結果を読み込みます。
+func (c *resultCache) get(key resultKey) ([]byte, bool) {
+	b, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	return b, true
+}
+
+// キー key の計算結果 b を保存します。
+func (c *resultCache) put(key resultKey, b []byte) error {
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// 書き込み途中のファイルを読まないよう、一時ファイルに書き込んでから置き換える
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(b)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/udawtr/arcclimate-go/arcclimate"
+)
+
+func testResultOptions() arcclimate.Options {
+	opts := arcclimate.NewOptions(35.658, 139.741)
+	opts.StartYear, opts.EndYear = 2011, 2011
+	return opts
+}
+
+func Test_resultKey_hash(t *testing.T) {
+	opts := testResultOptions()
+	key := newResultKey(opts, "CSV", "data")
+
+	// 同じ計算条件のキーは同じハッシュ
+	assert.Equal(t, key.hash(), newResultKey(testResultOptions(), "CSV", "data").hash())
+	assert.Len(t, key.hash(), 64)
+
+	// 計算結果に影響する条件が異なる場合は異なるハッシュ
+	wind := arcclimate.WindConversion{Height: 10, Profile: arcclimate.WindProfilePower, Terrain: arcclimate.TerrainIII}
+	direction := arcclimate.WindDirection{Sectors: 8}
+	for name, change := range map[string]func(o *arcclimate.Options){
+		"lat":            func(o *arcclimate.Options) { o.Lat = 35.659 },
+		"lon":            func(o *arcclimate.Options) { o.Lon = 139.742 },
+		"start_year":     func(o *arcclimate.Options) { o.StartYear = 2012; o.EndYear = 2012 },
+		"mode":           func(o *arcclimate.Options) { o.Mode = arcclimate.ModeEA },
+		"separation":     func(o *arcclimate.Options) { o.SeparationMethod = arcclimate.SeparationErbs },
+		"elevation":      func(o *arcclimate.Options) { o.ElevationMode = arcclimate.ElevationMesh },
+		"use_est":        func(o *arcclimate.Options) { o.UseEst = !o.UseEst },
+		"interpolation":  func(o *arcclimate.Options) { o.Interpolation = arcclimate.InterpolationBilinear },
+		"idw_power":      func(o *arcclimate.Options) { o.IDWPower = 3 },
+		"neighborhood":   func(o *arcclimate.Options) { o.Neighborhood = 16 },
+		"exclude_sea":    func(o *arcclimate.Options) { o.ExcludeSea = true },
+		"wind":           func(o *arcclimate.Options) { o.Wind = &wind },
+		"wind_direction": func(o *arcclimate.Options) { o.WindDirection = &direction },
+		"wind_calm":      func(o *arcclimate.Options) { o.WindCalm = 0.2 },
+		"psychrometrics": func(o *arcclimate.Options) { o.Psychrometrics = true },
+	} {
+		changed := testResultOptions()
+		change(&changed)
+		assert.NotEqual(t, key.hash(), newResultKey(changed, "CSV", "data").hash(), name)
+	}
+	assert.NotEqual(t, key.hash(), newResultKey(opts, "EPW", "data").hash())
+	assert.NotEqual(t, key.hash(), newResultKey(opts, "CSV", "other").hash())
+
+	// 計算結果に影響しない条件は無視する
+	unrelated := testResultOptions()
+	unrelated.UseCache, unrelated.SaveCache = false, false
+	unrelated.MemoryCache = arcclimate.NewMsmMemoryCache(1)
+	assert.Equal(t, key.hash(), newResultKey(unrelated, "CSV", "data").hash())
+
+	// 逆距離加重のべき数 0 は既定値の1、逆距離加重以外ではべき数を無視する
+	power := testResultOptions()
+	power.IDWPower = 0
+	assert.Equal(t, key.hash(), newResultKey(power, "CSV", "data").hash())
+	power.Interpolation = ""
+	assert.Equal(t, key.hash(), newResultKey(power, "CSV", "data").hash())
+	bilinear := testResultOptions()
+	bilinear.Interpolation = arcclimate.InterpolationBilinear
+	power.Interpolation, power.IDWPower = arcclimate.InterpolationBilinear, 3
+	assert.Equal(t, newResultKey(bilinear, "CSV", "data").hash(), newResultKey(power, "CSV", "data").hash())
+}
+
+func Test_dataVersion(t *testing.T) {
+	opts := testResultOptions()
+	assert.Equal(t, arcclimate.DefaultMsmURL+";", dataVersion(opts, ""))
+	assert.Equal(t, arcclimate.DefaultMsmURL+";2024-01", dataVersion(opts, "2024-01"))
+
+	opts.Source = arcclimate.NewDirMsmSource("/data/msm")
+	assert.NotEqual(t, dataVersion(testResultOptions(), "v1"), dataVersion(opts, "v1"))
+}
+
+func Test_resultCache(t *testing.T) {
+	c := &resultCache{dir: t.TempDir()}
+	key := newResultKey(testResultOptions(), "EPW", "data")
+
+	// 保存先はハッシュの先頭2文字のディレクトリ、拡張子は出力形式
+	h := key.hash()
+	assert.Equal(t, filepath.Join(c.dir, h[:2], h+".epw"), c.path(key))
+
+	_, ok := c.get(key)
+	assert.False(t, ok)
+
+	assert.NoError(t, c.put(key, []byte("epw")))
+	b, ok := c.get(key)
+	assert.True(t, ok)
+	assert.Equal(t, "epw", string(b))
+
+	// 上書きしても一時ファイルは残らない
+	assert.NoError(t, c.put(key, []byte("epw2")))
+	b, _ = c.get(key)
+	assert.Equal(t, "epw2", string(b))
+	entries, err := os.ReadDir(filepath.Dir(c.path(key)))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.False(t, strings.Contains(entries[0].Name(), ".tmp"))
+}
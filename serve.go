@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/akamensky/argparse"
+	"github.com/udawtr/arcclimate-go/arcclimate"
+)
+
+// serve サブコマンド: 補間計算・標準年の作成をHTTPのAPIとして提供
+func runServe(args []string) int {
+	parser := argparse.NewParser("arcclimate-go serve", "Serves interpolation and typical-year generation as a REST API")
+
+	addr := parser.String("", "addr", &argparse.Options{
+		Default: ":8080",
+		Help:    "待ち受けるアドレス"})
+
+	timeout := parser.Int("", "timeout", &argparse.Options{
+		Default: 120,
+		Help:    "1リクエストの計算時間の上限 [秒] (順番待ちの時間を含む)"})
+
+	maxConcurrent := parser.Int("", "max_concurrent", &argparse.Options{
+		Default: 4,
+		Help:    "同時に計算するリクエスト数の上限"})
+
+	memoryCache := parser.Int("", "memory_cache", &argparse.Options{
+		Default: 64,
+		Help:    "メモリ上に保持するMSMファイル数の上限 (0の場合は無制限)"})
+
+	resultCacheDir := parser.String("", "result_cache", &argparse.Options{
+		Default: "",
+		Help:    "計算結果を保存・再利用するディレクトリ (空の場合は保存しない)"})
+
+	dataVersionFlag := parser.String("", "data_version", &argparse.Options{
+		Default: "",
+		Help:    "MSMデータの版 (取得元のデータを更新した場合に変更し、以前の計算結果を使用しないようにする)"})
+
+	// リクエストで指定しない場合の計算条件
+	flags := addOptionFlags(&parser.Command)
+
+	if err := parser.Parse(args); err != nil {
+		fmt.Fprint(os.Stderr, parser.Usage(err))
+		return 2
+	}
+	if *timeout <= 0 || *maxConcurrent <= 0 || *memoryCache < 0 {
+		fmt.Fprintln(os.Stderr, "Error: --timeout and --max_concurrent must be positive, --memory_cache must not be negative")
+		return 2
+	}
+
+	opts, code := flags.options()
+	if code != 0 {
+		return code
+	}
+	opts.MemoryCache = arcclimate.NewMsmMemoryCache(*memoryCache)
+
+	s := &server{
+		opts:    opts,
+		timeout: time.Duration(*timeout) * time.Second,
+		sem:     make(chan struct{}, *maxConcurrent),
+		metrics: newMetrics(),
+		data:    dataVersion(opts, *dataVersionFlag),
+	}
+	if *resultCacheDir != "" {
+		s.results = &resultCache{dir: *resultCacheDir}
+	}
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           s.handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// SIGINT, SIGTERM で実行中のリクエストの完了を待って終了する
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), s.timeout)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+
+	log.Printf("HTTPサーバー開始: %s", *addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	log.Printf("HTTPサーバー終了")
+	return 0
+}
+
+// HTTPサーバー
+type server struct {
+	opts    arcclimate.Options // リクエストで指定しない場合の計算条件
+	timeout time.Duration      // 1リクエストの計算時間の上限
+	sem     chan struct{}      // 同時に計算するリクエスト数の制限
+	metrics *metrics
+	results *resultCache // 計算結果のキャッシュ (nil の場合は使用しない)
+	data    string       // MSMデータの版 (計算結果のキャッシュのキーに使用)
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/weather", s.instrument("/v1/weather", s.handleWeather))
+	mux.HandleFunc("/v1/point", s.instrument("/v1/point", s.handlePoint))
+	mux.HandleFunc("/healthz", s.handleHealth)
+	mux.HandleFunc("/metrics", s.handleMetrics)
+	return mux
+}
+
+// リクエストの件数と応答時間を記録するハンドラを返します。
+// ハンドラ h は計測値のラベル(計算モード・出力形式)を labels に設定します。
+func (s *server) instrument(endpoint string, h func(w http.ResponseWriter, r *http.Request, labels *requestLabels)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		var labels requestLabels
+		h(rec, r, &labels)
+		s.metrics.observe(endpoint, labels, rec.status, time.Since(start))
+	}
+}
+
+// 出力形式ごとの Content-Type
+var contentTypes = map[string]string{
+	"CSV":  "text/csv; charset=utf-8",
+	"EPW":  "text/plain; charset=utf-8",
+	"HAS":  "text/plain; charset=utf-8",
+	"JSON": "application/json",
+}
+
+// GET /v1/weather?lat=&lon=&mode=&separation=&format=&start_year=&end_year=&elevation=
+// 気象データを出力形式 format (csv, epw, has or json) で返します。
+// 計算結果のキャッシュがある場合は、計算せずに返します。
+func (s *server) handleWeather(w http.ResponseWriter, r *http.Request, labels *requestLabels) {
+	if !allowGet(w, r) {
+		return
+	}
+
+	opts, format, err := s.weatherOptions(r.URL.Query())
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, arcclimate.ErrOutOfDomain) {
+			status = http.StatusUnprocessableEntity
+		}
+		writeError(w, status, err)
+		return
+	}
+	labels.mode, labels.format = string(opts.Mode), strings.ToLower(format)
+
+	// 計算結果のキャッシュ
+	key := newResultKey(opts, format, s.data)
+	if s.results != nil {
+		if b, ok := s.results.get(key); ok {
+			s.metrics.observeResultCache(true)
+			w.Header().Set("X-Result-Cache", "hit")
+			writeBody(w, r, format, b)
+			return
+		}
+		s.metrics.observeResultCache(false)
+		w.Header().Set("X-Result-Cache", "miss")
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+
+	// 同時に計算するリクエスト数の制限
+	select {
+	case s.sem <- struct{}{}:
+		defer func() { <-s.sem }()
+	case <-ctx.Done():
+		writeError(w, http.StatusServiceUnavailable, errors.New("server is busy"))
+		return
+	}
+
+	res, err := arcclimate.InterpolateWithOptions(ctx, opts)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := writeTarget(&buf, res, format, opts.Lat, opts.Lon); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	// 国土地理院のAPIから標高を取得できなかった場合は、次回に再度計算するため保存しない
+	if s.results != nil && res.Metadata.ElevationMode == expectedElevationMode(opts) {
+		if err := s.results.put(key, buf.Bytes()); err != nil {
+			log.Printf("計算結果保存失敗 %v", err)
+		}
+	}
+
+	writeBody(w, r, format, buf.Bytes())
+}
+
+// 計算条件 opts で標高を取得できた場合の標高の判定方法
+func expectedElevationMode(opts arcclimate.Options) arcclimate.ElevationMode {
+	if opts.ElevationProvider != nil {
+		return opts.ElevationProvider.Mode()
+	}
+	return opts.ElevationMode
+}
+
+// 出力形式 format の内容 b を返します。
+func writeBody(w http.ResponseWriter, r *http.Request, format string, b []byte) {
+	w.Header().Set("Content-Type", contentTypes[format])
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(b)
+}
+
+// GET /v1/point?lat=&lon=&elevation=&interpolation=&idw_power=&neighborhood=&exclude_sea=
+// 推計対象地点の補間に使用する周囲のMSM地点、重み、標高を /v1/weather と同じ計算条件で返します。
+func (s *server) handlePoint(w http.ResponseWriter, r *http.Request, labels *requestLabels) {
+	if !allowGet(w, r) {
+		return
+	}
+
+	opts, err := s.pointOptions(r.URL.Query())
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, arcclimate.ErrOutOfDomain) {
+			status = http.StatusUnprocessableEntity
+		}
+		writeError(w, status, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+
+	p, err := arcclimate.NewPointInfoWithOptions(ctx, opts)
+	if err != nil {
+		writeError(w, errorStatus(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, p)
+}
+
+// クエリ q から /v1/point の計算条件を作成します。
+func (s *server) pointOptions(q url.Values) (arcclimate.Options, error) {
+	opts := s.opts
+	if err := checkParams(q, "lat", "lon", "elevation", "interpolation", "idw_power", "neighborhood", "exclude_sea"); err != nil {
+		return opts, err
+	}
+
+	var err error
+	if opts.Lat, opts.Lon, err = latLonParams(q); err != nil {
+		return opts, err
+	}
+	if v := q.Get("elevation"); v != "" {
+		if opts.ElevationMode, err = arcclimate.ParseElevationMode(v); err != nil {
+			return opts, err
+		}
+		opts.ElevationProvider = nil
+	}
+	if err := interpolationParams(q, &opts); err != nil {
+		return opts, err
+	}
+
+	// オフラインの場合は常に3次メッシュの平均標高を使用する
+	if opts.Offline {
+		opts.ElevationMode = arcclimate.ElevationMesh
+	}
+
+	if err := opts.Validate(); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// GET /metrics
+// 計測値を Prometheus のテキスト形式で返します。
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	var buf bytes.Buffer
+	s.metrics.write(&buf, s)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// GET /healthz
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":         "ok",
+		"running":        len(s.sem),
+		"max_concurrent": cap(s.sem),
+		"memory_cache":   s.opts.MemoryCache.Len(),
+	})
+}
+
+// クエリ q の wind_height, wind_profile, terrain, roughness から風速の換算の条件を作成します。
+// wind_height が無い場合はサーバーの既定の条件 def を返します。
+func windParams(q url.Values, def *arcclimate.WindConversion) (*arcclimate.WindConversion, error) {
+	if q.Get("wind_height") == "" {
+		if q.Get("wind_profile") != "" || q.Get("terrain") != "" || q.Get("roughness") != "" {
+			return nil, fmt.Errorf("wind_profile, terrain and roughness require wind_height")
+		}
+		return def, nil
+	}
+
+	wind := arcclimate.WindConversion{Profile: arcclimate.WindProfilePower, Terrain: arcclimate.TerrainIII}
+	if def != nil {
+		wind = *def
+	}
+	var err error
+	if wind.Height, err = strconv.ParseFloat(q.Get("wind_height"), 64); err != nil {
+		return nil, fmt.Errorf("invalid wind_height %q", q.Get("wind_height"))
+	}
+	if v := q.Get("wind_profile"); v != "" {
+		if wind.Profile, err = arcclimate.ParseWindProfile(v); err != nil {
+			return nil, err
+		}
+		if wind.Profile == arcclimate.WindProfilePower {
+			wind.Roughness = 0
+		}
+	}
+	if v := q.Get("terrain"); v != "" {
+		if wind.Terrain, err = arcclimate.ParseTerrainCategory(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := q.Get("roughness"); v != "" {
+		if wind.Roughness, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("invalid roughness %q", v)
+		}
+	}
+	return &wind, nil
+}
+
+// クエリ q の interpolation, idw_power, neighborhood, exclude_sea を計算条件 opts に設定します。
+func interpolationParams(q url.Values, opts *arcclimate.Options) error {
+	var err error
+	if v := q.Get("interpolation"); v != "" {
+		if opts.Interpolation, err = arcclimate.ParseInterpolationMethod(v); err != nil {
+			return err
+		}
+	}
+	if v := q.Get("idw_power"); v != "" {
+		if opts.IDWPower, err = strconv.ParseFloat(v, 64); err != nil || !(opts.IDWPower > 0) || math.IsInf(opts.IDWPower, 0) {
+			return fmt.Errorf("invalid idw_power %q", v)
+		}
+	}
+	if v := q.Get("neighborhood"); v != "" {
+		if opts.Neighborhood, err = strconv.Atoi(v); err != nil {
+			return fmt.Errorf("invalid neighborhood %q (want 4 or 16)", v)
+		}
+	}
+	if v := q.Get("exclude_sea"); v != "" {
+		if opts.ExcludeSea, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid exclude_sea %q (want true or false)", v)
+		}
+	}
+	return nil
+}
+
+// クエリ q から /v1/weather の計算条件と出力形式を作成します。
+func (s *server) weatherOptions(q url.Values) (arcclimate.Options, string, error) {
+	opts := s.opts
+	if err := checkParams(q, "lat", "lon", "mode", "separation", "format", "start_year", "end_year", "elevation", "interpolation", "idw_power", "neighborhood", "exclude_sea", "lapse_rate", "wind_height", "wind_profile", "terrain", "roughness", "wind_direction", "wind_projection", "wind_calm", "psychrometrics"); err != nil {
+		return opts, "", err
+	}
+
+	var err error
+	if opts.Lat, opts.Lon, err = latLonParams(q); err != nil {
+		return opts, "", err
+	}
+	if v := q.Get("mode"); v != "" {
+		if opts.Mode, err = arcclimate.ParseMode(v); err != nil {
+			return opts, "", err
+		}
+	}
+	if v := q.Get("separation"); v != "" {
+		if opts.SeparationMethod, err = arcclimate.ParseSeparationMethod(v); err != nil {
+			return opts, "", err
+		}
+	}
+	if v := q.Get("elevation"); v != "" {
+		if opts.ElevationMode, err = arcclimate.ParseElevationMode(v); err != nil {
+			return opts, "", err
+		}
+		opts.ElevationProvider = nil
+	}
+	if err := interpolationParams(q, &opts); err != nil {
+		return opts, "", err
+	}
+	if v := q.Get("lapse_rate"); v != "" {
+		if opts.LapseRate, err = arcclimate.ParseLapseRate(v); err != nil {
+			return opts, "", err
+		}
+	}
+	if opts.Wind, err = windParams(q, opts.Wind); err != nil {
+		return opts, "", err
+	}
+	if v := q.Get("wind_direction"); v != "" {
+		projection := false
+		if p := q.Get("wind_projection"); p != "" {
+			if projection, err = strconv.ParseBool(p); err != nil {
+				return opts, "", fmt.Errorf("invalid wind_projection %q (want true or false)", p)
+			}
+		}
+		mode, err := arcclimate.ParseWindDirection(v, projection)
+		if err != nil {
+			return opts, "", err
+		}
+		opts.WindDirection = &mode
+	} else if q.Get("wind_projection") != "" {
+		return opts, "", fmt.Errorf("wind_projection requires wind_direction")
+	}
+	if v := q.Get("psychrometrics"); v != "" {
+		if opts.Psychrometrics, err = strconv.ParseBool(v); err != nil {
+			return opts, "", fmt.Errorf("invalid psychrometrics %q (want true or false)", v)
+		}
+	}
+	if v := q.Get("wind_calm"); v != "" {
+		if opts.WindCalm, err = strconv.ParseFloat(v, 64); err != nil {
+			return opts, "", fmt.Errorf("invalid wind_calm %q", v)
+		}
+	}
+	for _, p := range []struct {
+		name  string
+		value *int
+	}{{"start_year", &opts.StartYear}, {"end_year", &opts.EndYear}} {
+		if v := q.Get(p.name); v != "" {
+			if *p.value, err = strconv.Atoi(v); err != nil {
+				return opts, "", fmt.Errorf("invalid %s %q", p.name, v)
+			}
+		}
+	}
+
+	format := "CSV"
+	if v := q.Get("format"); v != "" {
+		format = strings.ToUpper(v)
+		if !isOutputFormat(format) {
+			return opts, "", fmt.Errorf("unknown format %q (want csv, epw, has or json)", v)
+		}
+	}
+
+	// オフラインの場合は常に3次メッシュの平均標高を使用する
+	if opts.Offline {
+		opts.ElevationMode = arcclimate.ElevationMesh
+	}
+
+	if err := opts.Validate(); err != nil {
+		return opts, "", err
+	}
+	return opts, format, nil
+}
+
+// クエリ q に names 以外のパラメータや重複したパラメータが無いか確認します。
+func checkParams(q url.Values, names ...string) error {
+	for key, values := range q {
+		known := false
+		for _, name := range names {
+			if key == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown parameter %q", key)
+		}
+		if len(values) > 1 {
+			return fmt.Errorf("parameter %q is given more than once", key)
+		}
+	}
+	return nil
+}
+
+// クエリ q の緯度 lat, 経度 lon (必須)
+func latLonParams(q url.Values) (float64, float64, error) {
+	var v [2]float64
+	for i, name := range []string{"lat", "lon"} {
+		s := q.Get(name)
+		if s == "" {
+			return 0, 0, fmt.Errorf("parameter %q is required", name)
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid %s %q", name, s)
+		}
+		v[i] = f
+	}
+	return v[0], v[1], nil
+}
+
+// エラー err に対応するHTTPステータス
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, arcclimate.ErrMsmNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, arcclimate.ErrOutOfPeriod), errors.Is(err, arcclimate.ErrOutOfDomain):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
+
+// GET (または HEAD) 以外のリクエストを拒否します。
+func allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD")
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s is not allowed", r.Method))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	if status >= http.StatusInternalServerError {
+		log.Printf("HTTP %d: %v", status, err)
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/udawtr/arcclimate-go/arcclimate"
+)
+
+// テスト用のMSMファイル(東京付近のみ)を使用するオフラインのサーバー
+func newTestServer(t *testing.T) *server {
+	msmDir := t.TempDir()
+	writeTestMsm(t, msmDir, 35.658, 139.741)
+
+	opts := arcclimate.NewOptions(0, 0)
+	opts.StartYear, opts.EndYear = 2011, 2011
+	opts.Source = arcclimate.NewDirMsmSource(msmDir)
+	opts.Offline = true
+	opts.ElevationMode = arcclimate.ElevationMesh
+	opts.UseCache, opts.SaveCache = false, false
+	opts.MemoryCache = arcclimate.NewMsmMemoryCache(4)
+
+	return &server{
+		opts:    opts,
+		timeout: time.Minute,
+		sem:     make(chan struct{}, 2),
+		metrics: newMetrics(),
+		data:    dataVersion(opts, "test"),
+	}
+}
+
+func serveRequest(h http.Handler, method string, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func Test_handleWeather(t *testing.T) {
+	s := newTestServer(t)
+	h := s.handler()
+
+	rec := serveRequest(h, http.MethodGet, "/v1/weather?lat=35.658&lon=139.741")
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(rec.Body.String(), "date,TMP,MR,"))
+	assert.Empty(t, rec.Header().Get("X-Result-Cache"))
+
+	// 出力形式は大文字・小文字を区別しない
+	rec = serveRequest(h, http.MethodGet, "/v1/weather?lat=35.658&lon=139.741&format=Json")
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.True(t, json.Valid(rec.Body.Bytes()))
+
+	// HEAD は本文を返さない
+	rec = serveRequest(h, http.MethodHead, "/v1/weather?lat=35.658&lon=139.741")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Content-Length"))
+	assert.Zero(t, rec.Body.Len())
+
+	for _, c := range []struct {
+		method string
+		target string
+		status int
+	}{
+		{http.MethodGet, "/v1/weather?lon=139.741", http.StatusBadRequest},
+		{http.MethodGet, "/v1/weather?lat=north&lon=139.741", http.StatusBadRequest},
+		{http.MethodGet, "/v1/weather?lat=35.658&lon=139.741&height=3", http.StatusBadRequest},
+		{http.MethodGet, "/v1/weather?lat=35.658&lon=139.741&mode=EA&mode=normal", http.StatusBadRequest},
+		{http.MethodGet, "/v1/weather?lat=35.658&lon=139.741&format=xml", http.StatusBadRequest},
+		{http.MethodGet, "/v1/weather?lat=35.658&lon=139.741&mode=typical", http.StatusBadRequest},
+		{http.MethodGet, "/v1/weather?lat=35.658&lon=139.741&wind_profile=log", http.StatusBadRequest},
+		{http.MethodGet, "/v1/weather?lat=35.658&lon=139.741&start_year=2012&end_year=2011", http.StatusBadRequest},
+		{http.MethodGet, "/v1/weather?lat=35.658&lon=139.741&idw_power=0", http.StatusBadRequest},
+		{http.MethodGet, "/v1/weather?lat=35.658&lon=139.741&idw_power=-1", http.StatusBadRequest},
+		{http.MethodGet, "/v1/weather?lat=35.658&lon=139.741&idw_power=NaN", http.StatusBadRequest},
+		{http.MethodGet, "/v1/weather?lat=10&lon=139.741", http.StatusUnprocessableEntity},
+		{http.MethodGet, "/v1/weather?lat=43.06&lon=141.35", http.StatusNotFound}, // MSMファイルが無い
+		{http.MethodPost, "/v1/weather?lat=35.658&lon=139.741", http.StatusMethodNotAllowed},
+	} {
+		rec := serveRequest(h, c.method, c.target)
+		assert.Equal(t, c.status, rec.Code, "%s %s: %s", c.method, c.target, rec.Body.String())
+
+		var body map[string]string
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body), c.target)
+		assert.NotEmpty(t, body["error"], c.target)
+	}
+	assert.Equal(t, "GET, HEAD", serveRequest(h, http.MethodPost, "/v1/weather").Header().Get("Allow"))
+}
+
+// 計算結果のキャッシュ
+func Test_handleWeather_ResultCache(t *testing.T) {
+	s := newTestServer(t)
+	s.results = &resultCache{dir: t.TempDir()}
+	h := s.handler()
+
+	first := serveRequest(h, http.MethodGet, "/v1/weather?lat=35.658&lon=139.741&format=epw")
+	assert.Equal(t, http.StatusOK, first.Code, first.Body.String())
+	assert.Equal(t, "miss", first.Header().Get("X-Result-Cache"))
+
+	second := serveRequest(h, http.MethodGet, "/v1/weather?format=EPW&lon=139.741&lat=35.658")
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, "hit", second.Header().Get("X-Result-Cache"))
+	assert.Equal(t, first.Body.String(), second.Body.String())
+
+	// 計算条件が異なる場合は計算する
+	other := serveRequest(h, http.MethodGet, "/v1/weather?lat=35.658&lon=139.741&format=epw&mode=EA")
+	assert.Equal(t, "miss", other.Header().Get("X-Result-Cache"))
+
+	assert.Equal(t, uint64(1), s.metrics.resultHits)
+	assert.Equal(t, uint64(2), s.metrics.resultMisses)
+}
+
+func Test_handlePoint(t *testing.T) {
+	h := newTestServer(t).handler()
+
+	rec := serveRequest(h, http.MethodGet, "/v1/point?lat=35.658&lon=139.741")
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var p arcclimate.PointInfo
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	assert.Equal(t, arcclimate.InterpolationIDW, p.Interpolation)
+	assert.Equal(t, arcclimate.Neighborhood4, p.Neighborhood)
+	assert.Len(t, p.MsmWeights, 4)
+
+	// /v1/weather と同じ計算条件で補間する
+	rec = serveRequest(h, http.MethodGet, "/v1/point?lat=35.658&lon=139.741&interpolation=bicubic&neighborhood=16")
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	assert.Equal(t, arcclimate.InterpolationBicubic, p.Interpolation)
+	assert.Equal(t, arcclimate.Neighborhood16, p.Neighborhood)
+	assert.Equal(t, arcclimate.RequiredMsmListN(35.658, 139.741, arcclimate.Neighborhood16), p.MsmFiles)
+	assert.Len(t, p.MsmWeights, 16)
+
+	// サーバーの計算条件を既定値とする
+	s := newTestServer(t)
+	s.opts.Neighborhood = arcclimate.Neighborhood16
+	s.opts.ExcludeSea = true
+	rec = serveRequest(s.handler(), http.MethodGet, "/v1/point?lat=35.658&lon=139.741")
+	assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	assert.Equal(t, arcclimate.Neighborhood16, p.Neighborhood)
+	assert.Len(t, p.MsmWeights, 16)
+
+	assert.Equal(t, http.StatusBadRequest, serveRequest(h, http.MethodGet, "/v1/point?lat=35.658").Code)
+	assert.Equal(t, http.StatusBadRequest, serveRequest(h, http.MethodGet, "/v1/point?lat=35.658&lon=139.741&mode=EA").Code)
+	assert.Equal(t, http.StatusBadRequest, serveRequest(h, http.MethodGet, "/v1/point?lat=35.658&lon=139.741&elevation=sea").Code)
+	assert.Equal(t, http.StatusBadRequest, serveRequest(h, http.MethodGet, "/v1/point?lat=35.658&lon=139.741&interpolation=bicubic").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, serveRequest(h, http.MethodGet, "/v1/point?lat=10&lon=139.741").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serveRequest(h, http.MethodDelete, "/v1/point?lat=35.658&lon=139.741").Code)
+}
+
+func Test_handleHealth(t *testing.T) {
+	h := newTestServer(t).handler()
+
+	rec := serveRequest(h, http.MethodGet, "/healthz")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, "ok", body["status"])
+	assert.Equal(t, 0.0, body["running"])
+	assert.Equal(t, 2.0, body["max_concurrent"])
+
+	assert.Equal(t, http.StatusMethodNotAllowed, serveRequest(h, http.MethodPost, "/healthz").Code)
+}
+
+func Test_errorStatus(t *testing.T) {
+	for _, c := range []struct {
+		err    error
+		status int
+	}{
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{fmt.Errorf("load: %w", context.Canceled), http.StatusServiceUnavailable},
+		{fmt.Errorf("load: %w", arcclimate.ErrMsmNotFound), http.StatusNotFound},
+		{fmt.Errorf("load: %w", arcclimate.ErrOutOfPeriod), http.StatusUnprocessableEntity},
+		{fmt.Errorf("load: %w", arcclimate.ErrOutOfDomain), http.StatusUnprocessableEntity},
+		{errors.New("broken"), http.StatusInternalServerError},
+	} {
+		assert.Equal(t, c.status, errorStatus(c.err), c.err.Error())
+	}
+}